@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"nhooyr.io/websocket"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StatusAccessRequired = websocket.StatusCode(4001)
+	StatusAccessDenied   = websocket.StatusCode(4003)
+	StatusInviteExpired  = websocket.StatusCode(4008)
+)
+
+var ErrInviteInvalid = errors.New("invalid invite")
+var ErrInviteExpired = errors.New("invite has expired")
+
+type AccessConfig struct {
+	Password      string
+	RoomPasswords map[string]string
+
+	// Empty disables invites.
+	InviteSecret   string
+	InviteOnly     bool
+	InviteLifetime time.Duration
+
+	TrustedProxies []*net.IPNet
+}
+
+func defaultAccessConfig() AccessConfig {
+	return AccessConfig{
+		RoomPasswords:  map[string]string{},
+		InviteLifetime: DefaultInviteLifetime,
+	}
+}
+
+func (config AccessConfig) roomPassword(roomID string) string {
+	if password, has := config.RoomPasswords[roomID]; has {
+		return password
+	}
+
+	return config.Password
+}
+
+func (config AccessConfig) Restricted(roomID string) bool {
+	return config.roomPassword(roomID) != "" || config.InviteOnly
+}
+
+func (config AccessConfig) invitesEnabled() bool {
+	return config.InviteSecret != ""
+}
+
+func (config AccessConfig) inviteSignature(roomID string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, []byte(config.InviteSecret))
+	mac.Write([]byte(roomID + "\n" + strconv.FormatInt(expiresAt, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (config AccessConfig) CreateInvite(roomID string, expiresAt time.Time) string {
+	expiry := expiresAt.Unix()
+	return strconv.FormatInt(expiry, 10) + "." + config.inviteSignature(roomID, expiry)
+}
+
+func (config AccessConfig) checkInvite(roomID string, invite string, now time.Time) error {
+	if !config.invitesEnabled() {
+		return ErrInviteInvalid
+	}
+
+	expiryText, signature, found := strings.Cut(invite, ".")
+	if !found {
+		return ErrInviteInvalid
+	}
+
+	expiry, err := strconv.ParseInt(expiryText, 10, 64)
+	if err != nil {
+		return ErrInviteInvalid
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(config.inviteSignature(roomID, expiry))) {
+		return ErrInviteInvalid
+	}
+
+	if now.Unix() >= expiry {
+		return ErrInviteExpired
+	}
+
+	return nil
+}
+
+func (config AccessConfig) checkAccess(roomID string, password string, invite string) (websocket.StatusCode, string, bool) {
+	if !config.Restricted(roomID) {
+		return 0, "", true
+	}
+
+	roomPassword := config.roomPassword(roomID)
+	if password != "" && roomPassword != "" &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(roomPassword)) == 1 {
+		return 0, "", true
+	}
+
+	if invite != "" {
+		err := config.checkInvite(roomID, invite, time.Now())
+		switch {
+		case err == nil:
+			return 0, "", true
+		case errors.Is(err, ErrInviteExpired):
+			return StatusInviteExpired, "Your invite has expired", false
+		default:
+			return StatusAccessDenied, "Your invite isn't valid", false
+		}
+	}
+
+	if password != "" {
+		return StatusAccessDenied, "Wrong password", false
+	}
+
+	if roomPassword == "" {
+		return StatusAccessRequired, "You need an invite to join", false
+	}
+
+	return StatusAccessRequired, "You need a password or an invite to join", false
+}
+
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range '%s'", entry)
+			}
+
+			proxies = append(proxies, network)
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy address '%s'", entry)
+		}
+
+		bits := 8 * len(ip)
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+
+		proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return proxies, nil
+}
+
+func trustedProxy(proxies []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckInvite(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	config := AccessConfig{InviteSecret: "secret"}
+	valid := config.CreateInvite("lobby", now.Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		config AccessConfig
+		roomID string
+		invite string
+		err    error
+	}{
+		{
+			name:   "valid",
+			config: config,
+			roomID: "lobby",
+			invite: valid,
+		},
+		{
+			name:   "expired",
+			config: config,
+			roomID: "lobby",
+			invite: config.CreateInvite("lobby", now.Add(-time.Second)),
+			err:    ErrInviteExpired,
+		},
+		{
+			name:   "expires now",
+			config: config,
+			roomID: "lobby",
+			invite: config.CreateInvite("lobby", now),
+			err:    ErrInviteExpired,
+		},
+		{
+			name:   "other room",
+			config: config,
+			roomID: "cinema",
+			invite: valid,
+			err:    ErrInviteInvalid,
+		},
+		{
+			name:   "other secret",
+			config: AccessConfig{InviteSecret: "another secret"},
+			roomID: "lobby",
+			invite: valid,
+			err:    ErrInviteInvalid,
+		},
+		{
+			name:   "invites disabled",
+			config: AccessConfig{},
+			roomID: "lobby",
+			invite: valid,
+			err:    ErrInviteInvalid,
+		},
+		{
+			name:   "extended expiry",
+			config: config,
+			roomID: "lobby",
+			invite: "1800000000" + valid[len("1700003600"):],
+			err:    ErrInviteInvalid,
+		},
+		{
+			name:   "missing signature",
+			config: config,
+			roomID: "lobby",
+			invite: "1700003600",
+			err:    ErrInviteInvalid,
+		},
+		{
+			name:   "invalid expiry",
+			config: config,
+			roomID: "lobby",
+			invite: "soon." + config.inviteSignature("lobby", 0),
+			err:    ErrInviteInvalid,
+		},
+		{
+			name:   "expired with a bad signature",
+			config: config,
+			roomID: "lobby",
+			invite: "1600000000.bad",
+			err:    ErrInviteInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.config.checkInvite(test.roomID, test.invite, now); !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	config := AccessConfig{
+		Password:       "everyone",
+		RoomPasswords:  map[string]string{"open": "", "private": "members"},
+		InviteSecret:   "secret",
+		InviteLifetime: time.Hour,
+	}
+
+	tests := []struct {
+		name     string
+		roomID   string
+		password string
+		invite   string
+		status   int
+		allowed  bool
+	}{
+		{name: "unrestricted room", roomID: "open", allowed: true},
+		{name: "default password", roomID: "lobby", password: "everyone", allowed: true},
+		{name: "room password", roomID: "private", password: "members", allowed: true},
+		{name: "default password for a room with its own", roomID: "private", password: "everyone", status: int(StatusAccessDenied)},
+		{name: "no password", roomID: "lobby", status: int(StatusAccessRequired)},
+		{name: "invite", roomID: "private", invite: config.CreateInvite("private", time.Now().Add(time.Hour)), allowed: true},
+		{name: "expired invite", roomID: "private", invite: config.CreateInvite("private", time.Now().Add(-time.Hour)), status: int(StatusInviteExpired)},
+		{name: "invite for another room", roomID: "private", invite: config.CreateInvite("lobby", time.Now().Add(time.Hour)), status: int(StatusAccessDenied)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, _, allowed := config.checkAccess(test.roomID, test.password, test.invite)
+			if allowed != test.allowed || int(status) != test.status {
+				t.Errorf("expected %d, %t, got %d, %t", test.status, test.allowed, status, allowed)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		trusted []string
+		other   []string
+		valid   bool
+	}{
+		{name: "address", entries: []string{"127.0.0.1"}, trusted: []string{"127.0.0.1"}, other: []string{"127.0.0.2"}, valid: true},
+		{name: "range", entries: []string{" 10.0.0.0/8 "}, trusted: []string{"10.20.30.40"}, other: []string{"11.0.0.1"}, valid: true},
+		{name: "IPv6", entries: []string{"::1", "fd00::/8"}, trusted: []string{"::1", "fd12::3"}, other: []string{"::2"}, valid: true},
+		{name: "empty entries", entries: []string{"", " "}, other: []string{"127.0.0.1"}, valid: true},
+		{name: "invalid address", entries: []string{"localhost"}},
+		{name: "invalid range", entries: []string{"10.0.0.0/40"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxies, err := parseTrustedProxies(test.entries)
+			if !test.valid {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, address := range test.trusted {
+				if !trustedProxy(proxies, address) {
+					t.Errorf("expected %s to be trusted", address)
+				}
+			}
+
+			for _, address := range test.other {
+				if trustedProxy(proxies, address) {
+					t.Errorf("expected %s not to be trusted", address)
+				}
+			}
+		})
+	}
+}
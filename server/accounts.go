@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"watch-party/database"
+)
+
+const AccountAPIPath = "/api/account/"
+const SessionCookieName = "watch_party_session"
+const MinPasswordLength = 8
+
+// bcrypt ignores anything past this.
+const MaxPasswordLength = 72
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+type AccountConfig struct {
+	SessionLifetime time.Duration
+
+	// Let anyone create an account, rather than only the admin API.
+	Registration bool
+}
+
+type accountAPI struct {
+	db     *gorm.DB
+	config AccountConfig
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type AccountResponse struct {
+	ID       uint    `json:"id"`
+	Username string  `json:"username"`
+	Profile  Profile `json:"profile"`
+}
+
+func newAccountAPI(config AccountConfig, db *gorm.DB) *accountAPI {
+	return &accountAPI{
+		db:     db,
+		config: config,
+	}
+}
+
+func loginProblem(login LoginRequest) string {
+	if !usernamePattern.MatchString(login.Username) {
+		return "Usernames must be 3 to 32 letters, numbers, dots, dashes or underscores"
+	}
+
+	if len(login.Password) < MinPasswordLength {
+		return "Passwords must be at least 8 characters"
+	}
+
+	if len(login.Password) > MaxPasswordLength {
+		return "Passwords can be at most 72 bytes long"
+	}
+
+	return ""
+}
+
+func userProfile(user database.User) Profile {
+	profile := Profile{
+		Name:   user.DisplayName,
+		Colour: user.Colour,
+		Avatar: user.Avatar,
+	}
+
+	if profile.Name == "" {
+		profile.Name = user.Username
+	}
+
+	if profile, valid := validateProfile(profile); valid {
+		return profile
+	}
+
+	return Profile{Name: sanitizeDisplayName(user.Username)}
+}
+
+func accountResponse(user database.User) AccountResponse {
+	return AccountResponse{
+		ID:       user.ID,
+		Username: user.Username,
+		Profile:  userProfile(user),
+	}
+}
+
+func (api *accountAPI) sessionUser(request *http.Request) *database.User {
+	cookie, err := request.Cookie(SessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	user, err := database.FindSessionUser(api.db, cookie.Value)
+	if err != nil {
+		log.WithError(err).Error("Unable to query session")
+		return nil
+	}
+
+	return user
+}
+
+func (api *accountAPI) startSession(response http.ResponseWriter, request *http.Request, user database.User) bool {
+	token, expiresAt, err := database.CreateUserSession(api.db, user.ID, api.config.SessionLifetime)
+	if err != nil {
+		log.WithError(err).Error("Unable to create session")
+		writeError(response, http.StatusInternalServerError, "Unable to log in")
+		return false
+	}
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return true
+}
+
+func (api *accountAPI) login(response http.ResponseWriter, request *http.Request) {
+	var login LoginRequest
+	if !readJSON(response, request, &login) {
+		return
+	}
+
+	user, err := database.Authenticate(api.db, login.Username, login.Password)
+	if errors.Is(err, database.ErrInvalidLogin) {
+		log.WithField("username", login.Username).Warn("Failed login")
+		writeError(response, http.StatusUnauthorized, "Wrong username or password")
+		return
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Unable to log in")
+		writeError(response, http.StatusInternalServerError, "Unable to log in")
+		return
+	}
+
+	if removed, err := database.PruneExpiredUserSessions(api.db); err != nil {
+		log.WithError(err).Warn("Unable to remove expired sessions")
+	} else if removed > 0 {
+		log.WithField("count", removed).Info("Removed expired sessions")
+	}
+
+	if !api.startSession(response, request, *user) {
+		return
+	}
+
+	log.WithField("username", user.Username).Info("Logged in")
+	writeJSON(response, http.StatusOK, accountResponse(*user))
+}
+
+func (api *accountAPI) logout(response http.ResponseWriter, request *http.Request) {
+	if cookie, err := request.Cookie(SessionCookieName); err == nil {
+		if err := database.DeleteUserSession(api.db, cookie.Value); err != nil {
+			log.WithError(err).Error("Unable to remove session")
+		}
+	}
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func createUser(db *gorm.DB, response http.ResponseWriter, login LoginRequest) (database.User, bool) {
+	if problem := loginProblem(login); problem != "" {
+		writeError(response, http.StatusBadRequest, problem)
+		return database.User{}, false
+	}
+
+	user, err := database.CreateUser(db, login.Username, login.Password)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		writeError(response, http.StatusConflict, "That username is taken")
+		return database.User{}, false
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Unable to create user")
+		writeError(response, http.StatusInternalServerError, "Unable to create user")
+		return database.User{}, false
+	}
+
+	log.WithField("username", user.Username).Info("Created user")
+	return user, true
+}
+
+func (api *accountAPI) register(response http.ResponseWriter, request *http.Request) {
+	if !api.config.Registration {
+		writeError(response, http.StatusForbidden, "Registration is disabled")
+		return
+	}
+
+	var login LoginRequest
+	if !readJSON(response, request, &login) {
+		return
+	}
+
+	user, created := createUser(api.db, response, login)
+	if !created || !api.startSession(response, request, user) {
+		return
+	}
+
+	writeJSON(response, http.StatusCreated, accountResponse(user))
+}
+
+func (api *accountAPI) me(response http.ResponseWriter, request *http.Request) {
+	user := api.sessionUser(request)
+	if user == nil {
+		writeError(response, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+
+	writeJSON(response, http.StatusOK, accountResponse(*user))
+}
+
+func (api *accountAPI) serve(response http.ResponseWriter, request *http.Request) {
+	endpoint := strings.Trim(strings.TrimPrefix(request.URL.Path, AccountAPIPath), "/")
+	switch {
+	case endpoint == "login" && request.Method == http.MethodPost:
+		api.login(response, request)
+	case endpoint == "logout" && request.Method == http.MethodPost:
+		api.logout(response, request)
+	case endpoint == "register" && request.Method == http.MethodPost:
+		api.register(response, request)
+	case endpoint == "me" && request.Method == http.MethodGet:
+		api.me(response, request)
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func AccountHandler(api *accountAPI, next http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.Path, AccountAPIPath) {
+			next(response, request)
+			return
+		}
+
+		api.serve(response, request)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"watch-party/database"
+)
+
+func TestLoginProblem(t *testing.T) {
+	tests := []struct {
+		name  string
+		login LoginRequest
+		valid bool
+	}{
+		{name: "valid", login: LoginRequest{Username: "alice", Password: "correct horse"}, valid: true},
+		{name: "username with symbols", login: LoginRequest{Username: "a.l-i_ce", Password: "correct horse"}, valid: true},
+		{name: "short username", login: LoginRequest{Username: "al", Password: "correct horse"}},
+		{name: "long username", login: LoginRequest{Username: "alice-has-a-really-long-username-now", Password: "correct horse"}},
+		{name: "username with spaces", login: LoginRequest{Username: "alice smith", Password: "correct horse"}},
+		{name: "short password", login: LoginRequest{Username: "alice", Password: "horse"}},
+		{name: "shortest password", login: LoginRequest{Username: "alice", Password: "12345678"}, valid: true},
+		{name: "longest password", login: LoginRequest{Username: "alice", Password: strings.Repeat("a", MaxPasswordLength)}, valid: true},
+		{name: "long password", login: LoginRequest{Username: "alice", Password: strings.Repeat("a", MaxPasswordLength+1)}},
+		{name: "long password in runes", login: LoginRequest{Username: "alice", Password: strings.Repeat("é", 40)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if problem := loginProblem(test.login); (problem == "") != test.valid {
+				t.Errorf("expected valid to be %t, got problem %q", test.valid, problem)
+			}
+		})
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     database.User
+		expected string
+	}{
+		{name: "display name", user: database.User{Username: "alice", DisplayName: "Alice"}, expected: "Alice"},
+		{name: "no display name", user: database.User{Username: "alice"}, expected: "alice"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if profile := userProfile(test.user); profile.Name != test.expected {
+				t.Errorf("expected name %q, got %q", test.expected, profile.Name)
+			}
+		})
+	}
+}
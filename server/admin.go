@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"nhooyr.io/websocket"
+	"time"
+	"unicode/utf8"
+	"watch-party/database"
+)
+
+// The longest reason a websocket close frame can carry.
+const MaxCloseReasonLength = 123
+
+var ErrNoSuchClient = errors.New("no such client")
+var ErrNoSuchVideo = errors.New("no such video")
+
+type ClientStatus struct {
+	Token           string    `json:"token"`
+	Name            string    `json:"name"`
+	Username        string    `json:"username,omitempty"`
+	Address         string    `json:"address"`
+	Role            Role      `json:"role"`
+	Seat            *Seat     `json:"seat"`
+	Ready           bool      `json:"ready"`
+	Muted           bool      `json:"muted"`
+	CatchingUp      bool      `json:"catching_up"`
+	WaitingPosition int       `json:"waiting_position"`
+	Latency         float64   `json:"latency"`
+	Drift           float64   `json:"drift"`
+	JoinedAt        time.Time `json:"joined_at"`
+}
+
+type RoomStatus struct {
+	ID        string         `json:"id"`
+	VideoFile string         `json:"video"`
+	Playing   bool           `json:"playing"`
+	Progress  float64        `json:"progress"`
+	Clients   []ClientStatus `json:"clients"`
+}
+
+func closeReason(reason string) string {
+	if len(reason) <= MaxCloseReasonLength {
+		return reason
+	}
+
+	end := MaxCloseReasonLength
+	for end > 0 && !utf8.RuneStart(reason[end]) {
+		end--
+	}
+
+	return reason[:end]
+}
+
+func (server *Server) roomStatus() RoomStatus {
+	server.updateVideoState()
+
+	clients := make([]ClientStatus, 0, len(server.connectedClients))
+	for token, client := range server.connectedClients {
+		_, muted := server.activeMute(client)
+		clients = append(clients, ClientStatus{
+			Token:           token,
+			Name:            client.Profile.Name,
+			Username:        client.Username,
+			Address:         client.Address,
+			Role:            client.Role,
+			Seat:            server.stage.SeatForPlayer(token),
+			Ready:           client.Ready,
+			Muted:           muted,
+			CatchingUp:      client.CatchingUp,
+			WaitingPosition: server.waitingPosition(token),
+			Latency:         client.Clock.Latency.Seconds(),
+			Drift:           client.Clock.Drift,
+			JoinedAt:        client.JoinedAt,
+		})
+	}
+
+	return RoomStatus{
+		ID:        server.roomID,
+		VideoFile: server.videoState.VideoFile,
+		Playing:   server.videoState.Playing,
+		Progress:  server.videoState.Progress,
+		Clients:   clients,
+	}
+}
+
+func (server *Server) kick(token string, reason string) error {
+	if err := server.disconnect(token, websocket.StatusPolicyViolation, reason); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"token":  token,
+		"room":   server.roomID,
+		"reason": reason,
+	}).Info("Kicked client")
+
+	return nil
+}
+
+func (server *Server) forcePlay(message ServerMessage) error {
+	if message.File != nil {
+		result := server.db.
+			Where(database.Video{VideoFilePath: *message.File}).
+			First(&database.Video{})
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return ErrNoSuchVideo
+		}
+
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	noSender := ""
+	server.requestPlay(ServerMessage{
+		Type:     ServerMessageRequestPlay,
+		Token:    &noSender,
+		Playing:  message.Playing,
+		Progress: message.Progress,
+		File:     message.File,
+	})
+	return nil
+}
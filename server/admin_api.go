@@ -0,0 +1,747 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"watch-party/database"
+)
+
+const AdminAPIPath = "/api/admin/"
+const AdminRequestTimeout = 5 * time.Second
+const MaxAdminRequestSize = 1 << 20
+
+type adminAPI struct {
+	token   string
+	db      *gorm.DB
+	library *database.Library
+	rooms   *RoomRegistry
+	uploads *uploadManager
+	access  AccessConfig
+}
+
+type AdminErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type AdminVideo struct {
+	ID         uint      `json:"id"`
+	Title      string    `json:"title"`
+	File       string    `json:"file"`
+	Thumbnail  string    `json:"thumbnail"`
+	FileSize   int64     `json:"file_size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	HLSStatus  string    `json:"hls_status"`
+
+	Series  string `json:"series,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+	Year    int    `json:"year,omitempty"`
+
+	Metadata *VideoMetadata `json:"metadata,omitempty"`
+}
+
+type AdminImage struct {
+	ID         uint      `json:"id"`
+	Title      string    `json:"title"`
+	File       string    `json:"file"`
+	Thumbnail  string    `json:"thumbnail"`
+	FileSize   int64     `json:"file_size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+type AdminEditRequest struct {
+	Title string `json:"title"`
+}
+
+type AdminKickRequest struct {
+	Token  string `json:"token"`
+	Reason string `json:"reason"`
+}
+
+type AdminUser struct {
+	ID          uint      `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// An empty room bans from every room, and an empty duration forever.
+type AdminBanRequest struct {
+	Username string `json:"username"`
+	Address  string `json:"address"`
+	Room     string `json:"room"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+type AdminBan struct {
+	ID        uint       `json:"id"`
+	UserID    uint       `json:"user_id,omitempty"`
+	Address   string     `json:"address,omitempty"`
+	Room      string     `json:"room,omitempty"`
+	Reason    string     `json:"reason"`
+	BannedBy  string     `json:"banned_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type AdminInviteRequest struct {
+	Lifetime string `json:"lifetime"`
+}
+
+type AdminInvite struct {
+	Room      string    `json:"room"`
+	Invite    string    `json:"invite"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AdminPlaybackRequest struct {
+	Playing   bool    `json:"playing"`
+	Progress  float64 `json:"progress"`
+	VideoFile *string `json:"video"`
+}
+
+func writeJSON(response http.ResponseWriter, status int, data interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	if err := json.NewEncoder(response).Encode(data); err != nil {
+		log.WithError(err).Warn("Unable to write admin API response")
+	}
+}
+
+func writeError(response http.ResponseWriter, status int, message string) {
+	writeJSON(response, status, AdminErrorResponse{
+		Error: message,
+	})
+}
+
+func readJSON(response http.ResponseWriter, request *http.Request, data interface{}) bool {
+	body := http.MaxBytesReader(response, request.Body, MaxAdminRequestSize)
+	if err := json.NewDecoder(body).Decode(data); err != nil {
+		writeError(response, http.StatusBadRequest, "Invalid JSON body")
+		return false
+	}
+
+	return true
+}
+
+func (api *adminAPI) authorized(request *http.Request) bool {
+	token, hasToken := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !hasToken {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) == 1
+}
+
+func adminVideo(video database.Video) AdminVideo {
+	return AdminVideo{
+		ID:         video.ID,
+		Title:      video.Title,
+		File:       video.VideoFilePath,
+		Thumbnail:  video.ThumbnailPath,
+		FileSize:   video.FileSize,
+		ModifiedAt: video.FileModifiedAt,
+		HLSStatus:  video.HLSStatus,
+		Series:     video.Series,
+		Season:     video.Season,
+		Episode:    video.Episode,
+		Year:       video.Year,
+		Metadata:   videoGalleryItem(video).Metadata,
+	}
+}
+
+func adminUser(user database.User) AdminUser {
+	return AdminUser{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		CreatedAt:   user.CreatedAt,
+		LastLoginAt: user.LastLoginAt,
+	}
+}
+
+func adminBan(ban database.Ban) AdminBan {
+	return AdminBan{
+		ID:        ban.ID,
+		UserID:    ban.UserID,
+		Address:   ban.Address,
+		Room:      ban.RoomID,
+		Reason:    ban.Reason,
+		BannedBy:  ban.BannedBy,
+		CreatedAt: ban.CreatedAt,
+		ExpiresAt: ban.ExpiresAt,
+	}
+}
+
+func adminImage(image database.Image) AdminImage {
+	return AdminImage{
+		ID:         image.ID,
+		Title:      image.Title,
+		File:       image.FilePath,
+		Thumbnail:  image.ThumbnailPath,
+		FileSize:   image.FileSize,
+		ModifiedAt: image.FileModifiedAt,
+	}
+}
+
+func (api *adminAPI) findRow(response http.ResponseWriter, idText string, row interface{}) bool {
+	id, err := strconv.ParseUint(idText, 10, 0)
+	if err != nil {
+		writeError(response, http.StatusNotFound, "Not found")
+		return false
+	}
+
+	result := api.db.First(row, uint(id))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		writeError(response, http.StatusNotFound, "Not found")
+		return false
+	}
+
+	if result.Error != nil {
+		log.WithError(result.Error).Error("Unable to query library")
+		writeError(response, http.StatusInternalServerError, "Unable to query library")
+		return false
+	}
+
+	return true
+}
+
+func (api *adminAPI) videos(response http.ResponseWriter, request *http.Request, path []string) {
+	if len(path) == 0 {
+		if request.Method != http.MethodGet {
+			writeError(response, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var videos []database.Video
+		if result := api.db.Order("title").Find(&videos); result.Error != nil {
+			log.WithError(result.Error).Error("Unable to query videos")
+			writeError(response, http.StatusInternalServerError, "Unable to query videos")
+			return
+		}
+
+		adminVideos := make([]AdminVideo, 0, len(videos))
+		for _, video := range videos {
+			adminVideos = append(adminVideos, adminVideo(video))
+		}
+
+		writeJSON(response, http.StatusOK, adminVideos)
+		return
+	}
+
+	var video database.Video
+	if !api.findRow(response, path[0], &video) {
+		return
+	}
+
+	switch {
+	case len(path) == 1 && request.Method == http.MethodGet:
+		writeJSON(response, http.StatusOK, adminVideo(video))
+
+	case len(path) == 1 && request.Method == http.MethodPatch:
+		var edit AdminEditRequest
+		if !readJSON(response, request, &edit) {
+			return
+		}
+
+		title := strings.TrimSpace(edit.Title)
+		if title == "" {
+			writeError(response, http.StatusBadRequest, "Title can't be empty")
+			return
+		}
+
+		result := api.db.
+			Model(&video).
+			Updates(database.Video{Title: title, TitleEdited: true})
+		if result.Error != nil {
+			log.WithError(result.Error).Error("Unable to update video")
+			writeError(response, http.StatusInternalServerError, "Unable to update video")
+			return
+		}
+
+		api.rooms.Broadcast(ServerMessage{Type: ServerMessageVideoListChanged})
+		writeJSON(response, http.StatusOK, adminVideo(video))
+
+	case len(path) == 1 && request.Method == http.MethodDelete:
+		if err := api.library.DeleteVideo(video); err != nil {
+			log.WithError(err).Error("Unable to delete video")
+			writeError(response, http.StatusInternalServerError, "Unable to delete video")
+			return
+		}
+
+		response.WriteHeader(http.StatusNoContent)
+
+	case len(path) == 2 && path[1] == "thumbnail" && request.Method == http.MethodPost:
+		if err := api.library.RegenerateVideoThumbnail(video); err != nil {
+			writeThumbnailError(response, err)
+			return
+		}
+
+		response.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func (api *adminAPI) images(response http.ResponseWriter, request *http.Request, path []string) {
+	if len(path) == 0 {
+		if request.Method != http.MethodGet {
+			writeError(response, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var images []database.Image
+		if result := api.db.Order("title").Find(&images); result.Error != nil {
+			log.WithError(result.Error).Error("Unable to query images")
+			writeError(response, http.StatusInternalServerError, "Unable to query images")
+			return
+		}
+
+		adminImages := make([]AdminImage, 0, len(images))
+		for _, image := range images {
+			adminImages = append(adminImages, adminImage(image))
+		}
+
+		writeJSON(response, http.StatusOK, adminImages)
+		return
+	}
+
+	var image database.Image
+	if !api.findRow(response, path[0], &image) {
+		return
+	}
+
+	switch {
+	case len(path) == 1 && request.Method == http.MethodGet:
+		writeJSON(response, http.StatusOK, adminImage(image))
+
+	case len(path) == 1 && request.Method == http.MethodPatch:
+		var edit AdminEditRequest
+		if !readJSON(response, request, &edit) {
+			return
+		}
+
+		title := strings.TrimSpace(edit.Title)
+		if title == "" {
+			writeError(response, http.StatusBadRequest, "Title can't be empty")
+			return
+		}
+
+		if result := api.db.Model(&image).Update("title", title); result.Error != nil {
+			log.WithError(result.Error).Error("Unable to update image")
+			writeError(response, http.StatusInternalServerError, "Unable to update image")
+			return
+		}
+
+		api.rooms.Broadcast(ServerMessage{Type: ServerMessageImageListChanged})
+		writeJSON(response, http.StatusOK, adminImage(image))
+
+	case len(path) == 1 && request.Method == http.MethodDelete:
+		if err := api.library.DeleteImage(image); err != nil {
+			log.WithError(err).Error("Unable to delete image")
+			writeError(response, http.StatusInternalServerError, "Unable to delete image")
+			return
+		}
+
+		response.WriteHeader(http.StatusNoContent)
+
+	case len(path) == 2 && path[1] == "thumbnail" && request.Method == http.MethodPost:
+		if err := api.library.RegenerateImageThumbnail(image); err != nil {
+			writeThumbnailError(response, err)
+			return
+		}
+
+		response.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func writeThumbnailError(response http.ResponseWriter, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(response, http.StatusConflict, "The file is missing")
+		return
+	}
+
+	log.WithError(err).Error("Unable to regenerate thumbnail")
+	writeError(response, http.StatusInternalServerError, "Unable to regenerate thumbnail")
+}
+
+func (api *adminAPI) roomStatus(roomID string) (RoomStatus, bool) {
+	reply := make(chan RoomStatus, 1)
+	if !api.rooms.Send(roomID, ServerMessage{Type: ServerMessageRoomStatus, StatusReply: reply}) {
+		return RoomStatus{}, false
+	}
+
+	select {
+	case status := <-reply:
+		return status, true
+	case <-time.After(AdminRequestTimeout):
+		return RoomStatus{}, false
+	}
+}
+
+func (api *adminAPI) sendToRoom(response http.ResponseWriter, roomID string, message ServerMessage) {
+	reply := make(chan error, 1)
+	message.Reply = reply
+	if !api.rooms.Send(roomID, message) {
+		writeError(response, http.StatusNotFound, "No such room")
+		return
+	}
+
+	var err error
+	select {
+	case err = <-reply:
+	case <-time.After(AdminRequestTimeout):
+		writeError(response, http.StatusGatewayTimeout, "The room didn't respond")
+		return
+	}
+
+	switch {
+	case err == nil:
+		response.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrNoSuchClient), errors.Is(err, ErrNoSuchVideo):
+		writeError(response, http.StatusNotFound, err.Error())
+	default:
+		log.WithError(err).Error("Admin room request failed")
+		writeError(response, http.StatusInternalServerError, "Request failed")
+	}
+}
+
+func (api *adminAPI) roomsEndpoint(response http.ResponseWriter, request *http.Request, path []string) {
+	switch {
+	case len(path) == 0 && request.Method == http.MethodGet:
+		statuses := []RoomStatus{}
+		for _, roomID := range api.rooms.RoomIDs() {
+			if status, ok := api.roomStatus(roomID); ok {
+				statuses = append(statuses, status)
+			}
+		}
+
+		writeJSON(response, http.StatusOK, statuses)
+
+	case len(path) == 1 && request.Method == http.MethodGet:
+		status, ok := api.roomStatus(path[0])
+		if !ok {
+			writeError(response, http.StatusNotFound, "No such room")
+			return
+		}
+
+		writeJSON(response, http.StatusOK, status)
+
+	case len(path) == 2 && path[1] == "kick" && request.Method == http.MethodPost:
+		var kick AdminKickRequest
+		if !readJSON(response, request, &kick) {
+			return
+		}
+
+		api.sendToRoom(response, path[0], ServerMessage{
+			Type:    ServerMessageKick,
+			Token:   &kick.Token,
+			Message: kick.Reason,
+		})
+
+	case len(path) == 2 && path[1] == "playback" && request.Method == http.MethodPost:
+		var playback AdminPlaybackRequest
+		if !readJSON(response, request, &playback) {
+			return
+		}
+
+		if playback.Progress < 0 {
+			writeError(response, http.StatusBadRequest, "Progress can't be negative")
+			return
+		}
+
+		api.sendToRoom(response, path[0], ServerMessage{
+			Type:     ServerMessageForcePlay,
+			Playing:  playback.Playing,
+			Progress: playback.Progress,
+			File:     playback.VideoFile,
+		})
+
+	case len(path) == 2 && path[1] == "invites" && request.Method == http.MethodPost:
+		api.createInvite(response, request, path[0])
+
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func (api *adminAPI) users(response http.ResponseWriter, request *http.Request, path []string) {
+	switch {
+	case len(path) == 0 && request.Method == http.MethodGet:
+		var users []database.User
+		if result := api.db.Order("username").Find(&users); result.Error != nil {
+			log.WithError(result.Error).Error("Unable to query users")
+			writeError(response, http.StatusInternalServerError, "Unable to query users")
+			return
+		}
+
+		adminUsers := make([]AdminUser, 0, len(users))
+		for _, user := range users {
+			adminUsers = append(adminUsers, adminUser(user))
+		}
+
+		writeJSON(response, http.StatusOK, adminUsers)
+
+	case len(path) == 0 && request.Method == http.MethodPost:
+		var login LoginRequest
+		if !readJSON(response, request, &login) {
+			return
+		}
+
+		if user, created := createUser(api.db, response, login); created {
+			writeJSON(response, http.StatusCreated, adminUser(user))
+		}
+
+	case len(path) == 1 && request.Method == http.MethodDelete:
+		var user database.User
+		if !api.findRow(response, path[0], &user) {
+			return
+		}
+
+		if err := database.DeleteUser(api.db, user); err != nil {
+			log.WithError(err).Error("Unable to delete user")
+			writeError(response, http.StatusInternalServerError, "Unable to delete user")
+			return
+		}
+
+		log.WithField("username", user.Username).Info("Deleted user")
+		response.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func (api *adminAPI) bans(response http.ResponseWriter, request *http.Request, path []string) {
+	switch {
+	case len(path) == 0 && request.Method == http.MethodGet:
+		bans, err := database.ActiveBans(api.db)
+		if err != nil {
+			log.WithError(err).Error("Unable to query bans")
+			writeError(response, http.StatusInternalServerError, "Unable to query bans")
+			return
+		}
+
+		adminBans := make([]AdminBan, 0, len(bans))
+		for _, ban := range bans {
+			adminBans = append(adminBans, adminBan(ban))
+		}
+
+		writeJSON(response, http.StatusOK, adminBans)
+
+	case len(path) == 0 && request.Method == http.MethodPost:
+		api.createBan(response, request)
+
+	case len(path) == 1 && request.Method == http.MethodDelete:
+		var ban database.Ban
+		if !api.findRow(response, path[0], &ban) {
+			return
+		}
+
+		if result := api.db.Delete(&ban); result.Error != nil {
+			log.WithError(result.Error).Error("Unable to delete ban")
+			writeError(response, http.StatusInternalServerError, "Unable to delete ban")
+			return
+		}
+
+		log.WithField("ban", ban.ID).Info("Lifted ban")
+		response.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func (api *adminAPI) createBan(response http.ResponseWriter, request *http.Request) {
+	var banRequest AdminBanRequest
+	if !readJSON(response, request, &banRequest) {
+		return
+	}
+
+	if banRequest.Username == "" && banRequest.Address == "" {
+		writeError(response, http.StatusBadRequest, "Bans need a username or address")
+		return
+	}
+
+	if banRequest.Room != "" && !ValidRoomID(banRequest.Room) {
+		writeError(response, http.StatusBadRequest, "Invalid room ID")
+		return
+	}
+
+	ban := database.Ban{
+		Address:  banRequest.Address,
+		RoomID:   banRequest.Room,
+		Reason:   banRequest.Reason,
+		BannedBy: "admin",
+	}
+
+	if banRequest.Duration != "" {
+		duration, err := time.ParseDuration(banRequest.Duration)
+		if err != nil || duration <= 0 {
+			writeError(response, http.StatusBadRequest, "Invalid ban duration")
+			return
+		}
+
+		expiresAt := time.Now().Add(duration).Truncate(time.Second)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	if banRequest.Username != "" {
+		var user database.User
+		result := api.db.
+			Where(database.User{Username: banRequest.Username}).
+			First(&user)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			writeError(response, http.StatusNotFound, "No such user")
+			return
+		}
+
+		if result.Error != nil {
+			log.WithError(result.Error).Error("Unable to query users")
+			writeError(response, http.StatusInternalServerError, "Unable to query users")
+			return
+		}
+
+		ban.UserID = user.ID
+	}
+
+	if removed, err := database.PruneExpiredBans(api.db); err != nil {
+		log.WithError(err).Warn("Unable to remove expired bans")
+	} else if removed > 0 {
+		log.WithField("count", removed).Info("Removed expired bans")
+	}
+
+	if err := database.CreateBan(api.db, &ban); err != nil {
+		log.WithError(err).Error("Unable to save ban")
+		writeError(response, http.StatusInternalServerError, "Unable to save ban")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"ban":      ban.ID,
+		"username": banRequest.Username,
+		"address":  ban.Address,
+		"room":     ban.RoomID,
+	}).Info("Created ban")
+
+	api.rooms.Broadcast(ServerMessage{Type: ServerMessageEnforceBans})
+	writeJSON(response, http.StatusCreated, adminBan(ban))
+}
+
+func (api *adminAPI) createInvite(response http.ResponseWriter, request *http.Request, roomID string) {
+	if !api.access.invitesEnabled() {
+		writeError(response, http.StatusConflict, "Invites aren't enabled")
+		return
+	}
+
+	if !ValidRoomID(roomID) {
+		writeError(response, http.StatusBadRequest, "Invalid room ID")
+		return
+	}
+
+	var inviteRequest AdminInviteRequest
+	if !readJSON(response, request, &inviteRequest) {
+		return
+	}
+
+	lifetime := api.access.InviteLifetime
+	if inviteRequest.Lifetime != "" {
+		var err error
+		lifetime, err = time.ParseDuration(inviteRequest.Lifetime)
+		if err != nil || lifetime <= 0 {
+			writeError(response, http.StatusBadRequest, "Invalid invite lifetime")
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(lifetime).Truncate(time.Second)
+	log.WithFields(log.Fields{
+		"room":       roomID,
+		"expires_at": expiresAt,
+	}).Info("Created invite")
+
+	writeJSON(response, http.StatusCreated, AdminInvite{
+		Room:      roomID,
+		Invite:    api.access.CreateInvite(roomID, expiresAt),
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (api *adminAPI) serve(response http.ResponseWriter, request *http.Request) {
+	if !api.authorized(request) {
+		writeError(response, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, AdminAPIPath), "/"), "/")
+	log.WithFields(log.Fields{
+		"method": request.Method,
+		"path":   request.URL.Path,
+	}).Info("Admin API request")
+
+	switch path[0] {
+	case "videos":
+		api.videos(response, request, path[1:])
+	case "images":
+		api.images(response, request, path[1:])
+	case "rooms":
+		api.roomsEndpoint(response, request, path[1:])
+	case "uploads":
+		api.uploadsEndpoint(response, request, path[1:])
+	case "users":
+		api.users(response, request, path[1:])
+	case "bans":
+		api.bans(response, request, path[1:])
+	case "rescan":
+		if request.Method != http.MethodPost {
+			writeError(response, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		api.library.Rescan()
+		response.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(response, http.StatusNotFound, "Not found")
+	}
+}
+
+func AdminHandler(
+	config Config,
+	db *gorm.DB,
+	library *database.Library,
+	rooms *RoomRegistry,
+	next http.HandlerFunc,
+) http.HandlerFunc {
+	token := config.AdminToken
+	api := adminAPI{
+		token:   token,
+		db:      db,
+		library: library,
+		rooms:   rooms,
+		uploads: newUploadManager(config.UploadsPath, config.MaxUploadSize, library),
+		access:  config.Access,
+	}
+
+	return func(response http.ResponseWriter, request *http.Request) {
+		if token == "" || !strings.HasPrefix(request.URL.Path, AdminAPIPath) {
+			next(response, request)
+			return
+		}
+
+		api.serve(response, request)
+	}
+}
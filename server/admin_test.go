@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		closed string
+	}{
+		{name: "short", reason: "Removed by an admin", closed: "Removed by an admin"},
+		{name: "longest", reason: strings.Repeat("a", MaxCloseReasonLength), closed: strings.Repeat("a", MaxCloseReasonLength)},
+		{name: "too long", reason: strings.Repeat("a", MaxCloseReasonLength+1), closed: strings.Repeat("a", MaxCloseReasonLength)},
+		{name: "rune across the limit", reason: strings.Repeat("a", MaxCloseReasonLength-1) + "é", closed: strings.Repeat("a", MaxCloseReasonLength-1)},
+		{name: "runes past the limit", reason: strings.Repeat("🎬", 40), closed: strings.Repeat("🎬", 30)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			closed := closeReason(test.reason)
+			if closed != test.closed {
+				t.Errorf("expected %q, got %q", test.closed, closed)
+			}
+
+			if !utf8.ValidString(closed) {
+				t.Errorf("expected valid UTF-8, got %q", closed)
+			}
+		})
+	}
+}
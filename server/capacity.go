@@ -0,0 +1,127 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+// Chat from viewers without a seat is sent from here.
+var spectatorSeat = Seat{Row: -1, Column: -1}
+
+type TheaterFullMessage struct {
+	Message string `json:"message"`
+
+	// Where the viewer is on the waiting list, or zero if they're not on it.
+	WaitingPosition int `json:"waiting_position"`
+}
+
+type Spectator struct {
+	ID      uint    `json:"id"`
+	Profile Profile `json:"profile"`
+}
+
+func (server *Server) spectators() []Spectator {
+	spectators := []Spectator{}
+	for token, client := range server.connectedClients {
+		if server.stage.SeatForPlayer(token) == nil {
+			spectators = append(spectators, Spectator{
+				ID:      client.SpectatorID,
+				Profile: client.Profile,
+			})
+		}
+	}
+
+	sort.Slice(spectators, func(i, j int) bool {
+		return spectators[i].ID < spectators[j].ID
+	})
+
+	return spectators
+}
+
+func (server *Server) waitingPosition(token string) int {
+	for i, waitingToken := range server.waitingList {
+		if waitingToken == token {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+func (server *Server) firstInLine(token string) bool {
+	return len(server.waitingList) == 0 || server.waitingList[0] == token
+}
+
+func (server *Server) sendTheaterFull(client *Client) {
+	message := "The theater is full, you can still watch and chat from standing room"
+	position := server.waitingPosition(*client.Token)
+	if position > 0 {
+		message = "The theater is full, you'll be given a seat when one frees up"
+	}
+
+	_ = client.Send(MessageTheaterFull, TheaterFullMessage{
+		Message:         message,
+		WaitingPosition: position,
+	})
+}
+
+func (server *Server) takeSeat(client *Client) {
+	token := *client.Token
+	if server.stage.SeatForPlayer(token) != nil {
+		return
+	}
+
+	if server.firstInLine(token) && server.stage.PlaceViewer(token) {
+		return
+	}
+
+	if server.config.WaitingList && server.waitingPosition(token) == 0 {
+		server.waitingList = append(server.waitingList, token)
+	}
+
+	log.WithFields(log.Fields{
+		"token":   token,
+		"room":    server.roomID,
+		"waiting": server.waitingPosition(token),
+	}).Info("Theater is full, viewer is spectating")
+
+	server.sendTheaterFull(client)
+}
+
+func (server *Server) updateWaitingList() {
+	for _, token := range server.waitingList {
+		server.sendTheaterFull(server.connectedClients[token])
+	}
+}
+
+func (server *Server) leaveWaitingList(token string) {
+	position := server.waitingPosition(token)
+	if position == 0 {
+		return
+	}
+
+	server.waitingList = append(server.waitingList[:position-1], server.waitingList[position:]...)
+	server.updateWaitingList()
+}
+
+func (server *Server) promoteWaitingViewers() {
+	promoted := false
+	for len(server.waitingList) > 0 {
+		token := server.waitingList[0]
+		if !server.stage.PlaceViewer(token) {
+			break
+		}
+
+		log.WithFields(log.Fields{
+			"token": token,
+			"room":  server.roomID,
+		}).Info("Promoted viewer from waiting list")
+
+		server.waitingList = server.waitingList[1:]
+		promoted = true
+	}
+
+	if promoted {
+		server.updateWaitingList()
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestTheater(t *testing.T, waitingList bool, viewerCount int) (*Server, []*testViewer) {
+	server := newTestServer(t, RoomConfig{
+		WaitingList: waitingList,
+		Layouts: LayoutConfig{
+			Layouts:       map[string]Layout{"small": {Rows: []int{2}}},
+			DefaultLayout: "small",
+			RoomLayouts:   map[string]string{},
+		},
+	})
+
+	viewers := []*testViewer{}
+	for i := 0; i < viewerCount; i++ {
+		viewers = append(viewers, joinTestViewer(t, server, fmt.Sprint("Viewer ", i)))
+	}
+
+	return server, viewers
+}
+
+func seatedViewers(server *Server, viewers []*testViewer) []bool {
+	seated := []bool{}
+	for _, viewer := range viewers {
+		seated = append(seated, server.stage.SeatForPlayer(*viewer.client.Token) != nil)
+	}
+
+	return seated
+}
+
+func waitingPositions(server *Server, viewers []*testViewer) []int {
+	positions := []int{}
+	for _, viewer := range viewers {
+		positions = append(positions, server.waitingPosition(*viewer.client.Token))
+	}
+
+	return positions
+}
+
+func TestTakeSeat(t *testing.T) {
+	tests := []struct {
+		name        string
+		waitingList bool
+		viewers     int
+		seated      []bool
+		positions   []int
+		spectators  int
+	}{
+		{
+			name:        "room to spare",
+			waitingList: true,
+			viewers:     1,
+			seated:      []bool{true},
+			positions:   []int{0},
+		},
+		{
+			name:        "full",
+			waitingList: true,
+			viewers:     2,
+			seated:      []bool{true, true},
+			positions:   []int{0, 0},
+		},
+		{
+			name:        "waiting list",
+			waitingList: true,
+			viewers:     4,
+			seated:      []bool{true, true, false, false},
+			positions:   []int{0, 0, 1, 2},
+			spectators:  2,
+		},
+		{
+			name:       "standing room",
+			viewers:    4,
+			seated:     []bool{true, true, false, false},
+			positions:  []int{0, 0, 0, 0},
+			spectators: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, viewers := newTestTheater(t, test.waitingList, test.viewers)
+
+			if seated := seatedViewers(server, viewers); fmt.Sprint(seated) != fmt.Sprint(test.seated) {
+				t.Errorf("expected seated %v, got %v", test.seated, seated)
+			}
+
+			if positions := waitingPositions(server, viewers); fmt.Sprint(positions) != fmt.Sprint(test.positions) {
+				t.Errorf("expected waiting positions %v, got %v", test.positions, positions)
+			}
+
+			if spectators := len(server.spectators()); spectators != test.spectators {
+				t.Errorf("expected %d spectators, got %d", test.spectators, spectators)
+			}
+
+			for i, viewer := range viewers {
+				var theaterFull TheaterFullMessage
+				full := lastMessage(viewer.messages(t), MessageTheaterFull, &theaterFull)
+				if full == test.seated[i] {
+					t.Errorf("viewer %d: expected theater full to be %v", i, !test.seated[i])
+				}
+
+				if theaterFull.WaitingPosition != test.positions[i] {
+					t.Errorf("viewer %d: expected to be told position %d, got %d", i, test.positions[i], theaterFull.WaitingPosition)
+				}
+			}
+		})
+	}
+}
+
+func TestPromoteWaitingViewers(t *testing.T) {
+	tests := []struct {
+		name        string
+		waitingList bool
+		leaving     int
+		seated      []bool
+		positions   []int
+	}{
+		{
+			name:        "seated viewer leaves",
+			waitingList: true,
+			leaving:     0,
+			seated:      []bool{true, true, false},
+			positions:   []int{0, 0, 1},
+		},
+		{
+			name:        "waiting viewer leaves",
+			waitingList: true,
+			leaving:     2,
+			seated:      []bool{true, true, false},
+			positions:   []int{0, 0, 1},
+		},
+		{
+			name:      "standing room",
+			leaving:   0,
+			seated:    []bool{true, false, false},
+			positions: []int{0, 0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, viewers := newTestTheater(t, test.waitingList, 4)
+			for _, viewer := range viewers {
+				viewer.messages(t)
+			}
+
+			leaving := viewers[test.leaving]
+			server.leave(*leaving.client.Token, leaving.client)
+			viewers = append(viewers[:test.leaving], viewers[test.leaving+1:]...)
+
+			if seated := seatedViewers(server, viewers); fmt.Sprint(seated) != fmt.Sprint(test.seated) {
+				t.Errorf("expected seated %v, got %v", test.seated, seated)
+			}
+
+			if positions := waitingPositions(server, viewers); fmt.Sprint(positions) != fmt.Sprint(test.positions) {
+				t.Errorf("expected waiting positions %v, got %v", test.positions, positions)
+			}
+
+			if !test.waitingList {
+				return
+			}
+
+			var theaterFull TheaterFullMessage
+			if !lastMessage(viewers[2].messages(t), MessageTheaterFull, &theaterFull) || theaterFull.WaitingPosition != 1 {
+				t.Errorf("expected the last viewer to be told they're first in line, got %+v", theaterFull)
+			}
+		})
+	}
+}
+
+func TestChangeSeatWaitingList(t *testing.T) {
+	server, viewers := newTestTheater(t, true, 4)
+	free := *server.stage.SeatForPlayer(*viewers[0].client.Token)
+	server.stage.RemovePlayer(*viewers[0].client.Token)
+	viewers[3].messages(t)
+
+	server.changeSeat(*viewers[3].client.Token, free)
+
+	var errorMessage ErrorMessage
+	if !lastMessage(viewers[3].messages(t), MessageError, &errorMessage) || errorMessage.Error != "waiting-list" {
+		t.Errorf("expected to be sent to the back of the line, got %+v", errorMessage)
+	}
+
+	server.changeSeat(*viewers[2].client.Token, free)
+	if seat := server.stage.SeatForPlayer(*viewers[2].client.Token); seat == nil {
+		t.Error("expected the first in line to take the free seat")
+	}
+
+	if positions := waitingPositions(server, viewers); fmt.Sprint(positions) != fmt.Sprint([]int{0, 0, 0, 1}) {
+		t.Errorf("expected the last viewer to move up, got %v", positions)
+	}
+}
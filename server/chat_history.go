@@ -0,0 +1,109 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+	"watch-party/database"
+)
+
+const MaxChatHistoryPageSize = 100
+
+type ChatHistoryRequestMessage struct {
+	Before uint `json:"before"`
+	Limit  int  `json:"limit"`
+}
+
+type ChatHistoryEntry struct {
+	ID uint `json:"id"`
+	ChatResponseMessage
+	SentAt    int64   `json:"sent_at"`
+	VideoFile string  `json:"video"`
+	Progress  float64 `json:"progress"`
+}
+
+type ChatHistoryMessage struct {
+	Messages []ChatHistoryEntry `json:"messages"`
+	HasMore  bool               `json:"has_more"`
+}
+
+func (server *Server) saveChatMessage(token string, seat Seat, message string) {
+	displayName := ""
+	var userID uint
+	if client, exists := server.connectedClients[token]; exists {
+		displayName = client.Profile.Name
+		userID = client.UserID
+	}
+
+	server.updateVideoState()
+	err := database.SaveChatMessage(server.db, &database.ChatMessage{
+		RoomID:        server.roomID,
+		Row:           seat.Row,
+		Column:        seat.Column,
+		UserID:        userID,
+		DisplayName:   displayName,
+		Message:       message,
+		SentAt:        time.Now(),
+		VideoFile:     server.videoState.VideoFile,
+		VideoProgress: server.videoState.Progress,
+	})
+
+	if err != nil {
+		log.WithError(err).
+			WithField("room", server.roomID).
+			Error("Unable to save chat message")
+	}
+}
+
+func (server *Server) chatHistory(token string, beforeID uint, limit int) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	if limit <= 0 || limit > MaxChatHistoryPageSize {
+		limit = MaxChatHistoryPageSize
+	}
+
+	server.sendChatHistory(client, beforeID, limit)
+}
+
+func (server *Server) sendChatHistory(client *Client, beforeID uint, limit int) {
+	if limit <= 0 {
+		return
+	}
+
+	// Load one extra to know if there's anything further back.
+	messages, err := database.ChatHistory(server.db, server.roomID, beforeID, limit+1)
+	if err != nil {
+		log.WithError(err).
+			WithField("room", server.roomID).
+			Error("Unable to load chat history")
+		return
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[1:]
+	}
+
+	entries := make([]ChatHistoryEntry, 0, len(messages))
+	for _, message := range messages {
+		entries = append(entries, ChatHistoryEntry{
+			ID: message.ID,
+			ChatResponseMessage: ChatResponseMessage{
+				Message: message.Message,
+				Row:     message.Row,
+				Column:  message.Column,
+				Name:    message.DisplayName,
+			},
+			SentAt:    message.SentAt.UnixMilli(),
+			VideoFile: message.VideoFile,
+			Progress:  message.VideoProgress,
+		})
+	}
+
+	_ = client.Send(MessageChatHistory, ChatHistoryMessage{
+		Messages: entries,
+		HasMore:  hasMore,
+	})
+}
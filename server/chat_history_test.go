@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChatHistoryPaging(t *testing.T) {
+	server := newTestServer(t, RoomConfig{})
+	viewer := joinTestViewer(t, server, "Alice")
+	for i := 1; i <= MaxChatHistoryPageSize+5; i++ {
+		server.chat(*viewer.client.Token, fmt.Sprint(i))
+	}
+
+	tests := []struct {
+		name    string
+		before  uint
+		limit   int
+		first   string
+		last    string
+		count   int
+		hasMore bool
+	}{
+		{name: "latest page", limit: 5, first: "101", last: "105", count: 5, hasMore: true},
+		{name: "older page", before: 101, limit: 5, first: "96", last: "100", count: 5, hasMore: true},
+		{name: "last page", before: 4, limit: 5, first: "1", last: "3", count: 3},
+		{name: "exactly the rest", before: 6, limit: 5, first: "1", last: "5", count: 5},
+		{name: "default limit", first: "6", last: "105", count: MaxChatHistoryPageSize, hasMore: true},
+		{name: "limit too high", limit: 1000, first: "6", last: "105", count: MaxChatHistoryPageSize, hasMore: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viewer.messages(t)
+			server.chatHistory(*viewer.client.Token, test.before, test.limit)
+
+			var history ChatHistoryMessage
+			if !lastMessage(viewer.messages(t), MessageChatHistory, &history) {
+				t.Fatal("expected chat history")
+			}
+
+			if len(history.Messages) != test.count || history.HasMore != test.hasMore {
+				t.Fatalf("expected %d messages with more %t, got %d with %t",
+					test.count, test.hasMore, len(history.Messages), history.HasMore)
+			}
+
+			first, last := history.Messages[0], history.Messages[len(history.Messages)-1]
+			if first.Message != test.first || last.Message != test.last {
+				t.Errorf("expected %s to %s, got %s to %s", test.first, test.last, first.Message, last.Message)
+			}
+
+			if first.Name != "Alice" || first.Row < 0 {
+				t.Errorf("expected who sent it and where from, got %+v", first)
+			}
+		})
+	}
+}
+
+func TestChatHistoryOnJoin(t *testing.T) {
+	server := newTestServer(t, RoomConfig{ChatHistoryLength: 2})
+	first := joinTestViewer(t, server, "Alice")
+	for _, message := range []string{"one", "two", "three"} {
+		server.chat(*first.client.Token, message)
+	}
+
+	second := joinTestViewer(t, server, "Bob")
+	var history ChatHistoryMessage
+	if !lastMessage(second.messages(t), MessageChatHistory, &history) {
+		t.Fatal("expected chat history on joining")
+	}
+
+	if len(history.Messages) != 2 || history.Messages[0].Message != "two" || !history.HasMore {
+		t.Errorf("expected the last two messages, got %+v", history)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 type MonkeyActionMessage struct {
@@ -14,6 +15,10 @@ type ChatMessage struct {
 	Message string `json:"message"`
 }
 
+type VideoListRequestMessage struct {
+	Collection string `json:"collection"`
+}
+
 type RequestPlayMessage struct {
 	Playing   bool    `json:"playing"`
 	Progress  float64 `json:"progress"`
@@ -25,18 +30,42 @@ type RequestImageMessage struct {
 	Name string `json:"name"`
 }
 
-func handleClient(client Client, serverMessage chan<- ServerMessage) {
+type SubtitleListRequestMessage struct {
+	VideoFile *string `json:"video"`
+}
+
+type RequestSubtitleMessage struct {
+	SubtitleID *uint `json:"subtitle_id"`
+}
+
+type RevokeRoleMessage struct {
+	Seat
+}
+
+func handleClient(client Client, rateLimits RateLimitConfig, serverMessage chan<- ServerMessage) {
 	serverMessage <- ServerMessage{
 		Type:   ServerMessageJoin,
 		Client: &client,
 	}
 
+	limiter := newRateLimiter(rateLimits)
 	for message := range client.Messages {
 		log.WithFields(log.Fields{
 			"token": *client.Token,
 			"type":  message.Type,
 		}).Trace("Got message")
 
+		if allowed, retryAfter := limiter.allow(message.Type, time.Now()); !allowed {
+			serverMessage <- ServerMessage{
+				Type:     ServerMessageRateLimited,
+				Token:    client.Token,
+				Action:   string(message.Type),
+				Duration: retryAfter,
+				Warn:     limiter.shouldWarn(message.Type),
+			}
+			continue
+		}
+
 		switch message.Type {
 		case MessageMonkeyAction:
 			var clapMessage MonkeyActionMessage
@@ -59,9 +88,13 @@ func handleClient(client Client, serverMessage chan<- ServerMessage) {
 			}
 
 		case MessageVideoList:
+			var listMessage VideoListRequestMessage
+			_ = json.Unmarshal(message.Data, &listMessage)
+
 			serverMessage <- ServerMessage{
-				Type:  ServerMessageVideoList,
-				Token: client.Token,
+				Type:       ServerMessageVideoList,
+				Token:      client.Token,
+				Collection: listMessage.Collection,
 			}
 
 		case MessageImageList:
@@ -99,11 +132,197 @@ func handleClient(client Client, serverMessage chan<- ServerMessage) {
 				Token: client.Token,
 			}
 
+		case MessageSubtitleList:
+			var listMessage SubtitleListRequestMessage
+			_ = json.Unmarshal(message.Data, &listMessage)
+
+			serverMessage <- ServerMessage{
+				Type:  ServerMessageSubtitleList,
+				Token: client.Token,
+				File:  listMessage.VideoFile,
+			}
+
+		case MessageRequestSubtitle:
+			var requestMessage RequestSubtitleMessage
+			_ = json.Unmarshal(message.Data, &requestMessage)
+
+			serverMessage <- ServerMessage{
+				Type:       ServerMessageRequestSubtitle,
+				Token:      client.Token,
+				SubtitleID: requestMessage.SubtitleID,
+			}
+
+		case MessageGrantRole:
+			var grantMessage GrantRoleMessage
+			_ = json.Unmarshal(message.Data, &grantMessage)
+
+			serverMessage <- ServerMessage{
+				Type:  ServerMessageGrantRole,
+				Token: client.Token,
+				Seat:  grantMessage.Seat,
+				Role:  grantMessage.Role,
+			}
+
+		case MessageRevokeRole:
+			var revokeMessage RevokeRoleMessage
+			_ = json.Unmarshal(message.Data, &revokeMessage)
+
+			serverMessage <- ServerMessage{
+				Type:  ServerMessageGrantRole,
+				Token: client.Token,
+				Seat:  revokeMessage.Seat,
+				Role:  RoleViewer,
+			}
+
+		case MessageQueueAdd:
+			var addMessage QueueAddMessage
+			_ = json.Unmarshal(message.Data, &addMessage)
+
+			serverMessage <- ServerMessage{
+				Type:  ServerMessageQueueAdd,
+				Token: client.Token,
+				File:  &addMessage.VideoFile,
+			}
+
+		case MessageQueueMove:
+			var moveMessage QueueMoveMessage
+			_ = json.Unmarshal(message.Data, &moveMessage)
+
+			serverMessage <- ServerMessage{
+				Type:     ServerMessageQueueMove,
+				Token:    client.Token,
+				ItemID:   moveMessage.ItemID,
+				Position: moveMessage.Position,
+			}
+
+		case MessageQueueRemove:
+			var removeMessage QueueRemoveMessage
+			_ = json.Unmarshal(message.Data, &removeMessage)
+
+			serverMessage <- ServerMessage{
+				Type:   ServerMessageQueueRemove,
+				Token:  client.Token,
+				ItemID: removeMessage.ItemID,
+			}
+
+		case MessageVideoEnded:
+			var endedMessage VideoEndedMessage
+			_ = json.Unmarshal(message.Data, &endedMessage)
+
+			serverMessage <- ServerMessage{
+				Type:     ServerMessageVideoEnded,
+				Token:    client.Token,
+				File:     &endedMessage.VideoFile,
+				Progress: endedMessage.Progress,
+			}
+
+		case MessagePong:
+			var pongMessage PongMessage
+			_ = json.Unmarshal(message.Data, &pongMessage)
+
+			serverMessage <- ServerMessage{
+				Type:  ServerMessagePong,
+				Token: client.Token,
+				Pong:  pongMessage,
+			}
+
+		case MessageChatHistory:
+			var historyMessage ChatHistoryRequestMessage
+			_ = json.Unmarshal(message.Data, &historyMessage)
+
+			serverMessage <- ServerMessage{
+				Type:   ServerMessageChatHistory,
+				Token:  client.Token,
+				Before: historyMessage.Before,
+				Limit:  historyMessage.Limit,
+			}
+
+		case MessageProfile:
+			var profile Profile
+			_ = json.Unmarshal(message.Data, &profile)
+
+			serverMessage <- ServerMessage{
+				Type:    ServerMessageProfile,
+				Token:   client.Token,
+				Profile: profile,
+			}
+
+		case MessageChangeSeat:
+			var changeMessage ChangeSeatMessage
+			_ = json.Unmarshal(message.Data, &changeMessage)
+
+			serverMessage <- ServerMessage{
+				Type:  ServerMessageChangeSeat,
+				Token: client.Token,
+				Seat:  changeMessage.Seat,
+			}
+
+		case MessageSeatSwapReply:
+			var replyMessage SeatSwapReplyMessage
+			_ = json.Unmarshal(message.Data, &replyMessage)
+
+			serverMessage <- ServerMessage{
+				Type:   ServerMessageSeatSwapReply,
+				Token:  client.Token,
+				Seat:   replyMessage.Seat,
+				Accept: replyMessage.Accept,
+			}
+
+		case MessageKick:
+			var kickMessage ModerateMessage
+			_ = json.Unmarshal(message.Data, &kickMessage)
+
+			serverMessage <- ServerMessage{
+				Type:        ServerMessageKickViewer,
+				Token:       client.Token,
+				Seat:        kickMessage.Seat,
+				SpectatorID: kickMessage.SpectatorID,
+				Message:     kickMessage.Reason,
+			}
+
+		case MessageBan:
+			var banMessage ModerateMessage
+			_ = json.Unmarshal(message.Data, &banMessage)
+
+			serverMessage <- ServerMessage{
+				Type:        ServerMessageBan,
+				Token:       client.Token,
+				Seat:        banMessage.Seat,
+				SpectatorID: banMessage.SpectatorID,
+				Message:     banMessage.Reason,
+				Duration:    time.Duration(banMessage.Duration * float64(time.Second)),
+			}
+
+		case MessageMute:
+			var muteMessage ModerateMessage
+			_ = json.Unmarshal(message.Data, &muteMessage)
+
+			serverMessage <- ServerMessage{
+				Type:        ServerMessageMute,
+				Token:       client.Token,
+				Seat:        muteMessage.Seat,
+				SpectatorID: muteMessage.SpectatorID,
+				Message:     muteMessage.Reason,
+				Duration:    time.Duration(muteMessage.Duration * float64(time.Second)),
+			}
+
+		case MessageUnmute:
+			var unmuteMessage UnmuteMessage
+			_ = json.Unmarshal(message.Data, &unmuteMessage)
+
+			serverMessage <- ServerMessage{
+				Type:        ServerMessageUnmute,
+				Token:       client.Token,
+				Seat:        unmuteMessage.Seat,
+				SpectatorID: unmuteMessage.SpectatorID,
+			}
+
 		case MessageDisconnect:
 			if client.Token != nil {
 				serverMessage <- ServerMessage{
-					Type:  ServerMessageLeave,
-					Token: client.Token,
+					Type:   ServerMessageLeave,
+					Client: &client,
+					Token:  client.Token,
 				}
 			}
 			return
@@ -114,8 +333,12 @@ func handleClient(client Client, serverMessage chan<- ServerMessage) {
 	}
 }
 
-func ListenForNewClients(clients <-chan Client, serverMessage chan<- ServerMessage) {
+func ListenForNewClients(clients <-chan Client, rooms *RoomRegistry) {
 	for client := range clients {
-		go handleClient(client, serverMessage)
+		room := rooms.Join(client.RoomID)
+		go func(client Client) {
+			defer rooms.Leave(room)
+			handleClient(client, rooms.config.RateLimits, room.Messages)
+		}(client)
 	}
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+// The sample with the shortest round trip is trusted the most, like NTP.
+const ClockSampleCount = 8
+
+type clockSample struct {
+	RoundTrip   time.Duration
+	ClockOffset time.Duration
+}
+
+type ClockEstimate struct {
+	samples []clockSample
+
+	Latency     time.Duration
+	ClockOffset time.Duration
+	Drift       float64
+	HasEstimate bool
+}
+
+type PingMessage struct {
+	ServerTime int64 `json:"server_time"`
+}
+
+type PongMessage struct {
+	ServerTime int64   `json:"server_time"`
+	ClientTime int64   `json:"client_time"`
+	Progress   float64 `json:"progress"`
+	Playing    bool    `json:"playing"`
+}
+
+type SyncMessage struct {
+	VideoFile  string  `json:"video"`
+	Playing    bool    `json:"playing"`
+	Progress   float64 `json:"progress"`
+	ServerTime int64   `json:"server_time"`
+}
+
+type DriftMessage struct {
+	Drift       float64 `json:"drift"`
+	Latency     float64 `json:"latency"`
+	ClockOffset float64 `json:"clock_offset"`
+	Progress    float64 `json:"progress"`
+	ServerTime  int64   `json:"server_time"`
+	Nudge       bool    `json:"nudge"`
+}
+
+func (estimate *ClockEstimate) addSample(sample clockSample) {
+	estimate.samples = append(estimate.samples, sample)
+	if len(estimate.samples) > ClockSampleCount {
+		estimate.samples = estimate.samples[1:]
+	}
+
+	best := estimate.samples[0]
+	for _, candidate := range estimate.samples[1:] {
+		if candidate.RoundTrip < best.RoundTrip {
+			best = candidate
+		}
+	}
+
+	estimate.Latency = best.RoundTrip / 2
+	estimate.ClockOffset = best.ClockOffset
+	estimate.HasEstimate = true
+}
+
+func (server *Server) sendPings() {
+	ping := PingMessage{
+		ServerTime: time.Now().UnixMilli(),
+	}
+
+	for _, client := range server.connectedClients {
+		_ = client.Send(MessagePing, ping)
+	}
+}
+
+func (server *Server) broadcastSync() {
+	if server.videoState.VideoFile == "" {
+		return
+	}
+
+	server.updateVideoState()
+	server.broadcastExcept("", MessageSync, SyncMessage{
+		VideoFile:  server.videoState.VideoFile,
+		Playing:    server.videoState.Playing,
+		Progress:   server.videoState.Progress,
+		ServerTime: server.videoState.LastProgressUpdate.UnixMilli(),
+	})
+}
+
+func (server *Server) progressAt(at time.Time) float64 {
+	if !server.videoState.Playing || server.readyBarrier.Open {
+		return server.videoState.Progress
+	}
+
+	return server.videoState.Progress + at.Sub(server.videoState.LastProgressUpdate).Seconds()
+}
+
+func (server *Server) pong(token string, pong PongMessage) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	now := time.Now()
+	sentAt := time.UnixMilli(pong.ServerTime)
+	roundTrip := now.Sub(sentAt)
+	if roundTrip < 0 || roundTrip > time.Minute {
+		log.WithField("token", token).Warn("Ignoring pong with invalid timestamp")
+		return
+	}
+
+	// The client read its clock roughly half way through the round trip.
+	clientTime := time.UnixMilli(pong.ClientTime)
+	client.Clock.addSample(clockSample{
+		RoundTrip:   roundTrip,
+		ClockOffset: clientTime.Sub(sentAt.Add(roundTrip / 2)),
+	})
+
+	// Drift only means something while everyone is meant to be playing.
+	if !server.videoState.Playing || !pong.Playing || !client.Ready {
+		client.Clock.Drift = 0
+		return
+	}
+
+	reportedAt := clientTime.Add(-client.Clock.ClockOffset)
+	client.Clock.Drift = pong.Progress - server.progressAt(reportedAt)
+
+	nudge := math.Abs(client.Clock.Drift) > server.config.DriftThreshold.Seconds()
+	if nudge {
+		log.WithFields(log.Fields{
+			"token":   token,
+			"drift":   client.Clock.Drift,
+			"latency": client.Clock.Latency,
+		}).Info("Nudging drifting client")
+	}
+
+	_ = client.Send(MessageDrift, DriftMessage{
+		Drift:       client.Clock.Drift,
+		Latency:     client.Clock.Latency.Seconds(),
+		ClockOffset: client.Clock.ClockOffset.Seconds(),
+		Progress:    server.progressAt(now),
+		ServerTime:  now.UnixMilli(),
+		Nudge:       nudge,
+	})
+}
+
+func (server *Server) clockSyncTick() {
+	if server.config.SyncInterval <= 0 {
+		return
+	}
+
+	if time.Since(server.lastClockSync) < server.config.SyncInterval {
+		return
+	}
+
+	server.lastClockSync = time.Now()
+	server.sendPings()
+	server.broadcastSync()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockEstimateAddSample(t *testing.T) {
+	tests := []struct {
+		name        string
+		roundTrips  []time.Duration
+		latency     time.Duration
+		clockOffset time.Duration
+	}{
+		{
+			name:        "single sample",
+			roundTrips:  []time.Duration{100 * time.Millisecond},
+			latency:     50 * time.Millisecond,
+			clockOffset: 100 * time.Millisecond,
+		},
+		{
+			name:        "shortest round trip",
+			roundTrips:  []time.Duration{300 * time.Millisecond, 40 * time.Millisecond, 200 * time.Millisecond},
+			latency:     20 * time.Millisecond,
+			clockOffset: 40 * time.Millisecond,
+		},
+		{
+			name: "old samples dropped",
+			roundTrips: []time.Duration{
+				10 * time.Millisecond,
+				90 * time.Millisecond, 90 * time.Millisecond, 90 * time.Millisecond, 90 * time.Millisecond,
+				90 * time.Millisecond, 90 * time.Millisecond, 90 * time.Millisecond, 80 * time.Millisecond,
+			},
+			latency:     40 * time.Millisecond,
+			clockOffset: 80 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var estimate ClockEstimate
+			for _, roundTrip := range test.roundTrips {
+				estimate.addSample(clockSample{RoundTrip: roundTrip, ClockOffset: roundTrip})
+			}
+
+			if estimate.Latency != test.latency || estimate.ClockOffset != test.clockOffset || !estimate.HasEstimate {
+				t.Errorf("expected latency %s and offset %s, got %+v", test.latency, test.clockOffset, estimate)
+			}
+
+			if len(estimate.samples) > ClockSampleCount {
+				t.Errorf("expected at most %d samples, got %d", ClockSampleCount, len(estimate.samples))
+			}
+		})
+	}
+}
+
+func TestProgressAt(t *testing.T) {
+	updatedAt := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name        string
+		playing     bool
+		barrierOpen bool
+		after       time.Duration
+		progress    float64
+	}{
+		{name: "playing", playing: true, after: 3 * time.Second, progress: 103},
+		{name: "paused", after: 3 * time.Second, progress: 100},
+		{name: "waiting for everyone to buffer", playing: true, barrierOpen: true, after: 3 * time.Second, progress: 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := Server{
+				videoState: VideoPlaybackState{
+					Playing:            test.playing,
+					Progress:           100,
+					LastProgressUpdate: updatedAt,
+				},
+				readyBarrier: ReadyBarrier{Open: test.barrierOpen},
+			}
+
+			if progress := server.progressAt(updatedAt.Add(test.after)); progress != test.progress {
+				t.Errorf("expected %f, got %f", test.progress, progress)
+			}
+		})
+	}
+}
+
+func TestPongDrift(t *testing.T) {
+	tests := []struct {
+		name        string
+		progress    float64
+		barrierOpen bool
+		drift       float64
+		nudge       bool
+	}{
+		{name: "in sync", progress: 100, drift: 0},
+		{name: "ahead", progress: 103, drift: 3, nudge: true},
+		{name: "behind", progress: 98, drift: -2, nudge: true},
+		{name: "waiting for everyone to buffer", progress: 100, barrierOpen: true, drift: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{DriftThreshold: time.Second})
+			viewer := joinTestViewer(t, server, "Alice")
+			viewer.client.Ready = true
+			server.videoState = VideoPlaybackState{
+				Playing:            true,
+				Progress:           100,
+				VideoFile:          "movie.mp4",
+				LastProgressUpdate: time.Now(),
+			}
+			server.readyBarrier.Open = test.barrierOpen
+			viewer.messages(t)
+
+			now := time.Now()
+			server.pong(*viewer.client.Token, PongMessage{
+				ServerTime: now.UnixMilli(),
+				ClientTime: now.UnixMilli(),
+				Progress:   test.progress,
+				Playing:    true,
+			})
+
+			var drift DriftMessage
+			if !lastMessage(viewer.messages(t), MessageDrift, &drift) {
+				t.Fatal("expected a drift message")
+			}
+
+			if drift.Drift < test.drift-0.5 || drift.Drift > test.drift+0.5 || drift.Nudge != test.nudge {
+				t.Errorf("expected drift %f with nudge %t, got %+v", test.drift, test.nudge, drift)
+			}
+		})
+	}
+}
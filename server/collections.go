@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"watch-party/database"
+)
+
+type CollectionData struct {
+	Path          string `json:"path"`
+	Name          string `json:"name"`
+	ThumbnailFile string `json:"thumbnail_file,omitempty"`
+	VideoCount    int    `json:"video_count"`
+}
+
+func videoCollection(video database.Video) string {
+	return parentCollection(video.VideoFilePath)
+}
+
+func parentCollection(filePath string) string {
+	parent := path.Dir(filePath)
+	if parent == "." {
+		return ""
+	}
+
+	return parent
+}
+
+func inCollection(filePath string, collectionPath string) bool {
+	return collectionPath == "" || strings.HasPrefix(filePath, collectionPath+"/")
+}
+
+func (server *Server) childCollections(collectionPath string, videos []database.Video) ([]CollectionData, error) {
+	var collections []database.Collection
+	if result := server.db.Find(&collections); result.Error != nil {
+		return nil, result.Error
+	}
+
+	children := make([]CollectionData, 0)
+	for _, collection := range collections {
+		if parentCollection(collection.Path) != collectionPath {
+			continue
+		}
+
+		videoCount := 0
+		for _, video := range videos {
+			if inCollection(video.VideoFilePath, collection.Path) {
+				videoCount += 1
+			}
+		}
+
+		if videoCount == 0 {
+			continue
+		}
+
+		children = append(children, CollectionData{
+			Path:          collection.Path,
+			Name:          collection.Title,
+			ThumbnailFile: collection.CoverThumbnail,
+			VideoCount:    videoCount,
+		})
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return strings.ToLower(children[i].Name) < strings.ToLower(children[j].Name)
+	})
+
+	return children, nil
+}
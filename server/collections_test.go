@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"watch-party/database"
+)
+
+func TestParentCollection(t *testing.T) {
+	tests := []struct {
+		filePath string
+		parent   string
+	}{
+		{filePath: "Movie.mkv", parent: ""},
+		{filePath: "Films/Movie.mkv", parent: "Films"},
+		{filePath: "Show/Season 1/Episode 1.mkv", parent: "Show/Season 1"},
+		{filePath: "Show", parent: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filePath, func(t *testing.T) {
+			if parent := parentCollection(test.filePath); parent != test.parent {
+				t.Errorf("expected %q, got %q", test.parent, parent)
+			}
+		})
+	}
+}
+
+func TestInCollection(t *testing.T) {
+	tests := []struct {
+		filePath   string
+		collection string
+		in         bool
+	}{
+		{filePath: "Movie.mkv", collection: "", in: true},
+		{filePath: "Show/Season 1/Episode 1.mkv", collection: "Show", in: true},
+		{filePath: "Show/Season 1/Episode 1.mkv", collection: "Show/Season 1", in: true},
+		{filePath: "Shows/Episode 1.mkv", collection: "Show"},
+		{filePath: "Movie.mkv", collection: "Show"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.filePath, " in ", test.collection), func(t *testing.T) {
+			if in := inCollection(test.filePath, test.collection); in != test.in {
+				t.Errorf("expected %v, got %v", test.in, in)
+			}
+		})
+	}
+}
+
+func TestChildCollections(t *testing.T) {
+	server := newTestServer(t, RoomConfig{})
+	for _, collection := range []database.Collection{
+		{Title: "Show", Path: "Show", CoverThumbnail: "cover-show.jpg"},
+		{Title: "Season 1", Path: "Show/Season 1"},
+		{Title: "Season 2", Path: "Show/Season 2"},
+		{Title: "anime", Path: "anime"},
+		{Title: "Empty", Path: "Empty"},
+	} {
+		if result := server.db.Create(&collection); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	videos := []database.Video{
+		{VideoFilePath: "Movie.mkv"},
+		{VideoFilePath: "Show/Season 1/Episode 1.mkv"},
+		{VideoFilePath: "Show/Season 1/Episode 2.mkv"},
+		{VideoFilePath: "Show/Season 2/Episode 1.mkv"},
+		{VideoFilePath: "anime/Episode 1.mkv"},
+	}
+
+	tests := []struct {
+		collection string
+		children   []CollectionData
+	}{
+		{
+			collection: "",
+			children: []CollectionData{
+				{Path: "anime", Name: "anime", VideoCount: 1},
+				{Path: "Show", Name: "Show", ThumbnailFile: "cover-show.jpg", VideoCount: 3},
+			},
+		},
+		{
+			collection: "Show",
+			children: []CollectionData{
+				{Path: "Show/Season 1", Name: "Season 1", VideoCount: 2},
+				{Path: "Show/Season 2", Name: "Season 2", VideoCount: 1},
+			},
+		},
+		{
+			collection: "Show/Season 1",
+			children:   []CollectionData{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.collection, func(t *testing.T) {
+			children, err := server.childCollections(test.collection, videos)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprintf("%+v", children) != fmt.Sprintf("%+v", test.children) {
+				t.Errorf("expected %+v, got %+v", test.children, children)
+			}
+		})
+	}
+}
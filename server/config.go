@@ -1,11 +1,53 @@
 package main
 
+import "time"
+
 const DefaultStaticFilesPath = "../client/dist"
 const DefaultVidsPath = DefaultStaticFilesPath + "/vids"
 const DefaultImagesPath = DefaultStaticFilesPath + "/images"
 const DefaultThumbnailsPath = DefaultStaticFilesPath + "/thumbnails"
+const DefaultHLSPath = DefaultStaticFilesPath + "/hls"
+const DefaultSubtitlesPath = DefaultStaticFilesPath + "/subtitles"
 const DefaultDatabasePath = DefaultStaticFilesPath + "/watch-party.db"
 
+// Partial uploads are kept out of the static files, so they're not served.
+const DefaultUploadsPath = "../uploads"
+const DefaultMaxUploadSize = 16 << 30
+const MaxUploadChunkSize = 64 << 20
+const UploadExpiry = 24 * time.Hour
+
+var UploadVideoExtensions = []string{".mp4", ".m4v", ".mkv", ".webm", ".mov", ".avi"}
+var UploadImageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+const DefaultRescanInterval = 10 * time.Minute
+
+const DefaultRoomID = "default"
+const DefaultRoomGracePeriod = 5 * time.Minute
+const DefaultSeatGracePeriod = 2 * time.Minute
+const ServerTickInterval = time.Second
+const DefaultSyncInterval = 5 * time.Second
+const DefaultDriftThreshold = time.Second
+const DefaultReadyTimeout = 15 * time.Second
+const DefaultChatHistoryLength = 50
+const DefaultInviteLifetime = 24 * time.Hour
+const DefaultSessionLifetime = 30 * 24 * time.Hour
+const DefaultOIDCGroupsClaim = "groups"
+const OIDCStateLifetime = 10 * time.Minute
+
+var DefaultOIDCScopes = []string{"openid", "profile", "email"}
+
+const MaxDisplayNameLength = 32
+
+const DefaultChatRate = 1.0
+const DefaultChatBurst = 5
+const DefaultMonkeyActionRate = 4.0
+const DefaultMonkeyActionBurst = 10
+const DefaultFloodMuteAfter = 20
+const DefaultFloodStrikeWindow = time.Minute
+const DefaultFloodMuteDuration = 2 * time.Minute
+
+var MonkeyAvatars = []string{"monkey", "gorilla", "orangutan", "chimp", "lemur"}
+
 const (
 	MessageUpdateState  = MessageType("update-state")
 	MessageMonkeyAction = MessageType("monkey-action")
@@ -16,8 +58,43 @@ const (
 	MessageRequestImage = MessageType("request-image")
 	MessageReady        = MessageType("ready")
 	MessageDisconnect   = MessageType("disconnect")
+
+	MessageSubtitleList    = MessageType("subtitle-list")
+	MessageRequestSubtitle = MessageType("request-subtitle")
+	MessageSession         = MessageType("session")
+	MessageRoles           = MessageType("roles")
+	MessageGrantRole       = MessageType("grant-role")
+	MessageRevokeRole      = MessageType("revoke-role")
+	MessageError           = MessageType("error")
+	MessageQueue           = MessageType("queue")
+	MessageQueueAdd        = MessageType("queue-add")
+	MessageQueueMove       = MessageType("queue-move")
+	MessageQueueRemove     = MessageType("queue-remove")
+	MessageVideoEnded      = MessageType("video-ended")
+	MessagePing            = MessageType("ping")
+	MessagePong            = MessageType("pong")
+	MessageSync            = MessageType("sync")
+	MessageDrift           = MessageType("drift")
+	MessageCatchingUp      = MessageType("catching-up")
+	MessageChatHistory     = MessageType("chat-history")
+	MessageProfile         = MessageType("profile")
+	MessageChangeSeat      = MessageType("change-seat")
+	MessageSeatSwapRequest = MessageType("seat-swap-request")
+	MessageSeatSwapReply   = MessageType("seat-swap-reply")
+	MessageTheaterFull     = MessageType("theater-full")
+	MessageResumeOffer     = MessageType("resume-offer")
+	MessageKick            = MessageType("kick")
+	MessageBan             = MessageType("ban")
+	MessageMute            = MessageType("mute")
+	MessageUnmute          = MessageType("unmute")
+	MessageMuted           = MessageType("muted")
+	MessageRateLimited     = MessageType("rate-limited")
 )
 
+const DefaultLayoutName = "cinema"
+
+// The seating plan drawn by the client's cinema background.
+var DefaultReservedRows = []int{0, 1}
 var RowSeatCount = []int{
 	16,
 	16,
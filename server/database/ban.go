@@ -0,0 +1,67 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type Ban struct {
+	ID      uint   `gorm:"primaryKey;autoIncrement"`
+	UserID  uint   `gorm:"index"`
+	Address string `gorm:"index"`
+	RoomID  string `gorm:"index"`
+
+	Reason    string
+	BannedBy  string
+	CreatedAt time.Time
+
+	// Nil for permanent bans.
+	ExpiresAt *time.Time
+}
+
+func (ban Ban) Permanent() bool {
+	return ban.ExpiresAt == nil
+}
+
+func activeBans(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+func CreateBan(db *gorm.DB, ban *Ban) error {
+	return db.Create(ban).Error
+}
+
+func FindBan(db *gorm.DB, roomID string, userID uint, address string) (*Ban, error) {
+	query := db.Where("address = ? AND address != ''", address)
+	if userID != 0 {
+		query = query.Or("user_id = ?", userID)
+	}
+
+	var bans []Ban
+	result := activeBans(db).
+		Where("room_id = ? OR room_id = ''", roomID).
+		Where(query).
+		Order("expires_at IS NOT NULL, expires_at DESC").
+		Limit(1).
+		Find(&bans)
+	if result.Error != nil || len(bans) == 0 {
+		return nil, result.Error
+	}
+
+	return &bans[0], nil
+}
+
+func ActiveBans(db *gorm.DB) ([]Ban, error) {
+	var bans []Ban
+	result := activeBans(db).
+		Order("created_at DESC").
+		Find(&bans)
+	return bans, result.Error
+}
+
+func PruneExpiredBans(db *gorm.DB) (int64, error) {
+	result := db.
+		Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).
+		Delete(&Ban{})
+	return result.RowsAffected, result.Error
+}
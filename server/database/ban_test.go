@@ -0,0 +1,70 @@
+package database
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFindBan(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	later := time.Now().Add(time.Hour)
+	bans := []Ban{
+		{Reason: "user in lobby", UserID: 1, RoomID: "lobby"},
+		{Reason: "user everywhere", UserID: 2},
+		{Reason: "address in lobby", Address: "10.0.0.1", RoomID: "lobby"},
+		{Reason: "address everywhere", Address: "10.0.0.2"},
+		{Reason: "expired", UserID: 3, ExpiresAt: &expired},
+		{Reason: "temporary", UserID: 4, ExpiresAt: &later},
+		{Reason: "temporary address", Address: "10.0.0.5", ExpiresAt: &later},
+		{Reason: "permanent address", Address: "10.0.0.5"},
+	}
+
+	for i := range bans {
+		if err := CreateBan(db, &bans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		roomID  string
+		userID  uint
+		address string
+		reason  string
+	}{
+		{name: "user in their room", roomID: "lobby", userID: 1, address: "10.0.1.1", reason: "user in lobby"},
+		{name: "user in another room", roomID: "cinema", userID: 1, address: "10.0.1.1"},
+		{name: "user banned everywhere", roomID: "cinema", userID: 2, address: "10.0.1.1", reason: "user everywhere"},
+		{name: "address in its room", roomID: "lobby", address: "10.0.0.1", reason: "address in lobby"},
+		{name: "address in another room", roomID: "cinema", address: "10.0.0.1"},
+		{name: "address banned everywhere", roomID: "cinema", address: "10.0.0.2", reason: "address everywhere"},
+		{name: "logged in at a banned address", roomID: "cinema", userID: 9, address: "10.0.0.2", reason: "address everywhere"},
+		{name: "expired", roomID: "lobby", userID: 3, address: "10.0.1.1"},
+		{name: "temporary", roomID: "lobby", userID: 4, address: "10.0.1.1", reason: "temporary"},
+		{name: "permanent over temporary", roomID: "lobby", address: "10.0.0.5", reason: "permanent address"},
+		{name: "not banned", roomID: "lobby", userID: 9, address: "10.0.1.1"},
+		{name: "no address", roomID: "lobby"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ban, err := FindBan(db, test.roomID, test.userID, test.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.reason == "" && ban != nil {
+				t.Errorf("expected no ban, got %q", ban.Reason)
+			}
+			if test.reason != "" && (ban == nil || ban.Reason != test.reason) {
+				t.Errorf("expected ban %q, got %+v", test.reason, ban)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type ChatMessage struct {
+	ID     uint   `gorm:"primaryKey;autoIncrement"`
+	RoomID string `gorm:"index"`
+
+	// Both -1 if the sender was spectating without a seat.
+	Row    int
+	Column int
+
+	// Zero if the sender wasn't logged in.
+	UserID uint `gorm:"index"`
+
+	DisplayName string
+	Message     string
+	SentAt      time.Time
+
+	VideoFile     string
+	VideoProgress float64
+}
+
+func SaveChatMessage(db *gorm.DB, message *ChatMessage) error {
+	return db.Create(message).Error
+}
+
+func ChatHistory(db *gorm.DB, roomID string, beforeID uint, limit int) ([]ChatMessage, error) {
+	query := db.
+		Where("room_id = ?", roomID).
+		Order("id DESC").
+		Limit(limit)
+	if beforeID != 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var messages []ChatMessage
+	if result := query.Find(&messages); result.Error != nil {
+		return nil, result.Error
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
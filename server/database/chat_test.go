@@ -0,0 +1,53 @@
+package database
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+func TestChatHistory(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, roomID := range []string{"lobby", "lobby", "cinema", "lobby", "lobby", "cinema", "lobby"} {
+		if err := SaveChatMessage(db, &ChatMessage{RoomID: roomID, Message: fmt.Sprint(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		roomID   string
+		beforeID uint
+		limit    int
+		expected string
+	}{
+		{name: "most recent", roomID: "lobby", limit: 3, expected: "[4 5 7]"},
+		{name: "everything", roomID: "lobby", limit: 10, expected: "[1 2 4 5 7]"},
+		{name: "before a message", roomID: "lobby", beforeID: 5, limit: 3, expected: "[1 2 4]"},
+		{name: "before the first message", roomID: "lobby", beforeID: 1, limit: 3, expected: "[]"},
+		{name: "other room", roomID: "cinema", limit: 3, expected: "[3 6]"},
+		{name: "empty room", roomID: "foyer", limit: 3, expected: "[]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, err := ChatHistory(db, test.roomID, test.beforeID, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			texts := []string{}
+			for _, message := range messages {
+				texts = append(texts, message.Message)
+			}
+
+			if fmt.Sprint(texts) != test.expected {
+				t.Errorf("expected %s, got %v", test.expected, texts)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Images with these names are used as their folder's cover.
+var coverImageNames = []string{"cover", "folder", "poster"}
+var coverImageExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+type Collection struct {
+	ID    uint `gorm:"primaryKey;autoIncrement"`
+	Title string
+
+	// Relative to the videos path.
+	Path string `gorm:"uniqueIndex"`
+
+	// Relative to the videos path, empty if the folder has no cover.
+	CoverFilePath   string
+	CoverModifiedAt time.Time
+	CoverThumbnail  string
+}
+
+func isCoverImage(filePath string) bool {
+	name := strings.ToLower(path.Base(filePath))
+	extension := path.Ext(name)
+	for _, coverName := range coverImageNames {
+		if name[:len(name)-len(extension)] != coverName {
+			continue
+		}
+
+		for _, coverExtension := range coverImageExtensions {
+			if extension == coverExtension {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func findCoverImage(dirPath string) (string, fs.FileInfo) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return "", nil
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isCoverImage(entry.Name()) {
+			continue
+		}
+
+		coverPath := path.Join(dirPath, entry.Name())
+		fileInfo, err := os.Stat(coverPath)
+		if err != nil {
+			continue
+		}
+
+		return coverPath, fileInfo
+	}
+
+	return "", nil
+}
+
+func removeCollectionCover(config LibraryConfig, collection *Collection) {
+	if collection.CoverThumbnail != "" {
+		err := os.Remove(path.Join(config.ThumbnailsPath, collection.CoverThumbnail))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).
+				WithField("thumbnail", collection.CoverThumbnail).
+				Warn("Unable to remove collection cover thumbnail")
+		}
+	}
+
+	collection.CoverFilePath = ""
+	collection.CoverModifiedAt = time.Time{}
+	collection.CoverThumbnail = ""
+}
+
+func syncCollection(
+	db *gorm.DB,
+	config LibraryConfig,
+	dirPath string,
+	thumbnailRequests chan<- FFMPegRequest,
+) (bool, error) {
+	collectionPath := libraryPath(config.VideosPath, dirPath)
+	if collectionPath == "." || strings.HasPrefix(collectionPath, "../") {
+		return false, nil
+	}
+
+	var collection Collection
+	result := db.
+		Where(Collection{Path: collectionPath}).
+		Attrs(Collection{Title: path.Base(collectionPath)}).
+		FirstOrInit(&collection)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	changed := collection.ID == 0
+	coverPath, coverInfo := findCoverImage(dirPath)
+	switch {
+	case coverPath == "" && collection.CoverFilePath != "":
+		removeCollectionCover(config, &collection)
+		changed = true
+
+	case coverPath != "":
+		coverFilePath := libraryPath(config.VideosPath, coverPath)
+		if coverFilePath == collection.CoverFilePath && coverInfo.ModTime().Equal(collection.CoverModifiedAt) {
+			break
+		}
+
+		collection.CoverFilePath = coverFilePath
+		collection.CoverModifiedAt = coverInfo.ModTime()
+		collection.CoverThumbnail = generateThumbnailForImageFile(
+			config.ThumbnailsPath, "cover", coverPath, coverFilePath, thumbnailRequests)
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	if result := db.Save(&collection); result.Error != nil {
+		return false, result.Error
+	}
+
+	log.WithFields(log.Fields{
+		"Title": collection.Title,
+		"Path":  collection.Path,
+		"Cover": collection.CoverFilePath,
+	}).Info("Updated collection")
+
+	return true, nil
+}
+
+func ScanForCollections(
+	db *gorm.DB,
+	config LibraryConfig,
+	scanPath string,
+	thumbnailRequests chan<- FFMPegRequest,
+) bool {
+	changed := false
+	err := filepath.WalkDir(scanPath+"/", func(dirPath string, info fs.DirEntry, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		collectionChanged, err := syncCollection(db, config, dirPath, thumbnailRequests)
+		if err != nil {
+			log.WithError(err).
+				Warnf("Could not load collection '%s'", dirPath)
+			return nil
+		}
+
+		changed = changed || collectionChanged
+		return nil
+	})
+
+	if err != nil {
+		log.WithError(err).
+			Error("Error scanning collections")
+	}
+
+	return changed
+}
+
+func PruneMissingCollections(db *gorm.DB, config LibraryConfig) int {
+	if _, err := os.Stat(config.VideosPath); err != nil {
+		return 0
+	}
+
+	var collections []Collection
+	if result := db.Find(&collections); result.Error != nil {
+		log.WithError(result.Error).
+			Error("Unable to query collections")
+		return 0
+	}
+
+	changedCount := 0
+	for _, collection := range collections {
+		dirInfo, err := os.Stat(path.Join(config.VideosPath, collection.Path))
+		if err != nil || !dirInfo.IsDir() {
+			removeCollectionCover(config, &collection)
+			if result := db.Delete(&collection); result.Error != nil {
+				log.WithError(result.Error).
+					Warnf("Could not remove collection '%s'", collection.Path)
+				continue
+			}
+
+			log.WithField("Path", collection.Path).Info("Removed missing collection")
+			changedCount += 1
+			continue
+		}
+
+		if collection.CoverFilePath == "" {
+			continue
+		}
+
+		coverPath := path.Join(config.VideosPath, collection.CoverFilePath)
+		if _, err := os.Stat(coverPath); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		removeCollectionCover(config, &collection)
+		if result := db.Save(&collection); result.Error != nil {
+			log.WithError(result.Error).
+				Warnf("Could not remove cover of collection '%s'", collection.Path)
+			continue
+		}
+
+		changedCount += 1
+	}
+
+	return changedCount
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"testing"
+)
+
+func newTestLibrary(t *testing.T, dirPaths ...string) LibraryConfig {
+	config := LibraryConfig{
+		VideosPath:     t.TempDir(),
+		ThumbnailsPath: t.TempDir(),
+	}
+
+	for _, dirPath := range dirPaths {
+		if err := os.MkdirAll(path.Join(config.VideosPath, dirPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return config
+}
+
+func collectionPaths(t *testing.T, db *gorm.DB) []string {
+	var collections []Collection
+	if result := db.Order("path").Find(&collections); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	paths := []string{}
+	for _, collection := range collections {
+		paths = append(paths, collection.Path)
+	}
+
+	return paths
+}
+
+func TestIsCoverImage(t *testing.T) {
+	tests := []struct {
+		filePath string
+		cover    bool
+	}{
+		{filePath: "Show/cover.jpg", cover: true},
+		{filePath: "Show/Folder.PNG", cover: true},
+		{filePath: "poster.webp", cover: true},
+		{filePath: "Show/cover.gif"},
+		{filePath: "Show/covers.jpg"},
+		{filePath: "Show/Episode 1.jpg"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filePath, func(t *testing.T) {
+			if cover := isCoverImage(test.filePath); cover != test.cover {
+				t.Errorf("expected cover to be %v, got %v", test.cover, cover)
+			}
+		})
+	}
+}
+
+func TestScanForCollections(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := newTestLibrary(t, "Show/Season 1", "Films")
+	writeTestFiles(t, path.Join(config.VideosPath, "Show"), "poster.jpg")
+
+	requests := make(chan FFMPegRequest, 8)
+	if !ScanForCollections(db, config, config.VideosPath, requests) {
+		t.Error("expected the first scan to find collections")
+	}
+
+	expected := fmt.Sprint([]string{"Films", "Show", "Show/Season 1"})
+	if paths := collectionPaths(t, db); fmt.Sprint(paths) != expected {
+		t.Errorf("expected collections %s, got %v", expected, paths)
+	}
+
+	var show Collection
+	if result := db.Where("path = ?", "Show").First(&show); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	if show.Title != "Show" || show.CoverFilePath != "Show/poster.jpg" {
+		t.Errorf("expected Show with its poster, got %+v", show)
+	}
+
+	if show.CoverThumbnail != thumbnailFileName("cover", "Show/poster.jpg") || len(requests) != 1 {
+		t.Errorf("expected one cover thumbnail to be queued, got %q and %d requests", show.CoverThumbnail, len(requests))
+	}
+
+	if ScanForCollections(db, config, config.VideosPath, requests) {
+		t.Error("expected nothing to change on a rescan")
+	}
+}
+
+func TestPruneMissingCollections(t *testing.T) {
+	tests := []struct {
+		name    string
+		remove  string
+		pruned  int
+		paths   []string
+		covered bool
+	}{
+		{name: "nothing missing", paths: []string{"Films", "Show"}, covered: true},
+		{name: "folder removed", remove: "Films", pruned: 1, paths: []string{"Show"}, covered: true},
+		{name: "cover removed", remove: "Show/cover.jpg", pruned: 1, paths: []string{"Films", "Show"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := newTestLibrary(t, "Show", "Films")
+			writeTestFiles(t, path.Join(config.VideosPath, "Show"), "cover.jpg")
+			ScanForCollections(db, config, config.VideosPath, make(chan FFMPegRequest, 8))
+
+			if test.remove != "" {
+				if err := os.RemoveAll(path.Join(config.VideosPath, test.remove)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if pruned := PruneMissingCollections(db, config); pruned != test.pruned {
+				t.Errorf("expected %d pruned, got %d", test.pruned, pruned)
+			}
+
+			if paths := collectionPaths(t, db); fmt.Sprint(paths) != fmt.Sprint(test.paths) {
+				t.Errorf("expected collections %v, got %v", test.paths, paths)
+			}
+
+			var show Collection
+			db.Where("path = ?", "Show").First(&show)
+			if covered := show.CoverThumbnail != ""; covered != test.covered {
+				t.Errorf("expected covered to be %v, got %+v", test.covered, show)
+			}
+		})
+	}
+}
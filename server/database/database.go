@@ -1,14 +1,31 @@
 package database
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"path"
+	"path/filepath"
+	"time"
 )
 
 const ThumbnailScale = 400
 const ThumbnailWorkerPoolCount = 4
 
+type LibraryConfig struct {
+	VideosPath     string
+	ImagesPath     string
+	ThumbnailsPath string
+	SubtitlesPath  string
+
+	// Empty if HLS transcoding is disabled.
+	HLSPath string
+
+	// Zero disables the periodic rescan.
+	RescanInterval time.Duration
+}
+
 func Open(filePath string) (*gorm.DB, error) {
 	sqliteDB := sqlite.Open(filePath)
 	db, err := gorm.Open(sqliteDB, &gorm.Config{})
@@ -16,7 +33,8 @@ func Open(filePath string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	if err := db.AutoMigrate(Video{}, Image{}); err != nil {
+	if err := db.AutoMigrate(Video{}, Image{}, Subtitle{}, Collection{}, WatchProgress{}, ChatMessage{},
+		User{}, UserSession{}, UserRoomRole{}, Ban{}); err != nil {
 		return nil, err
 	}
 
@@ -28,3 +46,19 @@ func nameFromFile(filePath string) string {
 	name := filePath[:len(filePath)-len(extension)]
 	return name
 }
+
+func libraryPath(libraryRoot string, filePath string) string {
+	relativePath, err := filepath.Rel(filepath.Clean(libraryRoot), filepath.Clean(filePath))
+	if err != nil {
+		return path.Base(filePath)
+	}
+
+	return filepath.ToSlash(relativePath)
+}
+
+// Every library shares the thumbnails folder, so the kind keeps a video and an
+// image at the same relative path apart.
+func thumbnailFileName(kind string, filePath string) string {
+	hash := sha256.Sum256([]byte(filePath))
+	return kind + "-" + hex.EncodeToString(hash[:]) + ".jpg"
+}
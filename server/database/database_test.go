@@ -0,0 +1,34 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestThumbnailFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		path   string
+		other  string
+		unique bool
+	}{
+		{name: "same file", kind: "video", path: "Films/Movie.mkv", other: "Films/Movie.mkv"},
+		{name: "slash and underscore", kind: "video", path: "Films/Movie.mkv", other: "Films_Movie.mkv", unique: true},
+		{name: "different extensions", kind: "video", path: "Movie.mkv", other: "Movie.mp4", unique: true},
+		{name: "different folders", kind: "image", path: "a/cover.jpg", other: "b/cover.jpg", unique: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := thumbnailFileName(test.kind, test.path)
+			otherFileName := thumbnailFileName(test.kind, test.other)
+			if unique := fileName != otherFileName; unique != test.unique {
+				t.Errorf("expected unique to be %v for %s and %s", test.unique, fileName, otherFileName)
+			}
+		})
+	}
+
+	if thumbnailFileName("video", "Movie.jpg") == thumbnailFileName("image", "Movie.jpg") {
+		t.Error("expected videos and images to have their own thumbnails")
+	}
+}
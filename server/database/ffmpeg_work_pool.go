@@ -12,11 +12,15 @@ type FFMPegRequest struct {
 
 	stream   *ffmpeg.Stream
 	typeName string
+
+	// Optional, called by the worker once the request has been processed.
+	onFinished func(err error)
 }
 
 func fulfillFFMPegRequest(request FFMPegRequest) {
 	name := path.Base(request.outputPath)
-	if err := request.stream.Run(); err != nil {
+	err := request.stream.Run()
+	if err != nil {
 		log.WithError(err).
 			WithFields(log.Fields{"file": name, "type": request.typeName}).
 			Warnf("Unable to process '%s'\n", request.inputPath)
@@ -24,6 +28,10 @@ func fulfillFFMPegRequest(request FFMPegRequest) {
 		log.WithFields(log.Fields{"file": name, "type": request.typeName}).
 			Info("Finished processing file")
 	}
+
+	if request.onFinished != nil {
+		request.onFinished(err)
+	}
 }
 
 func ffmpegWorker(requests <-chan FFMPegRequest) {
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var seasonEpisodePattern = regexp.MustCompile(`(?i)\bS(\d{1,2}) ?E(\d{1,3})\b`)
+var crossEpisodePattern = regexp.MustCompile(`\b(\d{1,2})x(\d{1,3})\b`)
+var yearPattern = regexp.MustCompile(`[(\[]?\b(19\d{2}|20\d{2})\b[)\]]?`)
+var qualityPattern = regexp.MustCompile(`(?i)\b(2160p|1080p|720p|576p|480p|4k|uhd)\b`)
+
+var releaseTagPattern = regexp.MustCompile(`(?i)\b(2160p|1080p|720p|576p|480p|4k|uhd|hdr|web|webrip|web-dl|webdl|` +
+	`bluray|blu-ray|bdrip|brrip|dvdrip|hdtv|hdrip|x264|x265|h264|h265|hevc|xvid|aac|ac3|dts|` +
+	`proper|repack|remux|extended|unrated|10bit)\b`)
+
+type ParsedName struct {
+	Title   string
+	Series  string
+	Season  int
+	Episode int
+	Year    int
+	Quality string
+}
+
+func cleanNamePart(name string) string {
+	name = strings.Trim(name, " -_[]()")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func titleEnd(name string) int {
+	if location := releaseTagPattern.FindStringIndex(name); location != nil {
+		return location[0]
+	}
+
+	return len(name)
+}
+
+// 'Blade Runner 2049 2017' is 'Blade Runner 2049' from 2017.
+func splitYear(title string) (string, int) {
+	locations := yearPattern.FindAllStringSubmatchIndex(title, -1)
+	if len(locations) == 0 {
+		return title, 0
+	}
+
+	location := locations[len(locations)-1]
+	if cleanNamePart(title[:location[0]]) == "" {
+		return title, 0
+	}
+
+	year, _ := strconv.Atoi(title[location[2]:location[3]])
+	return title[:location[0]] + title[location[1]:], year
+}
+
+func ParseFileName(filePath string) ParsedName {
+	name := nameFromFile(path.Base(filePath))
+
+	// Release names use dots or underscores in place of spaces
+	if !strings.Contains(name, " ") {
+		name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	}
+
+	parsed := ParsedName{
+		Title: cleanNamePart(name),
+	}
+
+	if quality := qualityPattern.FindString(name); quality != "" {
+		parsed.Quality = strings.ToLower(quality)
+	}
+
+	episodeMatch := seasonEpisodePattern.FindStringSubmatchIndex(name)
+	if episodeMatch == nil {
+		episodeMatch = crossEpisodePattern.FindStringSubmatchIndex(name)
+	}
+
+	if episodeMatch == nil {
+		title, year := splitYear(name[:titleEnd(name)])
+		if title = cleanNamePart(title); title != "" {
+			parsed.Title = title
+			parsed.Year = year
+		}
+
+		return parsed
+	}
+
+	series, year := splitYear(name[:episodeMatch[0]])
+	parsed.Series = cleanNamePart(series)
+	parsed.Year = year
+	parsed.Season, _ = strconv.Atoi(name[episodeMatch[2]:episodeMatch[3]])
+	parsed.Episode, _ = strconv.Atoi(name[episodeMatch[4]:episodeMatch[5]])
+	if parsed.Series == "" {
+		return parsed
+	}
+
+	rest := name[episodeMatch[1]:]
+	episodeTitle := cleanNamePart(rest[:titleEnd(rest)])
+	parsed.Title = fmt.Sprintf("%s S%02dE%02d", parsed.Series, parsed.Season, parsed.Episode)
+	if episodeTitle != "" {
+		parsed.Title += " - " + episodeTitle
+	}
+
+	return parsed
+}
+
+func applyParsedName(video *Video, parsed ParsedName) {
+	video.Title = parsed.Title
+	video.Series = parsed.Series
+	video.Season = parsed.Season
+	video.Episode = parsed.Episode
+	video.Year = parsed.Year
+	video.Quality = parsed.Quality
+	video.NameParsed = true
+}
+
+func backfillParsedName(db *gorm.DB, video *Video) error {
+	title := video.Title
+	applyParsedName(video, ParseFileName(path.Base(video.VideoFilePath)))
+	if video.TitleEdited {
+		video.Title = title
+	}
+
+	result := db.Save(video)
+	return result.Error
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"path"
+	"testing"
+)
+
+func TestParseFileName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		expected ParsedName
+	}{
+		{
+			fileName: "Movie Name.mp4",
+			expected: ParsedName{Title: "Movie Name"},
+		},
+		{
+			fileName: "Movie Name (2010) 720p.mp4",
+			expected: ParsedName{Title: "Movie Name", Year: 2010, Quality: "720p"},
+		},
+		{
+			fileName: "Movie.Name.2010.1080p.BluRay.x264.mkv",
+			expected: ParsedName{Title: "Movie Name", Year: 2010, Quality: "1080p"},
+		},
+		{
+			fileName: "Blade.Runner.2049.2017.2160p.mkv",
+			expected: ParsedName{Title: "Blade Runner 2049", Year: 2017, Quality: "2160p"},
+		},
+		{
+			fileName: "1917.mkv",
+			expected: ParsedName{Title: "1917"},
+		},
+		{
+			fileName: "Show.Name.S02E05.1080p.WEB.mkv",
+			expected: ParsedName{Title: "Show Name S02E05", Series: "Show Name", Season: 2, Episode: 5, Quality: "1080p"},
+		},
+		{
+			fileName: "Show Name - s1e12 - Episode Title.mp4",
+			expected: ParsedName{Title: "Show Name S01E12 - Episode Title", Series: "Show Name", Season: 1, Episode: 12},
+		},
+		{
+			fileName: "Show_Name_2005_3x07_Episode_Title_720p.avi",
+			expected: ParsedName{Title: "Show Name S03E07 - Episode Title", Series: "Show Name", Season: 3, Episode: 7, Year: 2005, Quality: "720p"},
+		},
+		{
+			fileName: "S01E01.mkv",
+			expected: ParsedName{Title: "S01E01", Season: 1, Episode: 1},
+		},
+		{
+			fileName: "Shows/Show Name/Show Name S01E02.mkv",
+			expected: ParsedName{Title: "Show Name S01E02", Series: "Show Name", Season: 1, Episode: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fileName, func(t *testing.T) {
+			if parsed := ParseFileName(test.fileName); parsed != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, parsed)
+			}
+		})
+	}
+}
+
+func TestBackfillParsedName(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		video    Video
+		expected string
+	}{
+		{
+			name:     "title from file name",
+			video:    Video{Title: "Show.Name.S01E02", VideoFilePath: "Show.Name.S01E02.mkv"},
+			expected: "Show Name S01E02",
+		},
+		{
+			name:     "edited title",
+			video:    Video{Title: "My Favourite Episode", TitleEdited: true, VideoFilePath: "Show.Name.S01E03.mkv"},
+			expected: "My Favourite Episode",
+		},
+		{
+			name:     "edited title matching the file name",
+			video:    Video{Title: "Show.Name.S01E04", TitleEdited: true, VideoFilePath: "Show.Name.S01E04.mkv"},
+			expected: "Show.Name.S01E04",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			video := test.video
+			if err := backfillParsedName(db, &video); err != nil {
+				t.Fatal(err)
+			}
+
+			if video.Title != test.expected {
+				t.Errorf("expected title %q, got %q", test.expected, video.Title)
+			}
+
+			if !video.NameParsed || video.Series != "Show Name" {
+				t.Errorf("expected the file name to be parsed, got %+v", video)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// The credits are often skipped, so videos are finished before the end.
+const MinWatchedProgress = 30.0
+const FinishedFraction = 0.95
+
+type WatchProgress struct {
+	ID      uint   `gorm:"primaryKey;autoIncrement"`
+	RoomID  string `gorm:"uniqueIndex:idx_watch_progress_room_video"`
+	VideoID uint   `gorm:"uniqueIndex:idx_watch_progress_room_video"`
+	Video   Video
+
+	Progress  float64
+	Finished  bool
+	WatchedAt time.Time `gorm:"index"`
+}
+
+// Just starting a video again doesn't lose where the room got to last time.
+func SaveWatchProgress(db *gorm.DB, roomID string, video Video, progress float64, ended bool) error {
+	finished := ended || (video.Duration > 0 && progress >= video.Duration*FinishedFraction)
+	if !finished && progress < MinWatchedProgress {
+		return nil
+	}
+
+	watchProgress := WatchProgress{
+		RoomID:    roomID,
+		VideoID:   video.ID,
+		Progress:  progress,
+		Finished:  finished,
+		WatchedAt: time.Now(),
+	}
+
+	result := db.
+		Omit(clause.Associations).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "room_id"}, {Name: "video_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"progress", "finished", "watched_at"}),
+		}).
+		Create(&watchProgress)
+	return result.Error
+}
+
+func FindWatchProgress(db *gorm.DB, roomID string, videoID uint) (*WatchProgress, error) {
+	var watchProgress []WatchProgress
+	result := db.
+		Where(WatchProgress{RoomID: roomID, VideoID: videoID}).
+		Limit(1).
+		Find(&watchProgress)
+	if result.Error != nil || len(watchProgress) == 0 {
+		return nil, result.Error
+	}
+
+	return &watchProgress[0], nil
+}
+
+func ContinueWatching(db *gorm.DB, roomID string, limit int) ([]WatchProgress, error) {
+	var watchProgress []WatchProgress
+	result := db.
+		Preload("Video").
+		Where("room_id = ? AND NOT finished", roomID).
+		Order("watched_at DESC").
+		Limit(limit).
+		Find(&watchProgress)
+	return watchProgress, result.Error
+}
+
+func RecentlyWatched(db *gorm.DB, roomID string, limit int) ([]WatchProgress, error) {
+	var watchProgress []WatchProgress
+	result := db.
+		Preload("Video").
+		Where("room_id = ?", roomID).
+		Order("watched_at DESC").
+		Limit(limit).
+		Find(&watchProgress)
+	return watchProgress, result.Error
+}
+
+func removeWatchProgressForVideo(db *gorm.DB, videoID uint) error {
+	result := db.
+		Where(WatchProgress{VideoID: videoID}).
+		Delete(&WatchProgress{})
+	return result.Error
+}
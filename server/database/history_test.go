@@ -0,0 +1,145 @@
+package database
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestHistory(t *testing.T, videoCount int) (*gorm.DB, []Video) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	videos := []Video{}
+	for i := 0; i < videoCount; i++ {
+		video := Video{VideoFilePath: fmt.Sprint("Episode ", i, ".mkv"), Duration: 1000}
+		if result := db.Create(&video); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+
+		videos = append(videos, video)
+	}
+
+	return db, videos
+}
+
+func watchedVideos(watchProgress []WatchProgress) []string {
+	videos := []string{}
+	for _, progress := range watchProgress {
+		videos = append(videos, progress.Video.VideoFilePath)
+	}
+
+	return videos
+}
+
+func TestSaveWatchProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress []float64
+		ended    bool
+		saved    bool
+		expected float64
+		finished bool
+	}{
+		{name: "just started", progress: []float64{10}},
+		{name: "partway", progress: []float64{300}, saved: true, expected: 300},
+		{name: "credits", progress: []float64{960}, saved: true, expected: 960, finished: true},
+		{name: "ended", progress: []float64{10}, ended: true, saved: true, expected: 10, finished: true},
+		{name: "watched further", progress: []float64{300, 600}, saved: true, expected: 600},
+		{name: "started again", progress: []float64{600, 10}, saved: true, expected: 600},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, videos := newTestHistory(t, 1)
+			for _, progress := range test.progress {
+				if err := SaveWatchProgress(db, "room", videos[0], progress, test.ended); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			watchProgress, err := FindWatchProgress(db, "room", videos[0].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if saved := watchProgress != nil; saved != test.saved {
+				t.Fatalf("expected saved to be %v, got %+v", test.saved, watchProgress)
+			}
+
+			if !test.saved {
+				return
+			}
+
+			if watchProgress.Progress != test.expected || watchProgress.Finished != test.finished {
+				t.Errorf("expected progress %v and finished %v, got %+v", test.expected, test.finished, watchProgress)
+			}
+
+			var count int64
+			db.Model(&WatchProgress{}).Count(&count)
+			if count != 1 {
+				t.Errorf("expected one row, got %d", count)
+			}
+		})
+	}
+}
+
+func TestWatchHistory(t *testing.T) {
+	db, videos := newTestHistory(t, 4)
+	watched := []struct {
+		roomID   string
+		video    int
+		progress float64
+	}{
+		{roomID: "room", video: 0, progress: 1000},
+		{roomID: "room", video: 1, progress: 300},
+		{roomID: "room", video: 2, progress: 500},
+		{roomID: "other", video: 3, progress: 300},
+	}
+
+	watchedAt := time.Now().Add(-time.Hour)
+	for i, watch := range watched {
+		if err := SaveWatchProgress(db, watch.roomID, videos[watch.video], watch.progress, false); err != nil {
+			t.Fatal(err)
+		}
+
+		db.Model(&WatchProgress{}).
+			Where("video_id = ?", videos[watch.video].ID).
+			Update("watched_at", watchedAt.Add(time.Duration(i)*time.Minute))
+	}
+
+	tests := []struct {
+		name     string
+		history  func(db *gorm.DB, roomID string, limit int) ([]WatchProgress, error)
+		roomID   string
+		limit    int
+		expected []string
+	}{
+		{name: "continue watching", history: ContinueWatching, roomID: "room", limit: 10,
+			expected: []string{"Episode 2.mkv", "Episode 1.mkv"}},
+		{name: "continue watching limited", history: ContinueWatching, roomID: "room", limit: 1,
+			expected: []string{"Episode 2.mkv"}},
+		{name: "recently watched", history: RecentlyWatched, roomID: "room", limit: 10,
+			expected: []string{"Episode 2.mkv", "Episode 1.mkv", "Episode 0.mkv"}},
+		{name: "other room", history: RecentlyWatched, roomID: "other", limit: 10,
+			expected: []string{"Episode 3.mkv"}},
+		{name: "new room", history: RecentlyWatched, roomID: "new", limit: 10, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			watchProgress, err := test.history(db, test.roomID, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if videos := watchedVideos(watchProgress); fmt.Sprint(videos) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, videos)
+			}
+		})
+	}
+}
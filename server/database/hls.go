@@ -0,0 +1,349 @@
+package database
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const HLSMasterPlaylistName = "master.m3u8"
+const HLSSegmentSeconds = 6
+const HLSWorkerCount = 1
+
+type HLSStatus = string
+
+const (
+	HLSNotRequested = HLSStatus("")
+	HLSPending      = HLSStatus("pending")
+	HLSReady        = HLSStatus("ready")
+	HLSFailed       = HLSStatus("failed")
+)
+
+type hlsRendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int
+	AudioBitrate int
+}
+
+var hlsLadder = []hlsRendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	{Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	{Name: "480p", Height: 480, VideoBitrate: 1400, AudioBitrate: 128},
+	{Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 96},
+}
+
+func (rendition hlsRendition) bandwidth() int {
+	return (rendition.VideoBitrate + rendition.AudioBitrate) * 1000
+}
+
+func renderHLSRendition(inputPath string, outputDir string, rendition hlsRendition) *ffmpeg.Stream {
+	return ffmpeg.Input(inputPath).
+		Output(path.Join(outputDir, "index.m3u8"), ffmpeg.KwArgs{
+			"map":     []string{"0:v:0", "0:a:0?"},
+			"vf":      fmt.Sprintf("scale=-2:%d", rendition.Height),
+			"c:v":     "libx264",
+			"preset":  "veryfast",
+			"b:v":     fmt.Sprintf("%dk", rendition.VideoBitrate),
+			"maxrate": fmt.Sprintf("%dk", rendition.VideoBitrate*107/100),
+			"bufsize": fmt.Sprintf("%dk", rendition.VideoBitrate*3/2),
+			"c:a":     "aac",
+			"b:a":     fmt.Sprintf("%dk", rendition.AudioBitrate),
+			"ac":      2,
+
+			"format":               "hls",
+			"hls_time":             HLSSegmentSeconds,
+			"hls_playlist_type":    "vod",
+			"hls_segment_filename": path.Join(outputDir, "segment_%05d.ts"),
+		}).
+		OverWriteOutput()
+}
+
+func writeHLSMasterPlaylist(outputDir string, renditions []hlsRendition) error {
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	for _, rendition := range renditions {
+		playlist.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,NAME=\"%s\"\n",
+			rendition.bandwidth(), rendition.Name))
+		playlist.WriteString(fmt.Sprintf("%s/index.m3u8\n", rendition.Name))
+	}
+
+	return os.WriteFile(path.Join(outputDir, HLSMasterPlaylistName),
+		[]byte(playlist.String()), 0644)
+}
+
+type hlsTranscode struct {
+	db         *gorm.DB
+	videoID    uint
+	generation uint
+	videoDir   string
+	outputDir  string
+	renditions []hlsRendition
+
+	mutex     sync.Mutex
+	remaining int
+	failed    bool
+}
+
+func hlsGenerationDir(videoDir string, generation uint) string {
+	return path.Join(videoDir, fmt.Sprint(generation))
+}
+
+func (transcode *hlsTranscode) removeOldGenerations() {
+	entries, err := os.ReadDir(transcode.videoDir)
+	if err != nil {
+		log.WithError(err).
+			WithField("video", transcode.videoID).
+			Warn("Unable to list old HLS transcodes")
+		return
+	}
+
+	for _, entry := range entries {
+		generation, err := strconv.ParseUint(entry.Name(), 10, 0)
+		if err == nil && uint(generation) >= transcode.generation {
+			continue
+		}
+
+		if err := os.RemoveAll(path.Join(transcode.videoDir, entry.Name())); err != nil {
+			log.WithError(err).
+				WithField("video", transcode.videoID).
+				Warn("Unable to remove old HLS transcode")
+		}
+	}
+}
+
+func (transcode *hlsTranscode) renditionFinished(err error) {
+	transcode.mutex.Lock()
+	defer transcode.mutex.Unlock()
+
+	transcode.remaining -= 1
+	if err != nil {
+		transcode.failed = true
+	}
+
+	if transcode.remaining > 0 {
+		return
+	}
+
+	status := HLSReady
+	playlistPath := path.Join(fmt.Sprint(transcode.videoID), fmt.Sprint(transcode.generation), HLSMasterPlaylistName)
+	if !transcode.failed {
+		if err := writeHLSMasterPlaylist(transcode.outputDir, transcode.renditions); err != nil {
+			log.WithError(err).
+				WithField("video", transcode.videoID).
+				Error("Unable to write HLS master playlist")
+			transcode.failed = true
+		}
+	}
+
+	if transcode.failed {
+		status = HLSFailed
+		playlistPath = ""
+	}
+
+	result := transcode.db.
+		Model(&Video{}).
+		Where("id = ? AND hls_generation = ?", transcode.videoID, transcode.generation).
+		Updates(map[string]interface{}{
+			"hls_status":        status,
+			"hls_playlist_path": playlistPath,
+		})
+	if result.Error != nil {
+		log.WithError(result.Error).
+			WithField("video", transcode.videoID).
+			Error("Unable to update HLS status")
+		return
+	}
+
+	if result.RowsAffected == 0 || transcode.failed {
+		if err := os.RemoveAll(transcode.outputDir); err != nil {
+			log.WithError(err).
+				WithField("video", transcode.videoID).
+				Warn("Unable to remove HLS transcode")
+		}
+	}
+
+	if result.RowsAffected == 0 {
+		log.WithField("video", transcode.videoID).
+			Info("Ignoring finished HLS transcode of an old version of the video")
+		return
+	}
+
+	transcode.removeOldGenerations()
+	log.WithFields(log.Fields{
+		"video":  transcode.videoID,
+		"status": status,
+	}).Info("Finished HLS transcode")
+}
+
+// There's no point scaling a video up past its own height.
+func hlsLadderFor(height int) []hlsRendition {
+	if height <= 0 {
+		return hlsLadder
+	}
+
+	var renditions []hlsRendition
+	for _, rendition := range hlsLadder {
+		if rendition.Height <= height {
+			renditions = append(renditions, rendition)
+		}
+	}
+
+	if len(renditions) == 0 {
+		renditions = hlsLadder[len(hlsLadder)-1:]
+	}
+
+	return renditions
+}
+
+type hlsJob struct {
+	video     Video
+	inputPath string
+}
+
+// Transcodes have their own workers, so a long queue of them doesn't hold up
+// thumbnails and probes.
+type hlsQueue struct {
+	db      *gorm.DB
+	hlsPath string
+
+	mutex   sync.Mutex
+	ready   *sync.Cond
+	pending []hlsJob
+}
+
+func newHLSQueue(db *gorm.DB, hlsPath string) *hlsQueue {
+	queue := &hlsQueue{
+		db:      db,
+		hlsPath: hlsPath,
+	}
+
+	queue.ready = sync.NewCond(&queue.mutex)
+	return queue
+}
+
+func (queue *hlsQueue) start(workerCount int) {
+	for i := 0; i < workerCount; i++ {
+		go queue.work()
+	}
+}
+
+func (queue *hlsQueue) add(video Video, inputPath string) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	job := hlsJob{video: video, inputPath: inputPath}
+	for i, pending := range queue.pending {
+		if pending.video.ID == video.ID {
+			queue.pending[i] = job
+			return
+		}
+	}
+
+	queue.pending = append(queue.pending, job)
+	queue.ready.Signal()
+}
+
+func (queue *hlsQueue) next() hlsJob {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for len(queue.pending) == 0 {
+		queue.ready.Wait()
+	}
+
+	job := queue.pending[0]
+	queue.pending = queue.pending[1:]
+	return job
+}
+
+func (queue *hlsQueue) work() {
+	for {
+		job := queue.next()
+		transcode := queue.begin(job.video)
+		if transcode == nil {
+			continue
+		}
+
+		for _, rendition := range transcode.renditions {
+			renditionDir := path.Join(transcode.outputDir, rendition.Name)
+			fulfillFFMPegRequest(FFMPegRequest{
+				inputPath:  job.inputPath,
+				outputPath: path.Join(renditionDir, "index.m3u8"),
+				stream:     renderHLSRendition(job.inputPath, renditionDir, rendition),
+				typeName:   "hls-" + rendition.Name,
+				onFinished: transcode.renditionFinished,
+			})
+		}
+	}
+}
+
+func (queue *hlsQueue) begin(video Video) *hlsTranscode {
+	err := queue.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&video).
+			Updates(map[string]interface{}{
+				"hls_status":     HLSPending,
+				"hls_generation": gorm.Expr("hls_generation + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.First(&video, video.ID).Error
+	})
+	if err != nil {
+		log.WithError(err).
+			WithField("video", video.ID).
+			Error("Unable to update HLS status")
+		return nil
+	}
+
+	videoDir := path.Join(queue.hlsPath, fmt.Sprint(video.ID))
+	outputDir := hlsGenerationDir(videoDir, video.HLSGeneration)
+	renditions := hlsLadderFor(video.Height)
+	for _, rendition := range renditions {
+		if err := createDirIfNotExist(path.Join(outputDir, rendition.Name)); err != nil {
+			log.WithError(err).
+				WithField("path", outputDir).
+				Error("Need path to store HLS renditions")
+			return nil
+		}
+	}
+
+	return &hlsTranscode{
+		db:         queue.db,
+		videoID:    video.ID,
+		generation: video.HLSGeneration,
+		videoDir:   videoDir,
+		outputDir:  outputDir,
+		renditions: renditions,
+		remaining:  len(renditions),
+	}
+}
+
+func queueUnfinishedHLSTranscodes(db *gorm.DB, videosPath string, queue *hlsQueue) {
+	var videos []Video
+	result := db.
+		Where("hls_status IS NULL OR hls_status IN ?", []HLSStatus{HLSNotRequested, HLSPending}).
+		Find(&videos)
+	if result.Error != nil {
+		log.WithError(result.Error).
+			Error("Unable to query videos needing HLS transcode")
+		return
+	}
+
+	log.WithField("count", len(videos)).
+		Info("Queueing HLS transcodes")
+
+	for _, video := range videos {
+		queue.add(video, path.Join(videosPath, video.VideoFilePath))
+	}
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func renditionNames(renditions []hlsRendition) []string {
+	names := []string{}
+	for _, rendition := range renditions {
+		names = append(names, rendition.Name)
+	}
+
+	return names
+}
+
+func TestHLSLadderFor(t *testing.T) {
+	tests := []struct {
+		height   int
+		expected string
+	}{
+		{height: 0, expected: "[1080p 720p 480p 360p]"},
+		{height: 2160, expected: "[1080p 720p 480p 360p]"},
+		{height: 720, expected: "[720p 480p 360p]"},
+		{height: 576, expected: "[480p 360p]"},
+		{height: 240, expected: "[360p]"},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.height), func(t *testing.T) {
+			if names := fmt.Sprint(renditionNames(hlsLadderFor(test.height))); names != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestHLSQueueAdd(t *testing.T) {
+	queue := newHLSQueue(nil, "")
+	queue.add(Video{ID: 1, Title: "first"}, "first.mkv")
+	queue.add(Video{ID: 2, Title: "second"}, "second.mkv")
+	queue.add(Video{ID: 1, Title: "first again"}, "first.mkv")
+
+	if len(queue.pending) != 2 {
+		t.Fatalf("expected 2 pending transcodes, got %d", len(queue.pending))
+	}
+
+	if first := queue.next(); first.video.ID != 1 || first.video.Title != "first again" {
+		t.Errorf("expected the latest version of the first video, got %+v", first.video)
+	}
+
+	if second := queue.next(); second.video.ID != 2 {
+		t.Errorf("expected the second video, got %+v", second.video)
+	}
+}
+
+func TestHLSQueueBegin(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video := Video{VideoFilePath: "movie.mkv", Height: 480, HLSGeneration: 4}
+	if err := db.Create(&video).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	hlsPath := t.TempDir()
+	transcode := newHLSQueue(db, hlsPath).begin(video)
+	if transcode == nil {
+		t.Fatal("expected the transcode to start")
+	}
+
+	if transcode.generation != 5 {
+		t.Errorf("expected generation 5, got %d", transcode.generation)
+	}
+
+	expectedDir := path.Join(hlsPath, fmt.Sprint(video.ID), "5")
+	if transcode.outputDir != expectedDir {
+		t.Errorf("expected output in %s, got %s", expectedDir, transcode.outputDir)
+	}
+
+	for _, name := range []string{"480p", "360p"} {
+		if _, err := os.Stat(path.Join(expectedDir, name)); err != nil {
+			t.Errorf("expected a directory for %s: %v", name, err)
+		}
+	}
+
+	var saved Video
+	db.First(&saved, video.ID)
+	if saved.HLSStatus != HLSPending || saved.HLSGeneration != 5 {
+		t.Errorf("expected pending generation 5, got %s generation %d", saved.HLSStatus, saved.HLSGeneration)
+	}
+}
+
+func TestHLSRenditionFinished(t *testing.T) {
+	tests := []struct {
+		name            string
+		videoGeneration uint
+		generation      uint
+		failed          bool
+		status          HLSStatus
+		playlist        string
+		outputKept      bool
+		oldRemoved      bool
+	}{
+		{
+			name:            "current generation",
+			videoGeneration: 3,
+			generation:      3,
+			status:          HLSReady,
+			playlist:        "1/3/" + HLSMasterPlaylistName,
+			outputKept:      true,
+			oldRemoved:      true,
+		},
+		{
+			name:            "failed rendition",
+			videoGeneration: 3,
+			generation:      3,
+			failed:          true,
+			status:          HLSFailed,
+			oldRemoved:      true,
+		},
+		{
+			name:            "old generation",
+			videoGeneration: 4,
+			generation:      3,
+			status:          HLSPending,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			video := Video{VideoFilePath: "movie.mkv", HLSStatus: HLSPending, HLSGeneration: test.videoGeneration}
+			if err := db.Create(&video).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			videoDir := path.Join(t.TempDir(), fmt.Sprint(video.ID))
+			outputDir := hlsGenerationDir(videoDir, test.generation)
+			oldDirs := []string{hlsGenerationDir(videoDir, 1), path.Join(videoDir, "1080p")}
+			newerDir := hlsGenerationDir(videoDir, test.generation+1)
+			for _, dirPath := range append(oldDirs, outputDir, newerDir) {
+				if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			transcode := &hlsTranscode{
+				db:         db,
+				videoID:    video.ID,
+				generation: test.generation,
+				videoDir:   videoDir,
+				outputDir:  outputDir,
+				renditions: hlsLadder[:2],
+				remaining:  2,
+			}
+
+			transcode.renditionFinished(nil)
+			if test.failed {
+				transcode.renditionFinished(fmt.Errorf("ffmpeg failed"))
+			} else {
+				transcode.renditionFinished(nil)
+			}
+
+			var saved Video
+			db.First(&saved, video.ID)
+			if saved.HLSStatus != test.status || saved.HLSPlaylistPath != test.playlist {
+				t.Errorf("expected %q with playlist %q, got %q with %q",
+					test.status, test.playlist, saved.HLSStatus, saved.HLSPlaylistPath)
+			}
+
+			if _, err := os.Stat(path.Join(outputDir, HLSMasterPlaylistName)); (err == nil) != test.outputKept {
+				t.Errorf("expected output kept to be %t, got %v", test.outputKept, err)
+			}
+
+			for _, dirPath := range oldDirs {
+				if _, err := os.Stat(dirPath); os.IsNotExist(err) != test.oldRemoved {
+					t.Errorf("expected %s removed to be %t", dirPath, test.oldRemoved)
+				}
+			}
+
+			if _, err := os.Stat(newerDir); err != nil {
+				t.Error("expected a newer transcode to be left alone")
+			}
+		})
+	}
+}
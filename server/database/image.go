@@ -1,20 +1,25 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"gorm.io/gorm"
 	"io/fs"
+	"os"
 	"path"
 	"path/filepath"
+	"time"
 )
 
 type Image struct {
-	ID            uint `gorm:"primaryKey;autoIncrement"`
-	Title         string
-	ThumbnailPath string
-	FilePath      string
+	ID             uint `gorm:"primaryKey;autoIncrement"`
+	Title          string
+	ThumbnailPath  string
+	FilePath       string
+	FileSize       int64
+	FileModifiedAt time.Time
 }
 
 func renderImageThumbnail(inputPath string, outputPath string) *ffmpeg.Stream {
@@ -26,12 +31,12 @@ func renderImageThumbnail(inputPath string, outputPath string) *ffmpeg.Stream {
 
 func generateThumbnailForImageFile(
 	thumbnailPath string,
+	kind string,
 	inputPath string,
+	imageFilePath string,
 	thumbnailRequests chan<- FFMPegRequest,
 ) string {
-	name := path.Base(inputPath)
-	fileName := fmt.Sprintf("%s.jpg", nameFromFile(name))
-
+	fileName := thumbnailFileName(kind, imageFilePath)
 	outputPath := path.Join(thumbnailPath, fileName)
 	thumbnailRequests <- FFMPegRequest{
 		inputPath:  inputPath,
@@ -45,17 +50,20 @@ func generateThumbnailForImageFile(
 
 func createFileImage(
 	db *gorm.DB,
-	videoPath string,
-	thumbnailPath string,
+	config LibraryConfig,
+	imagePath string,
+	fileInfo fs.FileInfo,
 	thumbnailRequests chan<- FFMPegRequest,
 ) (Image, error) {
-	thumbnail := generateThumbnailForImageFile(thumbnailPath, videoPath, thumbnailRequests)
-	videoFilePath := path.Base(videoPath)
-	title := nameFromFile(videoFilePath)
+	imageFilePath := libraryPath(config.ImagesPath, imagePath)
+	thumbnail := generateThumbnailForImageFile(config.ThumbnailsPath, "image", imagePath, imageFilePath, thumbnailRequests)
+	title := nameFromFile(path.Base(imageFilePath))
 	image := Image{
-		Title:         title,
-		ThumbnailPath: thumbnail,
-		FilePath:      videoFilePath,
+		Title:          title,
+		ThumbnailPath:  thumbnail,
+		FilePath:       imageFilePath,
+		FileSize:       fileInfo.Size(),
+		FileModifiedAt: fileInfo.ModTime(),
 	}
 
 	if result := db.Create(&image); result.Error != nil {
@@ -65,54 +73,205 @@ func createFileImage(
 	log.WithFields(log.Fields{
 		"Title":     title,
 		"Thumbnail": thumbnail,
-		"File":      videoFilePath,
+		"File":      imageFilePath,
 	}).Info("Registered new file image")
 
 	return image, nil
 }
 
-func imageExists(db *gorm.DB, filePath string) (bool, error) {
-	var count int64
+func updateFileImage(
+	db *gorm.DB,
+	image *Image,
+	imagePath string,
+	fileInfo fs.FileInfo,
+	thumbnailPath string,
+	thumbnailRequests chan<- FFMPegRequest,
+) (bool, error) {
+	if image.FileSize == fileInfo.Size() && image.FileModifiedAt.Equal(fileInfo.ModTime()) {
+		return false, nil
+	}
+
+	changed := !image.FileModifiedAt.IsZero()
+	image.FileSize = fileInfo.Size()
+	image.FileModifiedAt = fileInfo.ModTime()
+	if changed {
+		image.ThumbnailPath = generateThumbnailForImageFile(thumbnailPath, "image", imagePath, image.FilePath, thumbnailRequests)
+	}
+
+	if result := db.Save(image); result.Error != nil {
+		return false, result.Error
+	}
+
+	if changed {
+		log.WithFields(log.Fields{
+			"Title": image.Title,
+			"File":  image.FilePath,
+		}).Info("Updated changed file image")
+	}
+
+	return changed, nil
+}
+
+func removeFileImage(db *gorm.DB, image Image, thumbnailsPath string) error {
+	if result := db.Delete(&image); result.Error != nil {
+		return result.Error
+	}
+
+	if err := os.Remove(path.Join(thumbnailsPath, image.ThumbnailPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).
+			WithField("thumbnail", image.ThumbnailPath).
+			Warn("Unable to remove image thumbnail")
+	}
+
+	log.WithFields(log.Fields{
+		"Title": image.Title,
+		"File":  image.FilePath,
+	}).Info("Removed missing file image")
+
+	return nil
+}
+
+func findFileImage(db *gorm.DB, config LibraryConfig, filePath string) (*Image, error) {
+	imageFilePath := libraryPath(config.ImagesPath, filePath)
+	var images []Image
 	result := db.
-		Model(&Image{}).
-		Where(Image{FilePath: path.Base(filePath)}).
-		Count(&count)
+		Where(Image{FilePath: imageFilePath}).
+		Limit(1).
+		Find(&images)
 	if result.Error != nil {
-		return false, result.Error
+		return nil, result.Error
+	}
+
+	if len(images) > 0 {
+		return &images[0], nil
+	}
+
+	return adoptLegacyFileImage(db, config, imageFilePath)
+}
+
+func adoptLegacyFileImage(db *gorm.DB, config LibraryConfig, imageFilePath string) (*Image, error) {
+	fileName := path.Base(imageFilePath)
+	if fileName == imageFilePath {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(path.Join(config.ImagesPath, fileName)); err == nil {
+		return nil, nil
 	}
 
-	return count > 0, nil
+	var images []Image
+	result := db.
+		Where(Image{FilePath: fileName}).
+		Limit(1).
+		Find(&images)
+	if result.Error != nil || len(images) == 0 {
+		return nil, result.Error
+	}
+
+	image := &images[0]
+	image.FilePath = imageFilePath
+	if result := db.Model(image).Update("file_path", imageFilePath); result.Error != nil {
+		return nil, result.Error
+	}
+
+	log.WithFields(log.Fields{
+		"Title": image.Title,
+		"File":  imageFilePath,
+	}).Info("Moved file image into its folder")
+
+	return image, nil
+}
+
+func syncFileImage(
+	db *gorm.DB,
+	config LibraryConfig,
+	filePath string,
+	thumbnailRequests chan<- FFMPegRequest,
+) (*Image, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := findFileImage(db, config, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if image == nil {
+		newImage, err := createFileImage(db, config, filePath, fileInfo, thumbnailRequests)
+		if err != nil {
+			return nil, err
+		}
+
+		return &newImage, nil
+	}
+
+	changed, err := updateFileImage(db, image, filePath, fileInfo, config.ThumbnailsPath, thumbnailRequests)
+	if err != nil || !changed {
+		return nil, err
+	}
+
+	return image, nil
 }
 
 func ScanForNewFileImages(
 	db *gorm.DB,
-	imagesPath string,
-	thumbnailsPath string,
+	config LibraryConfig,
+	scanPath string,
 	thumbnailRequests chan<- FFMPegRequest,
-) {
-	log.Infof("Scanning '%s' for new file images", imagesPath)
-	if err := createDirIfNotExist(thumbnailsPath); err != nil {
+) []Image {
+	log.Infof("Scanning '%s' for new file images", scanPath)
+	if err := createDirIfNotExist(config.ThumbnailsPath); err != nil {
 		log.WithError(err).
-			WithField("path", thumbnailsPath).
+			WithField("path", config.ThumbnailsPath).
 			Error("Need path to store thumbnails")
-		return
+		return nil
 	}
 
-	err := filepath.WalkDir(imagesPath+"/", func(filePath string, info fs.DirEntry, err error) error {
+	var changedImages []Image
+	err := filepath.WalkDir(scanPath+"/", func(filePath string, info fs.DirEntry, err error) error {
 		if err != nil || info.IsDir() {
 			return nil
 		}
 
-		exists, err := imageExists(db, filePath)
+		image, err := syncFileImage(db, config, filePath, thumbnailRequests)
 		if err != nil {
-			return err
+			log.WithError(err).
+				Warnf("Could not load image file '%s'", filePath)
+			return nil
+		}
+
+		if image != nil {
+			changedImages = append(changedImages, *image)
 		}
 
-		if !exists {
-			if _, err := createFileImage(db, filePath, thumbnailsPath, thumbnailRequests); err != nil {
-				log.WithError(err).
-					Warnf("Could not load image file '%s'", filePath)
-			}
+		return nil
+	})
+
+	if err != nil {
+		log.WithError(err).
+			Error("Error scanning image files")
+	}
+
+	return changedImages
+}
+
+func PruneMissingFileImages(db *gorm.DB, config LibraryConfig) int {
+	imagesPath := config.ImagesPath
+
+	// Never treat an unmounted or missing library as empty
+	if _, err := os.Stat(imagesPath); err != nil {
+		log.WithError(err).
+			WithField("path", imagesPath).
+			Warn("Skipping prune of missing image library")
+		return 0
+	}
+
+	existingFiles := map[string]bool{}
+	err := filepath.WalkDir(imagesPath+"/", func(filePath string, info fs.DirEntry, err error) error {
+		if err == nil && !info.IsDir() {
+			existingFiles[libraryPath(imagesPath, filePath)] = true
 		}
 
 		return nil
@@ -121,5 +280,30 @@ func ScanForNewFileImages(
 	if err != nil {
 		log.WithError(err).
 			Error("Error scanning image files")
+		return 0
+	}
+
+	var images []Image
+	if result := db.Find(&images); result.Error != nil {
+		log.WithError(result.Error).
+			Error("Unable to query images")
+		return 0
 	}
+
+	removedCount := 0
+	for _, image := range images {
+		if existingFiles[image.FilePath] {
+			continue
+		}
+
+		if err := removeFileImage(db, image, config.ThumbnailsPath); err != nil {
+			log.WithError(err).
+				Warnf("Could not remove image file '%s'", image.FilePath)
+			continue
+		}
+
+		removedCount += 1
+	}
+
+	return removedCount
 }
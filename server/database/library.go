@@ -0,0 +1,127 @@
+package database
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"os"
+	"path"
+)
+
+type Library struct {
+	db       *gorm.DB
+	config   LibraryConfig
+	requests chan<- FFMPegRequest
+	changes  chan<- LibraryChange
+	rescans  chan struct{}
+	added    chan string
+}
+
+func NewLibrary(
+	db *gorm.DB,
+	config LibraryConfig,
+	requests chan<- FFMPegRequest,
+	changes chan<- LibraryChange,
+) *Library {
+	return &Library{
+		db:       db,
+		config:   config,
+		requests: requests,
+		changes:  changes,
+		rescans:  make(chan struct{}, 1),
+		added:    make(chan string),
+	}
+}
+
+func (library *Library) Watch() {
+	WatchLibrary(library.db, library.config, library.requests, library.changes, library.rescans, library.added)
+}
+
+func (library *Library) VideosPath() string {
+	return library.config.VideosPath
+}
+
+func (library *Library) ImagesPath() string {
+	return library.config.ImagesPath
+}
+
+func (library *Library) AddFile(filePath string) {
+	// The watcher may be busy scanning, so don't hold up the caller.
+	go func() {
+		library.added <- filePath
+	}()
+}
+
+func (library *Library) Rescan() {
+	select {
+	case library.rescans <- struct{}{}:
+	default:
+	}
+}
+
+func (library *Library) RegenerateVideoThumbnail(video Video) error {
+	videoPath := path.Join(library.config.VideosPath, video.VideoFilePath)
+	if _, err := os.Stat(videoPath); err != nil {
+		return err
+	}
+
+	// Older thumbnails were named differently.
+	fileName := thumbnailFileName("video", video.VideoFilePath)
+	if video.ThumbnailPath != fileName {
+		if result := library.db.Model(&video).Update("thumbnail_path", fileName); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	// The workers may all be busy, so don't hold up the caller.
+	go generateThumbnailForVideoFile(library.config.ThumbnailsPath, videoPath, video.VideoFilePath, library.requests)
+	return nil
+}
+
+func (library *Library) RegenerateImageThumbnail(image Image) error {
+	imagePath := path.Join(library.config.ImagesPath, image.FilePath)
+	if _, err := os.Stat(imagePath); err != nil {
+		return err
+	}
+
+	fileName := thumbnailFileName("image", image.FilePath)
+	if image.ThumbnailPath != fileName {
+		if result := library.db.Model(&image).Update("thumbnail_path", fileName); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	go generateThumbnailForImageFile(library.config.ThumbnailsPath, "image", imagePath, image.FilePath, library.requests)
+	return nil
+}
+
+// The file has to go too, or the next scan would just find it again.
+func (library *Library) DeleteVideo(video Video) error {
+	videoPath := path.Join(library.config.VideosPath, video.VideoFilePath)
+	if err := os.Remove(videoPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := removeFileVideo(library.db, library.config, video); err != nil {
+		return err
+	}
+
+	log.WithField("File", video.VideoFilePath).Info("Deleted video")
+	library.changes <- VideoLibraryChanged
+	return nil
+}
+
+func (library *Library) DeleteImage(image Image) error {
+	imagePath := path.Join(library.config.ImagesPath, image.FilePath)
+	if err := os.Remove(imagePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := removeFileImage(library.db, image, library.config.ThumbnailsPath); err != nil {
+		return err
+	}
+
+	log.WithField("File", image.FilePath).Info("Deleted image")
+	library.changes <- ImageLibraryChanged
+	return nil
+}
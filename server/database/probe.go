@@ -0,0 +1,124 @@
+package database
+
+import (
+	"encoding/json"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"gorm.io/gorm"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var browserContainers = []string{".mp4", ".m4v", ".webm"}
+var browserVideoCodecs = []string{"h264", "vp8", "vp9", "av1"}
+var browserAudioCodecs = []string{"aac", "mp3", "opus", "vorbis", "flac"}
+
+type MediaTrack struct {
+	Index    int    `json:"index"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+type probedMedia struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+
+	Streams []struct {
+		Index     int    `json:"index"`
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		Tags      struct {
+			Language string `json:"language"`
+			Title    string `json:"title"`
+		} `json:"tags"`
+		Disposition struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func browserPlayable(video *Video) bool {
+	extension := strings.ToLower(path.Ext(video.VideoFilePath))
+	if !contains(browserContainers, extension) || !contains(browserVideoCodecs, video.VideoCodec) {
+		return false
+	}
+
+	return video.AudioCodec == "" || contains(browserAudioCodecs, video.AudioCodec)
+}
+
+// Only tried once per version of the file.
+func probeVideo(db *gorm.DB, video *Video, videoPath string) error {
+	video.MetadataProbed = true
+	probeOutput, err := ffmpeg.Probe(videoPath)
+	if err == nil {
+		var probed probedMedia
+		if err = json.Unmarshal([]byte(probeOutput), &probed); err == nil {
+			applyProbedMedia(video, probed)
+		}
+	}
+
+	if result := db.Save(video); result.Error != nil {
+		return result.Error
+	}
+
+	return err
+}
+
+func applyProbedMedia(video *Video, probed probedMedia) {
+	video.Container = probed.Format.FormatName
+	video.Duration, _ = strconv.ParseFloat(probed.Format.Duration, 64)
+	video.Bitrate, _ = strconv.ParseInt(probed.Format.BitRate, 10, 64)
+	video.VideoCodec = ""
+	video.AudioCodec = ""
+	video.AudioTracks = nil
+	video.SubtitleTracks = nil
+
+	for _, stream := range probed.Streams {
+		track := MediaTrack{
+			Index:    stream.Index,
+			Codec:    stream.CodecName,
+			Language: stream.Tags.Language,
+			Title:    stream.Tags.Title,
+		}
+
+		switch stream.CodecType {
+		case "video":
+			// Cover art is stored as a video stream too.
+			if video.VideoCodec != "" || stream.Disposition.AttachedPic != 0 {
+				continue
+			}
+
+			video.VideoCodec = stream.CodecName
+			video.Width = stream.Width
+			video.Height = stream.Height
+
+		case "audio":
+			if video.AudioCodec == "" {
+				video.AudioCodec = stream.CodecName
+			}
+
+			video.AudioTracks = append(video.AudioTracks, track)
+
+		case "subtitle":
+			video.SubtitleTracks = append(video.SubtitleTracks, track)
+		}
+	}
+
+	video.BrowserUnsupported = !browserPlayable(video)
+}
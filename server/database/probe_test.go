@@ -0,0 +1,109 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestBrowserPlayable(t *testing.T) {
+	tests := []struct {
+		name     string
+		video    Video
+		playable bool
+	}{
+		{name: "mp4", video: Video{VideoFilePath: "Movie.mp4", VideoCodec: "h264", AudioCodec: "aac"}, playable: true},
+		{name: "upper case extension", video: Video{VideoFilePath: "Movie.WEBM", VideoCodec: "vp9", AudioCodec: "opus"}, playable: true},
+		{name: "silent", video: Video{VideoFilePath: "Movie.m4v", VideoCodec: "av1"}, playable: true},
+		{name: "mkv", video: Video{VideoFilePath: "Movie.mkv", VideoCodec: "h264", AudioCodec: "aac"}},
+		{name: "hevc", video: Video{VideoFilePath: "Movie.mp4", VideoCodec: "hevc", AudioCodec: "aac"}},
+		{name: "ac3", video: Video{VideoFilePath: "Movie.mp4", VideoCodec: "h264", AudioCodec: "ac3"}},
+		{name: "not probed", video: Video{VideoFilePath: "Movie.mp4"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if playable := browserPlayable(&test.video); playable != test.playable {
+				t.Errorf("expected playable to be %v, got %v", test.playable, playable)
+			}
+		})
+	}
+}
+
+func TestApplyProbedMedia(t *testing.T) {
+	tests := []struct {
+		name      string
+		filePath  string
+		probed    string
+		expected  Video
+		audio     []MediaTrack
+		subtitles []MediaTrack
+	}{
+		{
+			name:     "mp4",
+			filePath: "Movie.mp4",
+			probed: `{
+				"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "5400.250000", "bit_rate": "2500000"},
+				"streams": [
+					{"index": 0, "codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080},
+					{"index": 1, "codec_type": "audio", "codec_name": "aac", "tags": {"language": "eng"}}
+				]
+			}`,
+			expected: Video{Container: "mov,mp4,m4a,3gp,3g2,mj2", Duration: 5400.25, Bitrate: 2500000,
+				VideoCodec: "h264", AudioCodec: "aac", Width: 1920, Height: 1080},
+			audio: []MediaTrack{{Index: 1, Codec: "aac", Language: "eng"}},
+		},
+		{
+			name:     "mkv with cover art and tracks",
+			filePath: "Movie.mkv",
+			probed: `{
+				"format": {"format_name": "matroska,webm", "duration": "60.000000"},
+				"streams": [
+					{"index": 0, "codec_type": "video", "codec_name": "mjpeg", "width": 600, "height": 900, "disposition": {"attached_pic": 1}},
+					{"index": 1, "codec_type": "video", "codec_name": "hevc", "width": 3840, "height": 2160},
+					{"index": 2, "codec_type": "audio", "codec_name": "eac3", "tags": {"language": "eng", "title": "Surround"}},
+					{"index": 3, "codec_type": "audio", "codec_name": "aac", "tags": {"language": "fre"}},
+					{"index": 4, "codec_type": "subtitle", "codec_name": "subrip", "tags": {"language": "eng", "title": "SDH"}}
+				]
+			}`,
+			expected: Video{Container: "matroska,webm", Duration: 60, VideoCodec: "hevc", AudioCodec: "eac3",
+				Width: 3840, Height: 2160, BrowserUnsupported: true},
+			audio: []MediaTrack{
+				{Index: 2, Codec: "eac3", Language: "eng", Title: "Surround"},
+				{Index: 3, Codec: "aac", Language: "fre"},
+			},
+			subtitles: []MediaTrack{{Index: 4, Codec: "subrip", Language: "eng", Title: "SDH"}},
+		},
+		{
+			name:     "no streams",
+			filePath: "Movie.webm",
+			probed:   `{"format": {"format_name": "webm", "duration": "N/A"}}`,
+			expected: Video{Container: "webm", BrowserUnsupported: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var probed probedMedia
+			if err := json.Unmarshal([]byte(test.probed), &probed); err != nil {
+				t.Fatal(err)
+			}
+
+			// Stale tracks from the last probe shouldn't survive.
+			video := Video{
+				VideoFilePath:  test.filePath,
+				AudioCodec:     "mp3",
+				AudioTracks:    []MediaTrack{{Index: 9, Codec: "mp3"}},
+				SubtitleTracks: []MediaTrack{{Index: 10, Codec: "ass"}},
+			}
+			applyProbedMedia(&video, probed)
+
+			test.expected.VideoFilePath = test.filePath
+			test.expected.AudioTracks = test.audio
+			test.expected.SubtitleTracks = test.subtitles
+			if fmt.Sprintf("%+v", video) != fmt.Sprintf("%+v", test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, video)
+			}
+		})
+	}
+}
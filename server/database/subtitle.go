@@ -0,0 +1,411 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"gorm.io/gorm"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type SubtitleSourceType = string
+
+const (
+	SubtitleSidecarSource  = SubtitleSourceType("sidecar")
+	SubtitleEmbeddedSource = SubtitleSourceType("embedded")
+)
+
+type SubtitleStatus = string
+
+const (
+	SubtitlePending = SubtitleStatus("pending")
+	SubtitleReady   = SubtitleStatus("ready")
+	SubtitleFailed  = SubtitleStatus("failed")
+)
+
+var sidecarSubtitleExtensions = []string{".srt", ".ass", ".ssa", ".vtt"}
+
+// Bitmap subtitles, like PGS and VobSub, would need OCR.
+var textSubtitleCodecs = []string{"subrip", "srt", "ass", "ssa", "webvtt", "mov_text", "text"}
+
+type Subtitle struct {
+	ID       uint `gorm:"primaryKey;autoIncrement"`
+	VideoID  uint `gorm:"index"`
+	Label    string
+	Language string
+
+	SourceType SubtitleSourceType
+
+	// Relative to the videos path, only for sidecar subtitles.
+	SourceFilePath string
+
+	// Only for embedded subtitles.
+	StreamIndex int
+
+	// The converted WebVTT file, relative to the subtitles path.
+	FilePath string
+	Status   SubtitleStatus
+}
+
+func isSubtitleFile(filePath string) bool {
+	extension := strings.ToLower(path.Ext(filePath))
+	for _, subtitleExtension := range sidecarSubtitleExtensions {
+		if extension == subtitleExtension {
+			return true
+		}
+	}
+
+	return false
+}
+
+func convertSubtitle(inputPath string, outputPath string, kwargs ffmpeg.KwArgs) *ffmpeg.Stream {
+	kwargs["format"] = "webvtt"
+	return ffmpeg.Input(inputPath).
+		Output(outputPath, kwargs).
+		OverWriteOutput()
+}
+
+func queueSubtitleConversion(
+	db *gorm.DB,
+	config LibraryConfig,
+	subtitle Subtitle,
+	inputPath string,
+	ffmpegRequests chan<- FFMPegRequest,
+) error {
+	subtitle.Status = SubtitlePending
+	if result := db.Save(&subtitle); result.Error != nil {
+		return result.Error
+	}
+
+	if subtitle.FilePath == "" {
+		subtitle.FilePath = fmt.Sprintf("%d.vtt", subtitle.ID)
+		if result := db.Model(&subtitle).Update("file_path", subtitle.FilePath); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	kwargs := ffmpeg.KwArgs{}
+	if subtitle.SourceType == SubtitleEmbeddedSource {
+		kwargs["map"] = fmt.Sprintf("0:%d", subtitle.StreamIndex)
+	}
+
+	outputPath := path.Join(config.SubtitlesPath, subtitle.FilePath)
+	ffmpegRequests <- FFMPegRequest{
+		inputPath:  inputPath,
+		outputPath: outputPath,
+		stream:     convertSubtitle(inputPath, outputPath, kwargs),
+		typeName:   "subtitle",
+		onFinished: func(err error) {
+			status := SubtitleReady
+			if err != nil {
+				status = SubtitleFailed
+			}
+
+			result := db.
+				Model(&Subtitle{ID: subtitle.ID}).
+				Update("status", status)
+			if result.Error != nil {
+				log.WithError(result.Error).
+					WithField("subtitle", subtitle.ID).
+					Error("Unable to update subtitle status")
+			}
+		},
+	}
+
+	log.WithFields(log.Fields{
+		"Label":  subtitle.Label,
+		"Source": subtitle.SourceType,
+		"File":   inputPath,
+	}).Info("Registered new subtitle")
+
+	return nil
+}
+
+func findSidecarSubtitles(videoPath string) ([]string, error) {
+	videoName := nameFromFile(path.Base(videoPath))
+	entries, err := os.ReadDir(path.Dir(videoPath))
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecarPaths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isSubtitleFile(name) {
+			continue
+		}
+
+		subtitleName := nameFromFile(name)
+		if subtitleName == videoName || strings.HasPrefix(subtitleName, videoName+".") {
+			sidecarPaths = append(sidecarPaths, path.Join(path.Dir(videoPath), name))
+		}
+	}
+
+	return sidecarPaths, nil
+}
+
+func sidecarSubtitle(config LibraryConfig, video *Video, subtitlePath string) Subtitle {
+	videoName := nameFromFile(path.Base(video.VideoFilePath))
+	subtitleName := nameFromFile(path.Base(subtitlePath))
+	tags := strings.Split(strings.TrimPrefix(subtitleName[len(videoName):], "."), ".")
+
+	language := ""
+	label := strings.Join(tags, " ")
+	if len(tags) > 0 {
+		language = tags[0]
+	}
+
+	if label == "" {
+		label = "Default"
+	}
+
+	sourceFilePath, err := filepath.Rel(config.VideosPath, subtitlePath)
+	if err != nil {
+		sourceFilePath = subtitlePath
+	}
+
+	return Subtitle{
+		VideoID:        video.ID,
+		Label:          label,
+		Language:       language,
+		SourceType:     SubtitleSidecarSource,
+		SourceFilePath: sourceFilePath,
+	}
+}
+
+func embeddedSubtitles(video *Video) []Subtitle {
+	var subtitles []Subtitle
+	for i, track := range video.SubtitleTracks {
+		if !isTextSubtitleCodec(track.Codec) {
+			log.WithFields(log.Fields{
+				"File":  video.VideoFilePath,
+				"Codec": track.Codec,
+			}).Info("Skipping unsupported embedded subtitle")
+			continue
+		}
+
+		label := track.Title
+		if label == "" {
+			label = track.Language
+		}
+		if label == "" {
+			label = fmt.Sprintf("Track %d", i+1)
+		}
+
+		subtitles = append(subtitles, Subtitle{
+			VideoID:     video.ID,
+			Label:       label,
+			Language:    track.Language,
+			SourceType:  SubtitleEmbeddedSource,
+			StreamIndex: track.Index,
+		})
+	}
+
+	return subtitles
+}
+
+func isTextSubtitleCodec(codecName string) bool {
+	for _, textCodec := range textSubtitleCodecs {
+		if codecName == textCodec {
+			return true
+		}
+	}
+
+	return false
+}
+
+func subtitleSource(subtitle Subtitle) string {
+	if subtitle.SourceType == SubtitleEmbeddedSource {
+		return fmt.Sprintf("embedded:%d", subtitle.StreamIndex)
+	}
+
+	return "sidecar:" + subtitle.SourceFilePath
+}
+
+func removeSubtitle(db *gorm.DB, config LibraryConfig, subtitle Subtitle) error {
+	if result := db.Delete(&subtitle); result.Error != nil {
+		return result.Error
+	}
+
+	if subtitle.FilePath == "" {
+		return nil
+	}
+
+	if err := os.Remove(path.Join(config.SubtitlesPath, subtitle.FilePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).
+			WithField("subtitle", subtitle.FilePath).
+			Warn("Unable to remove subtitle file")
+	}
+
+	return nil
+}
+
+func removeSubtitlesForVideo(db *gorm.DB, config LibraryConfig, videoID uint) error {
+	var subtitles []Subtitle
+	if result := db.Where(Subtitle{VideoID: videoID}).Find(&subtitles); result.Error != nil {
+		return result.Error
+	}
+
+	for _, subtitle := range subtitles {
+		if err := removeSubtitle(db, config, subtitle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Subtitles which are still there keep their ID, so rooms don't lose them.
+func discoverSubtitles(
+	db *gorm.DB,
+	config LibraryConfig,
+	video *Video,
+	videoPath string,
+	ffmpegRequests chan<- FFMPegRequest,
+) error {
+	var existing []Subtitle
+	if result := db.Where(Subtitle{VideoID: video.ID}).Find(&existing); result.Error != nil {
+		return result.Error
+	}
+
+	existingBySource := map[string]Subtitle{}
+	for _, subtitle := range existing {
+		existingBySource[subtitleSource(subtitle)] = subtitle
+	}
+
+	sidecarPaths, err := findSidecarSubtitles(videoPath)
+	if err != nil {
+		return err
+	}
+
+	var subtitles []Subtitle
+	var inputPaths []string
+	for _, sidecarPath := range sidecarPaths {
+		subtitles = append(subtitles, sidecarSubtitle(config, video, sidecarPath))
+		inputPaths = append(inputPaths, sidecarPath)
+	}
+
+	for _, subtitle := range embeddedSubtitles(video) {
+		subtitles = append(subtitles, subtitle)
+		inputPaths = append(inputPaths, videoPath)
+	}
+
+	for i, subtitle := range subtitles {
+		source := subtitleSource(subtitle)
+		if previous, has := existingBySource[source]; has {
+			subtitle.ID = previous.ID
+			subtitle.FilePath = previous.FilePath
+			delete(existingBySource, source)
+		}
+
+		if err := queueSubtitleConversion(db, config, subtitle, inputPaths[i], ffmpegRequests); err != nil {
+			return err
+		}
+	}
+
+	for _, subtitle := range existingBySource {
+		if err := removeSubtitle(db, config, subtitle); err != nil {
+			return err
+		}
+	}
+
+	video.SubtitlesScanned = true
+	result := db.
+		Model(video).
+		Update("subtitles_scanned", true)
+	return result.Error
+}
+
+func videosForSidecarSubtitle(db *gorm.DB, config LibraryConfig, subtitlePath string) ([]Video, []string, error) {
+	var videos []Video
+	if result := db.Where(Video{SourceType: VideoFileSource}).Find(&videos); result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	subtitleDir := path.Dir(libraryPath(config.VideosPath, subtitlePath))
+	subtitleName := nameFromFile(path.Base(subtitlePath))
+	var matchingVideos []Video
+	var videoPaths []string
+	for _, video := range videos {
+		if path.Dir(video.VideoFilePath) != subtitleDir {
+			continue
+		}
+
+		videoName := nameFromFile(path.Base(video.VideoFilePath))
+		if subtitleName != videoName && !strings.HasPrefix(subtitleName, videoName+".") {
+			continue
+		}
+
+		videoPath := path.Join(config.VideosPath, video.VideoFilePath)
+		if _, err := os.Stat(videoPath); err != nil {
+			continue
+		}
+
+		matchingVideos = append(matchingVideos, video)
+		videoPaths = append(videoPaths, videoPath)
+	}
+
+	return matchingVideos, videoPaths, nil
+}
+
+func syncSidecarSubtitle(
+	db *gorm.DB,
+	config LibraryConfig,
+	subtitlePath string,
+	ffmpegRequests chan<- FFMPegRequest,
+) []Video {
+	videos, videoPaths, err := videosForSidecarSubtitle(db, config, subtitlePath)
+	if err != nil {
+		log.WithError(err).
+			Warnf("Could not load subtitle file '%s'", subtitlePath)
+		return nil
+	}
+
+	for i := range videos {
+		if err := discoverSubtitles(db, config, &videos[i], videoPaths[i], ffmpegRequests); err != nil {
+			log.WithError(err).
+				Warnf("Could not load subtitles for video file '%s'", videoPaths[i])
+		}
+	}
+
+	return videos
+}
+
+func PruneMissingSidecarSubtitles(db *gorm.DB, config LibraryConfig) int {
+	if _, err := os.Stat(config.VideosPath); err != nil {
+		return 0
+	}
+
+	var subtitles []Subtitle
+	result := db.
+		Where(Subtitle{SourceType: SubtitleSidecarSource}).
+		Find(&subtitles)
+	if result.Error != nil {
+		log.WithError(result.Error).
+			Error("Unable to query subtitles")
+		return 0
+	}
+
+	removedCount := 0
+	for _, subtitle := range subtitles {
+		sourcePath := path.Join(config.VideosPath, subtitle.SourceFilePath)
+		if _, err := os.Stat(sourcePath); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if result := db.Delete(&subtitle); result.Error != nil {
+			log.WithError(result.Error).
+				Warnf("Could not remove subtitle file '%s'", subtitle.SourceFilePath)
+			continue
+		}
+
+		_ = os.Remove(path.Join(config.SubtitlesPath, subtitle.FilePath))
+		removedCount += 1
+	}
+
+	return removedCount
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dirPath string, names ...string) {
+	for _, name := range names {
+		if err := os.WriteFile(path.Join(dirPath, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindSidecarSubtitles(t *testing.T) {
+	dirPath := t.TempDir()
+	writeTestFiles(t, dirPath, "Movie.mkv", "Movie.srt", "Movie.en.forced.ass", "Movie 2.srt",
+		"Movies.vtt", "Other.vtt", "Movie.nfo")
+
+	sidecarPaths, err := findSidecarSubtitles(path.Join(dirPath, "Movie.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(sidecarPaths)
+	expected := fmt.Sprint([]string{path.Join(dirPath, "Movie.en.forced.ass"), path.Join(dirPath, "Movie.srt")})
+	if fmt.Sprint(sidecarPaths) != expected {
+		t.Errorf("expected %s, got %v", expected, sidecarPaths)
+	}
+}
+
+func TestSidecarSubtitle(t *testing.T) {
+	config := LibraryConfig{VideosPath: "/videos"}
+	video := &Video{ID: 1, VideoFilePath: "Films/Movie.mkv"}
+
+	tests := []struct {
+		subtitlePath string
+		label        string
+		language     string
+	}{
+		{subtitlePath: "/videos/Films/Movie.srt", label: "Default"},
+		{subtitlePath: "/videos/Films/Movie.en.srt", label: "en", language: "en"},
+		{subtitlePath: "/videos/Films/Movie.en.forced.ass", label: "en forced", language: "en"},
+	}
+
+	for _, test := range tests {
+		t.Run(path.Base(test.subtitlePath), func(t *testing.T) {
+			subtitle := sidecarSubtitle(config, video, test.subtitlePath)
+			if subtitle.Label != test.label || subtitle.Language != test.language {
+				t.Errorf("expected %q in %q, got %q in %q", test.label, test.language, subtitle.Label, subtitle.Language)
+			}
+
+			if subtitle.SourceFilePath != "Films/"+path.Base(test.subtitlePath) {
+				t.Errorf("expected a path relative to the videos path, got %s", subtitle.SourceFilePath)
+			}
+		})
+	}
+}
+
+func TestEmbeddedSubtitles(t *testing.T) {
+	video := &Video{ID: 1, SubtitleTracks: []MediaTrack{
+		{Index: 2, Codec: "subrip", Language: "eng", Title: "English SDH"},
+		{Index: 3, Codec: "hdmv_pgs_subtitle", Language: "fre"},
+		{Index: 4, Codec: "ass", Language: "ger"},
+		{Index: 5, Codec: "mov_text"},
+	}}
+
+	subtitles := embeddedSubtitles(video)
+	expected := []Subtitle{
+		{VideoID: 1, Label: "English SDH", Language: "eng", SourceType: SubtitleEmbeddedSource, StreamIndex: 2},
+		{VideoID: 1, Label: "ger", Language: "ger", SourceType: SubtitleEmbeddedSource, StreamIndex: 4},
+		{VideoID: 1, Label: "Track 4", SourceType: SubtitleEmbeddedSource, StreamIndex: 5},
+	}
+
+	if fmt.Sprint(subtitles) != fmt.Sprint(expected) {
+		t.Errorf("expected %+v, got %+v", expected, subtitles)
+	}
+}
+
+func TestDiscoverSubtitles(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := LibraryConfig{VideosPath: t.TempDir(), SubtitlesPath: t.TempDir()}
+	requests := make(chan FFMPegRequest, 16)
+	videoPath := path.Join(config.VideosPath, "Movie.mkv")
+	writeTestFiles(t, config.VideosPath, "Movie.mkv", "Movie.en.srt", "Movie.fr.srt")
+
+	video := Video{VideoFilePath: "Movie.mkv", SubtitleTracks: []MediaTrack{{Index: 2, Codec: "subrip", Language: "eng"}}}
+	if err := db.Create(&video).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	discover := func() map[string]Subtitle {
+		if err := discoverSubtitles(db, config, &video, videoPath, requests); err != nil {
+			t.Fatal(err)
+		}
+
+		var subtitles []Subtitle
+		db.Where(Subtitle{VideoID: video.ID}).Find(&subtitles)
+		bySource := map[string]Subtitle{}
+		for _, subtitle := range subtitles {
+			bySource[subtitleSource(subtitle)] = subtitle
+		}
+
+		return bySource
+	}
+
+	first := discover()
+	if len(first) != 3 || len(requests) != 3 {
+		t.Fatalf("expected 3 subtitles to be converted, got %d and %d requests", len(first), len(requests))
+	}
+
+	if err := os.Remove(path.Join(config.VideosPath, "Movie.fr.srt")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, config.VideosPath, "Movie.de.srt")
+
+	second := discover()
+	tests := []struct {
+		source string
+		kept   bool
+		found  bool
+	}{
+		{source: "sidecar:Movie.en.srt", kept: true, found: true},
+		{source: "embedded:2", kept: true, found: true},
+		{source: "sidecar:Movie.fr.srt"},
+		{source: "sidecar:Movie.de.srt", found: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			subtitle, found := second[test.source]
+			if found != test.found {
+				t.Fatalf("expected found to be %t", test.found)
+			}
+
+			previous, existed := first[test.source]
+			if test.kept && (subtitle.ID != previous.ID || subtitle.FilePath != previous.FilePath) {
+				t.Errorf("expected %+v to keep its ID and file, got %+v", previous, subtitle)
+			}
+
+			if found && !existed && subtitle.FilePath != fmt.Sprintf("%d.vtt", subtitle.ID) {
+				t.Errorf("expected a new file for a new subtitle, got %s", subtitle.FilePath)
+			}
+
+			if found && subtitle.Status != SubtitlePending {
+				t.Errorf("expected the subtitle to be converted again, got %s", subtitle.Status)
+			}
+		})
+	}
+
+	if !video.SubtitlesScanned {
+		t.Error("expected the video to be marked as scanned")
+	}
+}
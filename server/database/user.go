@@ -0,0 +1,273 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+var ErrInvalidLogin = errors.New("invalid username or password")
+var ErrUsernameTaken = errors.New("username is taken")
+
+type User struct {
+	ID           uint   `gorm:"primaryKey;autoIncrement"`
+	Username     string `gorm:"uniqueIndex"`
+	PasswordHash string
+
+	SSOIssuer  string `gorm:"index:idx_user_sso"`
+	SSOSubject string `gorm:"index:idx_user_sso"`
+	GroupRole  string
+
+	DisplayName string
+	Colour      string
+	Avatar      string
+
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+type UserSession struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement"`
+	TokenHash string `gorm:"uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	User      User
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type UserRoomRole struct {
+	ID     uint   `gorm:"primaryKey;autoIncrement"`
+	UserID uint   `gorm:"uniqueIndex:idx_user_room_role"`
+	RoomID string `gorm:"uniqueIndex:idx_user_room_role"`
+	Role   string
+}
+
+// Checked when there's no such user, so usernames can't be probed by timing.
+var dummyPasswordHash []byte
+var dummyPasswordHashOnce sync.Once
+
+func hashSessionToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func CreateUser(db *gorm.DB, username string, password string) (User, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	var existing int64
+	if result := db.Model(&User{}).Where("username = ?", username).Count(&existing); result.Error != nil {
+		return User{}, result.Error
+	}
+
+	if existing > 0 {
+		return User{}, ErrUsernameTaken
+	}
+
+	user := User{
+		Username:     username,
+		PasswordHash: string(passwordHash),
+		DisplayName:  username,
+	}
+
+	if result := db.Create(&user); result.Error != nil {
+		return User{}, result.Error
+	}
+
+	return user, nil
+}
+
+func SaveSSOUser(
+	db *gorm.DB,
+	issuer string,
+	subject string,
+	username string,
+	displayName string,
+	groupRole string,
+) (User, error) {
+	var users []User
+	result := db.
+		Where("sso_issuer = ? AND sso_subject = ?", issuer, subject).
+		Limit(1).
+		Find(&users)
+	if result.Error != nil {
+		return User{}, result.Error
+	}
+
+	if len(users) > 0 {
+		user := users[0]
+		user.GroupRole = groupRole
+		user.LastLoginAt = time.Now()
+		result := db.
+			Model(&user).
+			Updates(map[string]interface{}{
+				"group_role":    user.GroupRole,
+				"last_login_at": user.LastLoginAt,
+			})
+		return user, result.Error
+	}
+
+	var existing int64
+	if result := db.Model(&User{}).Where("username = ?", username).Count(&existing); result.Error != nil {
+		return User{}, result.Error
+	}
+
+	if username == "" || existing > 0 {
+		username = "sso-" + hashSessionToken(issuer + "\n" + subject)[:12]
+	}
+
+	if displayName == "" {
+		displayName = username
+	}
+
+	user := User{
+		Username:    username,
+		SSOIssuer:   issuer,
+		SSOSubject:  subject,
+		GroupRole:   groupRole,
+		DisplayName: displayName,
+		LastLoginAt: time.Now(),
+	}
+
+	if result := db.Create(&user); result.Error != nil {
+		return User{}, result.Error
+	}
+
+	return user, nil
+}
+
+func Authenticate(db *gorm.DB, username string, password string) (*User, error) {
+	var users []User
+	result := db.
+		Where("username = ?", username).
+		Limit(1).
+		Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if len(users) == 0 {
+		dummyPasswordHashOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidLogin
+	}
+
+	user := &users[0]
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidLogin
+	}
+
+	user.LastLoginAt = time.Now()
+	if result := db.Model(user).Update("last_login_at", user.LastLoginAt); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return user, nil
+}
+
+func CreateUserSession(db *gorm.DB, userID uint, lifetime time.Duration) (string, time.Time, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := hex.EncodeToString(tokenBytes)
+	session := UserSession{
+		TokenHash: hashSessionToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(lifetime),
+	}
+
+	if result := db.Omit(clause.Associations).Create(&session); result.Error != nil {
+		return "", time.Time{}, result.Error
+	}
+
+	return token, session.ExpiresAt, nil
+}
+
+func FindSessionUser(db *gorm.DB, token string) (*User, error) {
+	var sessions []UserSession
+	result := db.
+		Preload("User").
+		Where("token_hash = ? AND expires_at > ?", hashSessionToken(token), time.Now()).
+		Limit(1).
+		Find(&sessions)
+	if result.Error != nil || len(sessions) == 0 {
+		return nil, result.Error
+	}
+
+	return &sessions[0].User, nil
+}
+
+func DeleteUserSession(db *gorm.DB, token string) error {
+	result := db.
+		Where(UserSession{TokenHash: hashSessionToken(token)}).
+		Delete(&UserSession{})
+	return result.Error
+}
+
+func PruneExpiredUserSessions(db *gorm.DB) (int64, error) {
+	result := db.
+		Where("expires_at <= ?", time.Now()).
+		Delete(&UserSession{})
+	return result.RowsAffected, result.Error
+}
+
+func DeleteUser(db *gorm.DB, user User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where(UserSession{UserID: user.ID}).Delete(&UserSession{}); result.Error != nil {
+			return result.Error
+		}
+
+		if result := tx.Where(UserRoomRole{UserID: user.ID}).Delete(&UserRoomRole{}); result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Delete(&user).Error
+	})
+}
+
+func SaveUserProfile(db *gorm.DB, userID uint, displayName string, colour string, avatar string) error {
+	result := db.
+		Model(&User{ID: userID}).
+		Updates(map[string]interface{}{
+			"display_name": displayName,
+			"colour":       colour,
+			"avatar":       avatar,
+		})
+	return result.Error
+}
+
+func UserRole(db *gorm.DB, userID uint, roomID string) (string, error) {
+	var roles []UserRoomRole
+	result := db.
+		Where(UserRoomRole{UserID: userID, RoomID: roomID}).
+		Limit(1).
+		Find(&roles)
+	if result.Error != nil || len(roles) == 0 {
+		return "", result.Error
+	}
+
+	return roles[0].Role, nil
+}
+
+func SaveUserRole(db *gorm.DB, userID uint, roomID string, role string) error {
+	result := db.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "room_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).
+		Create(&UserRoomRole{UserID: userID, RoomID: roomID, Role: role})
+	return result.Error
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateUser(db, "alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateUser(db, "alice", "another password"); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("expected %v, got %v", ErrUsernameTaken, err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{name: "correct password", username: "alice", password: "correct horse"},
+		{name: "wrong password", username: "alice", password: "battery staple", err: ErrInvalidLogin},
+		{name: "no such user", username: "carol", password: "correct horse", err: ErrInvalidLogin},
+		{name: "no username", username: "", password: "correct horse", err: ErrInvalidLogin},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := Authenticate(db, test.username, test.password)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+
+			if err == nil && user.Username != test.username {
+				t.Errorf("expected user %s, got %s", test.username, user.Username)
+			}
+		})
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := CreateUser(db, "alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, _, err := CreateUserSession(db, user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, _, err := CreateUserSession(db, user.ID, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loggedOut, _, err := CreateUserSession(db, user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteUserSession(db, loggedOut); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		loggedIn bool
+	}{
+		{name: "active", token: active, loggedIn: true},
+		{name: "expired", token: expired},
+		{name: "logged out", token: loggedOut},
+		{name: "unknown", token: "not-a-session"},
+		{name: "hash of an active session", token: hashSessionToken(active)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sessionUser, err := FindSessionUser(db, test.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.loggedIn && (sessionUser == nil || sessionUser.ID != user.ID) {
+				t.Errorf("expected %s to be logged in, got %v", user.Username, sessionUser)
+			}
+			if !test.loggedIn && sessionUser != nil {
+				t.Errorf("expected nobody to be logged in, got %s", sessionUser.Username)
+			}
+		})
+	}
+
+	if removed, err := PruneExpiredUserSessions(db); err != nil || removed != 1 {
+		t.Errorf("expected 1 expired session to be removed, got %d, %v", removed, err)
+	}
+}
+
+func TestSaveSSOUser(t *testing.T) {
+	db, err := Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := SaveSSOUser(db, "https://issuer", "subject", "alice", "Alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := SaveSSOUser(db, "https://issuer", "subject", "alice", "Alice", "moderator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != first.ID || again.GroupRole != "moderator" {
+		t.Errorf("expected the same user with their new role, got %+v", again)
+	}
+
+	other, err := SaveSSOUser(db, "https://issuer", "other", "alice", "Alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.ID == first.ID || other.Username == "alice" {
+		t.Errorf("expected a new user with their own username, got %+v", other)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 )
 
 const ThumbnailFrameNumber = 24 * 10
@@ -25,8 +26,42 @@ type Video struct {
 	Title         string
 	ThumbnailPath string
 
-	SourceType    VideoSourceType
-	VideoFilePath string
+	// Set when the title is changed by hand, so it isn't parsed again.
+	TitleEdited bool
+
+	NameParsed bool
+	Series     string `gorm:"index"`
+	Season     int
+	Episode    int
+	Year       int
+	Quality    string
+
+	SourceType     VideoSourceType
+	VideoFilePath  string
+	FileSize       int64
+	FileModifiedAt time.Time
+
+	HLSStatus       HLSStatus
+	HLSPlaylistPath string
+
+	// Bumped for every transcode, so old ones can't overwrite the status.
+	HLSGeneration uint `gorm:"not null;default:0"`
+
+	SubtitlesScanned bool
+	Subtitles        []Subtitle
+
+	// Filled in by ffprobe, left empty if probing failed.
+	MetadataProbed     bool
+	Duration           float64
+	Width              int
+	Height             int
+	VideoCodec         string
+	AudioCodec         string
+	Bitrate            int64
+	Container          string
+	AudioTracks        []MediaTrack `gorm:"serializer:json;type:text"`
+	SubtitleTracks     []MediaTrack `gorm:"serializer:json;type:text"`
+	BrowserUnsupported bool
 }
 
 func readVideoFrame(path string, outputPath string) *ffmpeg.Stream {
@@ -40,11 +75,10 @@ func readVideoFrame(path string, outputPath string) *ffmpeg.Stream {
 func generateThumbnailForVideoFile(
 	thumbnailPath string,
 	inputPath string,
+	videoFilePath string,
 	thumbnailRequests chan<- FFMPegRequest,
 ) string {
-	name := path.Base(inputPath)
-	fileName := fmt.Sprintf("%s.jpg", nameFromFile(name))
-
+	fileName := thumbnailFileName("video", videoFilePath)
 	outputPath := path.Join(thumbnailPath, fileName)
 	thumbnailRequests <- FFMPegRequest{
 		inputPath:  inputPath,
@@ -58,26 +92,29 @@ func generateThumbnailForVideoFile(
 
 func createFileVideo(
 	db *gorm.DB,
+	config LibraryConfig,
 	videoPath string,
-	thumbnailPath string,
+	fileInfo fs.FileInfo,
 	thumbnailRequests chan<- FFMPegRequest,
 ) (Video, error) {
-	thumbnail := generateThumbnailForVideoFile(thumbnailPath, videoPath, thumbnailRequests)
-	videoFilePath := path.Base(videoPath)
-	title := nameFromFile(videoFilePath)
+	videoFilePath := libraryPath(config.VideosPath, videoPath)
+	thumbnail := generateThumbnailForVideoFile(config.ThumbnailsPath, videoPath, videoFilePath, thumbnailRequests)
 	video := Video{
-		Title:         title,
-		ThumbnailPath: thumbnail,
-		SourceType:    VideoFileSource,
-		VideoFilePath: videoFilePath,
+		ThumbnailPath:  thumbnail,
+		SourceType:     VideoFileSource,
+		VideoFilePath:  videoFilePath,
+		FileSize:       fileInfo.Size(),
+		FileModifiedAt: fileInfo.ModTime(),
 	}
 
+	applyParsedName(&video, ParseFileName(videoFilePath))
+
 	if result := db.Create(&video); result.Error != nil {
 		return Video{}, result.Error
 	}
 
 	log.WithFields(log.Fields{
-		"Title":     title,
+		"Title":     video.Title,
 		"Thumbnail": thumbnail,
 		"File":      videoFilePath,
 	}).Info("Registered new file video")
@@ -99,48 +136,248 @@ func createDirIfNotExist(dirPath string) error {
 	return nil
 }
 
-func videoExists(db *gorm.DB, filePath string) (bool, error) {
-	var count int64
+func updateFileVideo(
+	db *gorm.DB,
+	video *Video,
+	videoPath string,
+	fileInfo fs.FileInfo,
+	thumbnailPath string,
+	thumbnailRequests chan<- FFMPegRequest,
+) (bool, error) {
+	if video.FileSize == fileInfo.Size() && video.FileModifiedAt.Equal(fileInfo.ModTime()) {
+		return false, nil
+	}
+
+	changed := !video.FileModifiedAt.IsZero()
+	video.FileSize = fileInfo.Size()
+	video.FileModifiedAt = fileInfo.ModTime()
+	if changed {
+		video.ThumbnailPath = generateThumbnailForVideoFile(thumbnailPath, videoPath, video.VideoFilePath, thumbnailRequests)
+		video.HLSStatus = HLSNotRequested
+		video.HLSPlaylistPath = ""
+		video.HLSGeneration += 1
+		video.MetadataProbed = false
+	}
+
+	if result := db.Save(video); result.Error != nil {
+		return false, result.Error
+	}
+
+	if changed {
+		log.WithFields(log.Fields{
+			"Title": video.Title,
+			"File":  video.VideoFilePath,
+		}).Info("Updated changed file video")
+	}
+
+	return changed, nil
+}
+
+func removeFileVideo(db *gorm.DB, config LibraryConfig, video Video) error {
+	if err := removeSubtitlesForVideo(db, config, video.ID); err != nil {
+		return err
+	}
+
+	if err := removeWatchProgressForVideo(db, video.ID); err != nil {
+		return err
+	}
+
+	if result := db.Delete(&video); result.Error != nil {
+		return result.Error
+	}
+
+	if err := os.Remove(path.Join(config.ThumbnailsPath, video.ThumbnailPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.WithError(err).
+			WithField("thumbnail", video.ThumbnailPath).
+			Warn("Unable to remove video thumbnail")
+	}
+
+	if config.HLSPath != "" {
+		if err := os.RemoveAll(path.Join(config.HLSPath, fmt.Sprint(video.ID))); err != nil {
+			log.WithError(err).
+				WithField("video", video.ID).
+				Warn("Unable to remove HLS transcode")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"Title": video.Title,
+		"File":  video.VideoFilePath,
+	}).Info("Removed missing file video")
+
+	return nil
+}
+
+func findFileVideo(db *gorm.DB, config LibraryConfig, filePath string) (*Video, error) {
+	videoFilePath := libraryPath(config.VideosPath, filePath)
+	var videos []Video
 	result := db.
-		Model(&Video{}).
-		Where(Video{VideoFilePath: path.Base(filePath)}).
-		Count(&count)
+		Where(Video{VideoFilePath: videoFilePath}).
+		Limit(1).
+		Find(&videos)
 	if result.Error != nil {
-		return false, result.Error
+		return nil, result.Error
+	}
+
+	if len(videos) > 0 {
+		return &videos[0], nil
 	}
 
-	return count > 0, nil
+	return adoptLegacyFileVideo(db, config, videoFilePath)
+}
+
+func adoptLegacyFileVideo(db *gorm.DB, config LibraryConfig, videoFilePath string) (*Video, error) {
+	fileName := path.Base(videoFilePath)
+	if fileName == videoFilePath {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(path.Join(config.VideosPath, fileName)); err == nil {
+		return nil, nil
+	}
+
+	var videos []Video
+	result := db.
+		Where(Video{SourceType: VideoFileSource, VideoFilePath: fileName}).
+		Limit(1).
+		Find(&videos)
+	if result.Error != nil || len(videos) == 0 {
+		return nil, result.Error
+	}
+
+	video := &videos[0]
+	video.VideoFilePath = videoFilePath
+	if result := db.Model(video).Update("video_file_path", videoFilePath); result.Error != nil {
+		return nil, result.Error
+	}
+
+	log.WithFields(log.Fields{
+		"Title": video.Title,
+		"File":  videoFilePath,
+	}).Info("Moved file video into its folder")
+
+	return video, nil
+}
+
+func syncFileVideo(
+	db *gorm.DB,
+	config LibraryConfig,
+	filePath string,
+	ffmpegRequests chan<- FFMPegRequest,
+) (*Video, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	video, err := findFileVideo(db, config, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	if video == nil {
+		newVideo, err := createFileVideo(db, config, filePath, fileInfo, ffmpegRequests)
+		if err != nil {
+			return nil, err
+		}
+
+		video = &newVideo
+		changed = true
+	} else {
+		changed, err = updateFileVideo(db, video, filePath, fileInfo, config.ThumbnailsPath, ffmpegRequests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !video.NameParsed {
+		if err := backfillParsedName(db, video); err != nil {
+			log.WithError(err).
+				Warnf("Could not update name of video file '%s'", filePath)
+		}
+	}
+
+	if !video.MetadataProbed {
+		if err := probeVideo(db, video, filePath); err != nil {
+			log.WithError(err).
+				Warnf("Could not probe video file '%s'", filePath)
+		}
+	}
+
+	if changed || !video.SubtitlesScanned {
+		if err := discoverSubtitles(db, config, video, filePath, ffmpegRequests); err != nil {
+			log.WithError(err).
+				Warnf("Could not load subtitles for video file '%s'", filePath)
+		}
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	return video, nil
 }
 
 func ScanForNewFileVideos(
 	db *gorm.DB,
-	videosPath string,
-	thumbnailsPath string,
-	thumbnailRequests chan<- FFMPegRequest,
-) {
-	log.Infof("Scanning '%s' for new file videos", videosPath)
-	if err := createDirIfNotExist(thumbnailsPath); err != nil {
-		log.WithError(err).
-			WithField("path", thumbnailsPath).
-			Error("Need path to store thumbnails")
-		return
+	config LibraryConfig,
+	scanPath string,
+	ffmpegRequests chan<- FFMPegRequest,
+) []Video {
+	log.Infof("Scanning '%s' for new file videos", scanPath)
+	for _, outputPath := range []string{config.ThumbnailsPath, config.SubtitlesPath} {
+		if err := createDirIfNotExist(outputPath); err != nil {
+			log.WithError(err).
+				WithField("path", outputPath).
+				Error("Need path to store thumbnails and subtitles")
+			return nil
+		}
 	}
 
-	err := filepath.WalkDir(videosPath+"/", func(filePath string, info fs.DirEntry, err error) error {
-		if err != nil || info.IsDir() {
+	var changedVideos []Video
+	err := filepath.WalkDir(scanPath+"/", func(filePath string, info fs.DirEntry, err error) error {
+		if err != nil || info.IsDir() || isSubtitleFile(filePath) || isCoverImage(filePath) {
 			return nil
 		}
 
-		exists, err := videoExists(db, filePath)
+		video, err := syncFileVideo(db, config, filePath, ffmpegRequests)
 		if err != nil {
-			return err
+			log.WithError(err).
+				Warnf("Could not load video file '%s'", filePath)
+			return nil
 		}
 
-		if !exists {
-			if _, err := createFileVideo(db, filePath, thumbnailsPath, thumbnailRequests); err != nil {
-				log.WithError(err).
-					Warnf("Could not load video file '%s'", filePath)
-			}
+		if video != nil {
+			changedVideos = append(changedVideos, *video)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.WithError(err).
+			Error("Error scanning video files")
+	}
+
+	return changedVideos
+}
+
+func PruneMissingFileVideos(db *gorm.DB, config LibraryConfig) int {
+	videosPath := config.VideosPath
+
+	// Never treat an unmounted or missing library as empty
+	if _, err := os.Stat(videosPath); err != nil {
+		log.WithError(err).
+			WithField("path", videosPath).
+			Warn("Skipping prune of missing video library")
+		return 0
+	}
+
+	existingFiles := map[string]bool{}
+	err := filepath.WalkDir(videosPath+"/", func(filePath string, info fs.DirEntry, err error) error {
+		if err == nil && !info.IsDir() {
+			existingFiles[libraryPath(videosPath, filePath)] = true
 		}
 
 		return nil
@@ -149,5 +386,30 @@ func ScanForNewFileVideos(
 	if err != nil {
 		log.WithError(err).
 			Error("Error scanning video files")
+		return 0
+	}
+
+	var videos []Video
+	if result := db.Where(Video{SourceType: VideoFileSource}).Find(&videos); result.Error != nil {
+		log.WithError(result.Error).
+			Error("Unable to query videos")
+		return 0
 	}
+
+	removedCount := 0
+	for _, video := range videos {
+		if existingFiles[video.VideoFilePath] {
+			continue
+		}
+
+		if err := removeFileVideo(db, config, video); err != nil {
+			log.WithError(err).
+				Warnf("Could not remove video file '%s'", video.VideoFilePath)
+			continue
+		}
+
+		removedCount += 1
+	}
+
+	return removedCount
 }
@@ -0,0 +1,315 @@
+package database
+
+import (
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// So we don't thumbnail a video that's still being copied in.
+const WatcherSettleTime = 2 * time.Second
+const watcherPollInterval = time.Second
+
+type LibraryChange int
+
+const (
+	VideoLibraryChanged = LibraryChange(iota)
+	ImageLibraryChanged
+)
+
+type libraryWatcher struct {
+	db         *gorm.DB
+	config     LibraryConfig
+	requests   chan<- FFMPegRequest
+	transcodes *hlsQueue
+	changes    chan<- LibraryChange
+	notify     *fsnotify.Watcher
+
+	dirtyPaths   map[string]time.Time
+	pendingPrune map[LibraryChange]time.Time
+}
+
+func (watcher *libraryWatcher) libraryForPath(filePath string) (LibraryChange, bool) {
+	isInside := func(root string) bool {
+		relativePath, err := filepath.Rel(filepath.Clean(root), filepath.Clean(filePath))
+		return err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, "../")
+	}
+
+	switch {
+	case isInside(watcher.config.VideosPath):
+		return VideoLibraryChanged, true
+	case isInside(watcher.config.ImagesPath):
+		return ImageLibraryChanged, true
+	default:
+		return 0, false
+	}
+}
+
+func (watcher *libraryWatcher) addWatches(rootPath string) {
+	if watcher.notify == nil {
+		return
+	}
+
+	err := filepath.WalkDir(rootPath, func(dirPath string, info fs.DirEntry, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+
+		if err := watcher.notify.Add(dirPath); err != nil {
+			log.WithError(err).
+				WithField("path", dirPath).
+				Warn("Unable to watch directory, relying on periodic rescan")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.WithError(err).
+			WithField("path", rootPath).
+			Warn("Unable to watch library")
+	}
+}
+
+func (watcher *libraryWatcher) queueTranscodes(videos []Video) {
+	if watcher.transcodes == nil {
+		return
+	}
+
+	for _, video := range videos {
+		watcher.transcodes.add(video, filepath.Join(watcher.config.VideosPath, video.VideoFilePath))
+	}
+}
+
+func (watcher *libraryWatcher) syncPath(library LibraryChange, filePath string) bool {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		// It's gone again, the remove event will schedule a prune.
+		return false
+	}
+
+	config := watcher.config
+	switch {
+	case library == VideoLibraryChanged && fileInfo.IsDir():
+		watcher.addWatches(filePath)
+		collectionsChanged := ScanForCollections(watcher.db, config, filePath, watcher.requests)
+		videos := ScanForNewFileVideos(watcher.db, config, filePath, watcher.requests)
+		watcher.queueTranscodes(videos)
+		return len(videos) > 0 || collectionsChanged
+
+	case library == VideoLibraryChanged && isCoverImage(filePath):
+		changed, err := syncCollection(watcher.db, config, filepath.Dir(filePath), watcher.requests)
+		if err != nil {
+			log.WithError(err).
+				Warnf("Could not load cover image '%s'", filePath)
+		}
+
+		return changed
+
+	case library == VideoLibraryChanged && isSubtitleFile(filePath):
+		videos := syncSidecarSubtitle(watcher.db, config, filePath, watcher.requests)
+		return len(videos) > 0
+
+	case library == VideoLibraryChanged:
+		video, err := syncFileVideo(watcher.db, config, filePath, watcher.requests)
+		if err != nil {
+			log.WithError(err).
+				Warnf("Could not load video file '%s'", filePath)
+			return false
+		}
+
+		if video == nil {
+			return false
+		}
+
+		watcher.queueTranscodes([]Video{*video})
+		return true
+
+	case fileInfo.IsDir():
+		watcher.addWatches(filePath)
+		images := ScanForNewFileImages(watcher.db, config, filePath, watcher.requests)
+		return len(images) > 0
+
+	default:
+		image, err := syncFileImage(watcher.db, config, filePath, watcher.requests)
+		if err != nil {
+			log.WithError(err).
+				Warnf("Could not load image file '%s'", filePath)
+			return false
+		}
+
+		return image != nil
+	}
+}
+
+func (watcher *libraryWatcher) addFile(filePath string) {
+	library, inLibrary := watcher.libraryForPath(filePath)
+	if !inLibrary {
+		log.WithField("path", filePath).Warn("Added file is outside of the library")
+		return
+	}
+
+	delete(watcher.dirtyPaths, filePath)
+	if watcher.syncPath(library, filePath) {
+		watcher.changes <- library
+	}
+}
+
+func (watcher *libraryWatcher) prune(library LibraryChange) bool {
+	config := watcher.config
+	if library == VideoLibraryChanged {
+		removedVideos := PruneMissingFileVideos(watcher.db, config)
+		removedSubtitles := PruneMissingSidecarSubtitles(watcher.db, config)
+		changedCollections := PruneMissingCollections(watcher.db, config)
+		return removedVideos+removedSubtitles+changedCollections > 0
+	}
+
+	return PruneMissingFileImages(watcher.db, config) > 0
+}
+
+func (watcher *libraryWatcher) handleEvent(event fsnotify.Event) {
+	library, inLibrary := watcher.libraryForPath(event.Name)
+	if !inLibrary {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"path": event.Name,
+		"op":   event.Op.String(),
+	}).Trace("Library file event")
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(watcher.dirtyPaths, event.Name)
+		watcher.pendingPrune[library] = time.Now()
+		return
+	}
+
+	if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+		watcher.dirtyPaths[event.Name] = time.Now()
+	}
+}
+
+func (watcher *libraryWatcher) flushSettled() {
+	changed := map[LibraryChange]bool{}
+	for filePath, lastEvent := range watcher.dirtyPaths {
+		if time.Since(lastEvent) < WatcherSettleTime {
+			continue
+		}
+
+		delete(watcher.dirtyPaths, filePath)
+		library, _ := watcher.libraryForPath(filePath)
+		if watcher.syncPath(library, filePath) {
+			changed[library] = true
+		}
+	}
+
+	for library, lastEvent := range watcher.pendingPrune {
+		if time.Since(lastEvent) < WatcherSettleTime {
+			continue
+		}
+
+		delete(watcher.pendingPrune, library)
+		if watcher.prune(library) {
+			changed[library] = true
+		}
+	}
+
+	for library := range changed {
+		watcher.changes <- library
+	}
+}
+
+func (watcher *libraryWatcher) rescan() []Video {
+	config := watcher.config
+	watcher.addWatches(config.VideosPath)
+	watcher.addWatches(config.ImagesPath)
+
+	collectionsChanged := ScanForCollections(watcher.db, config, config.VideosPath, watcher.requests)
+	videos := ScanForNewFileVideos(watcher.db, config, config.VideosPath, watcher.requests)
+	prunedVideos := watcher.prune(VideoLibraryChanged)
+	if len(videos) > 0 || prunedVideos || collectionsChanged {
+		watcher.changes <- VideoLibraryChanged
+	}
+
+	images := ScanForNewFileImages(watcher.db, config, config.ImagesPath, watcher.requests)
+	prunedImages := watcher.prune(ImageLibraryChanged)
+	if len(images) > 0 || prunedImages {
+		watcher.changes <- ImageLibraryChanged
+	}
+
+	return videos
+}
+
+func WatchLibrary(
+	db *gorm.DB,
+	config LibraryConfig,
+	requests chan<- FFMPegRequest,
+	changes chan<- LibraryChange,
+	rescanRequests <-chan struct{},
+	addedFiles <-chan string,
+) {
+	watcher := libraryWatcher{
+		db:       db,
+		config:   config,
+		requests: requests,
+		changes:  changes,
+
+		dirtyPaths:   map[string]time.Time{},
+		pendingPrune: map[LibraryChange]time.Time{},
+	}
+
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if notify, err := fsnotify.NewWatcher(); err != nil {
+		log.WithError(err).
+			Warn("Unable to start file system watcher, relying on periodic rescan")
+	} else {
+		defer notify.Close()
+		watcher.notify = notify
+		events = notify.Events
+		watchErrors = notify.Errors
+	}
+
+	// Videos found here will be picked up by the unfinished transcode sweep.
+	watcher.rescan()
+	if config.HLSPath != "" {
+		watcher.transcodes = newHLSQueue(db, config.HLSPath)
+		watcher.transcodes.start(HLSWorkerCount)
+		queueUnfinishedHLSTranscodes(db, config.VideosPath, watcher.transcodes)
+	}
+
+	var rescans <-chan time.Time
+	if config.RescanInterval > 0 {
+		rescanTicker := time.NewTicker(config.RescanInterval)
+		defer rescanTicker.Stop()
+		rescans = rescanTicker.C
+	}
+
+	settleTicker := time.NewTicker(watcherPollInterval)
+	defer settleTicker.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			watcher.handleEvent(event)
+		case err := <-watchErrors:
+			log.WithError(err).
+				Warn("File system watcher error")
+		case <-settleTicker.C:
+			watcher.flushSettled()
+		case <-rescans:
+			log.Info("Starting periodic library rescan")
+			watcher.queueTranscodes(watcher.rescan())
+		case <-rescanRequests:
+			log.Info("Starting requested library rescan")
+			watcher.queueTranscodes(watcher.rescan())
+		case filePath := <-addedFiles:
+			watcher.addFile(filePath)
+		}
+	}
+}
@@ -4,8 +4,13 @@ go 1.20
 
 require (
 	github.com/benjilks/tinywebserver v0.0.4
+	github.com/coreos/go-oidc/v3 v3.6.0
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/go-jose/go-jose/v3 v3.0.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/u2takey/ffmpeg-go v0.4.1
+	golang.org/x/crypto v0.14.0
+	golang.org/x/oauth2 v0.8.0
 	gopkg.in/ini.v1 v1.67.0
 	gorm.io/driver/sqlite v1.4.4
 	gorm.io/gorm v1.24.5
@@ -14,11 +19,15 @@ require (
 
 require (
 	github.com/aws/aws-sdk-go v1.38.20 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/klauspost/compress v1.10.3 // indirect
 	github.com/mattn/go-sqlite3 v1.14.16 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/protobuf v1.28.0 // indirect
 )
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/ini.v1"
+	"strconv"
+	"strings"
+)
+
+const layoutSectionPrefix = "layout."
+const MaxSeatsPerRow = 64
+
+type Layout struct {
+	Name            string `json:"name"`
+	Rows            []int  `json:"rows"`
+	ReservedRows    []int  `json:"reserved_rows"`
+	VIPSeats        []Seat `json:"vip_seats"`
+	AccessibleSeats []Seat `json:"accessible_seats"`
+}
+
+type LayoutConfig struct {
+	Layouts       map[string]Layout
+	DefaultLayout string
+
+	// Rooms not listed use the default layout.
+	RoomLayouts map[string]string
+}
+
+func defaultLayoutConfig() LayoutConfig {
+	return LayoutConfig{
+		Layouts: map[string]Layout{
+			DefaultLayoutName: {
+				Name:         DefaultLayoutName,
+				Rows:         RowSeatCount,
+				ReservedRows: DefaultReservedRows,
+			},
+		},
+		DefaultLayout: DefaultLayoutName,
+		RoomLayouts:   map[string]string{},
+	}
+}
+
+func (config LayoutConfig) ForRoom(roomID string) Layout {
+	if layoutName, has := config.RoomLayouts[roomID]; has {
+		return config.Layouts[layoutName]
+	}
+
+	return config.Layouts[config.DefaultLayout]
+}
+
+func (config LayoutConfig) Validate() error {
+	if _, has := config.Layouts[config.DefaultLayout]; !has {
+		return fmt.Errorf("default layout '%s' is not defined", config.DefaultLayout)
+	}
+
+	for roomID, layoutName := range config.RoomLayouts {
+		if !ValidRoomID(roomID) {
+			return fmt.Errorf("invalid room ID '%s'", roomID)
+		}
+
+		if _, has := config.Layouts[layoutName]; !has {
+			return fmt.Errorf("room '%s' uses undefined layout '%s'", roomID, layoutName)
+		}
+	}
+
+	for _, layout := range config.Layouts {
+		if err := layout.Validate(); err != nil {
+			return fmt.Errorf("layout '%s': %w", layout.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (layout Layout) Validate() error {
+	if len(layout.Rows) == 0 {
+		return errors.New("has no rows")
+	}
+
+	for row, seatCount := range layout.Rows {
+		if seatCount <= 0 || seatCount > MaxSeatsPerRow {
+			return fmt.Errorf("row %d must have between 1 and %d seats", row, MaxSeatsPerRow)
+		}
+	}
+
+	for _, row := range layout.ReservedRows {
+		if row < 0 || row >= len(layout.Rows) {
+			return fmt.Errorf("reserved row %d doesn't exist", row)
+		}
+	}
+
+	if len(layout.ReservedRows) >= len(layout.Rows) {
+		return errors.New("every row is reserved")
+	}
+
+	for _, seat := range append(layout.VIPSeats, layout.AccessibleSeats...) {
+		if !layout.ValidSeat(seat) {
+			return fmt.Errorf("seat %d:%d doesn't exist", seat.Row, seat.Column)
+		}
+	}
+
+	if !layout.hasStandardSeat() {
+		return errors.New("has no seats viewers can be placed in")
+	}
+
+	return nil
+}
+
+func (layout Layout) hasStandardSeat() bool {
+	for row, seatCount := range layout.Rows {
+		for column := 0; column < seatCount; column++ {
+			if layout.IsStandardSeat(Seat{Row: row, Column: column}) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (layout Layout) ValidSeat(seat Seat) bool {
+	if seat.Row < 0 || seat.Row >= len(layout.Rows) {
+		return false
+	}
+
+	return seat.Column >= 0 && seat.Column < layout.Rows[seat.Row]
+}
+
+func containsSeat(seats []Seat, seat Seat) bool {
+	for _, candidate := range seats {
+		if candidate == seat {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (layout Layout) IsVIPSeat(seat Seat) bool {
+	return containsSeat(layout.VIPSeats, seat)
+}
+
+func (layout Layout) IsAccessibleSeat(seat Seat) bool {
+	return containsSeat(layout.AccessibleSeats, seat)
+}
+
+func (layout Layout) IsReservedRow(row int) bool {
+	for _, reservedRow := range layout.ReservedRows {
+		if row == reservedRow {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (layout Layout) IsStandardSeat(seat Seat) bool {
+	return !layout.IsReservedRow(seat.Row) &&
+		!layout.IsVIPSeat(seat) &&
+		!layout.IsAccessibleSeat(seat)
+}
+
+// Seats are written as 'row:column', e.g. '2:7, 2:8'.
+func parseSeats(seatList []string) ([]Seat, error) {
+	var seats []Seat
+	for _, seatText := range seatList {
+		rowText, columnText, found := strings.Cut(seatText, ":")
+		if !found {
+			return nil, fmt.Errorf("seat '%s' should be written as row:column", seatText)
+		}
+
+		row, err := strconv.Atoi(strings.TrimSpace(rowText))
+		if err != nil {
+			return nil, fmt.Errorf("seat '%s' has an invalid row", seatText)
+		}
+
+		column, err := strconv.Atoi(strings.TrimSpace(columnText))
+		if err != nil {
+			return nil, fmt.Errorf("seat '%s' has an invalid column", seatText)
+		}
+
+		seats = append(seats, Seat{
+			Row:    row,
+			Column: column,
+		})
+	}
+
+	return seats, nil
+}
+
+func parseLayoutSection(name string, section *ini.Section) (Layout, error) {
+	layout := Layout{Name: name}
+	rows, err := section.Key("rows").StrictInts(",")
+	if err != nil {
+		return layout, fmt.Errorf("layout '%s' has invalid rows: %w", name, err)
+	}
+
+	reservedRows, err := section.Key("reserved-rows").StrictInts(",")
+	if err != nil {
+		return layout, fmt.Errorf("layout '%s' has invalid reserved rows: %w", name, err)
+	}
+
+	vipSeats, err := parseSeats(section.Key("vip-seats").Strings(","))
+	if err != nil {
+		return layout, fmt.Errorf("layout '%s': %w", name, err)
+	}
+
+	accessibleSeats, err := parseSeats(section.Key("accessible-seats").Strings(","))
+	if err != nil {
+		return layout, fmt.Errorf("layout '%s': %w", name, err)
+	}
+
+	layout.Rows = rows
+	layout.ReservedRows = reservedRows
+	layout.VIPSeats = vipSeats
+	layout.AccessibleSeats = accessibleSeats
+	return layout, nil
+}
+
+func layoutsFromFile(file *ini.File, config LayoutConfig) (LayoutConfig, error) {
+	for _, section := range file.Sections() {
+		name, isLayout := strings.CutPrefix(section.Name(), layoutSectionPrefix)
+		if !isLayout {
+			continue
+		}
+
+		layout, err := parseLayoutSection(name, section)
+		if err != nil {
+			return config, err
+		}
+
+		config.Layouts[name] = layout
+	}
+
+	for _, key := range file.Section("room-layouts").Keys() {
+		config.RoomLayouts[key.Name()] = key.String()
+	}
+
+	return config, nil
+}
+
+func LoadLayoutFile(filePath string, config LayoutConfig) (LayoutConfig, error) {
+	file, err := ini.Load(filePath)
+	if err != nil {
+		return config, err
+	}
+
+	return layoutsFromFile(file, config)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"gopkg.in/ini.v1"
+	"testing"
+)
+
+func TestLayoutValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout Layout
+		valid  bool
+	}{
+		{
+			name:   "default",
+			layout: Layout{Rows: []int{4, 4, 4}, ReservedRows: []int{0}},
+			valid:  true,
+		},
+		{
+			name:   "special seats",
+			layout: Layout{Rows: []int{2, 3}, VIPSeats: []Seat{{Row: 0, Column: 1}}, AccessibleSeats: []Seat{{Row: 1, Column: 2}}},
+			valid:  true,
+		},
+		{
+			name:   "no rows",
+			layout: Layout{},
+		},
+		{
+			name:   "empty row",
+			layout: Layout{Rows: []int{4, 0}},
+		},
+		{
+			name:   "row too long",
+			layout: Layout{Rows: []int{MaxSeatsPerRow + 1}},
+		},
+		{
+			name:   "reserved row out of range",
+			layout: Layout{Rows: []int{4, 4}, ReservedRows: []int{2}},
+		},
+		{
+			name:   "negative reserved row",
+			layout: Layout{Rows: []int{4, 4}, ReservedRows: []int{-1}},
+		},
+		{
+			name:   "every row reserved",
+			layout: Layout{Rows: []int{4, 4}, ReservedRows: []int{0, 1}},
+		},
+		{
+			name:   "VIP seat past end of row",
+			layout: Layout{Rows: []int{2, 3}, VIPSeats: []Seat{{Row: 0, Column: 2}}},
+		},
+		{
+			name:   "no standard seats",
+			layout: Layout{Rows: []int{2, 1}, ReservedRows: []int{0}, AccessibleSeats: []Seat{{Row: 1, Column: 0}}},
+		},
+		{
+			name:   "accessible seat in missing row",
+			layout: Layout{Rows: []int{2, 3}, AccessibleSeats: []Seat{{Row: 2, Column: 0}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.layout.Validate()
+			if test.valid && err != nil {
+				t.Errorf("expected valid layout, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseLayoutSection(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		rows         []int
+		reservedRows []int
+		valid        bool
+	}{
+		{
+			name:         "rows and reserved rows",
+			config:       "rows = 4, 6, 8\nreserved-rows = 0",
+			rows:         []int{4, 6, 8},
+			reservedRows: []int{0},
+			valid:        true,
+		},
+		{
+			name:   "no reserved rows",
+			config: "rows = 4",
+			rows:   []int{4},
+			valid:  true,
+		},
+		{
+			name:   "invalid row",
+			config: "rows = 4, six, 8",
+		},
+		{
+			name:   "invalid reserved row",
+			config: "rows = 4, 6\nreserved-rows = first",
+		},
+		{
+			name:   "invalid seat",
+			config: "rows = 4\nvip-seats = 0-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ini.Load([]byte("[layout.test]\n" + test.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			layout, err := parseLayoutSection("test", file.Section("layout.test"))
+			if !test.valid {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !equalInts(layout.Rows, test.rows) {
+				t.Errorf("expected rows %v, got %v", test.rows, layout.Rows)
+			}
+			if !equalInts(layout.ReservedRows, test.reservedRows) {
+				t.Errorf("expected reserved rows %v, got %v", test.reservedRows, layout.ReservedRows)
+			}
+		})
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -7,6 +7,8 @@ import (
 	"gopkg.in/ini.v1"
 	"gorm.io/gorm"
 	"runtime"
+	"strings"
+	"time"
 	"watch-party/database"
 )
 
@@ -17,6 +19,33 @@ type Config struct {
 	VideosPath     string
 	ImagesPath     string
 	ThumbnailsPath string
+	HLSPath        string
+	SubtitlesPath  string
+
+	EnableHLSTranscode bool
+	RescanInterval     time.Duration
+
+	RoomGracePeriod time.Duration
+	SeatGracePeriod time.Duration
+	SyncInterval    time.Duration
+	DriftThreshold  time.Duration
+	ReadyTimeout    time.Duration
+
+	ChatHistoryLength int
+
+	Layouts     LayoutConfig
+	LayoutsFile string
+	WaitingList bool
+
+	AdminToken    string
+	UploadsPath   string
+	MaxUploadSize int64
+
+	Access   AccessConfig
+	Accounts AccountConfig
+	OIDC     OIDCConfig
+
+	RateLimits RateLimitConfig
 
 	WebServerConfig webserver.Config
 }
@@ -33,15 +62,42 @@ func defaultConfig() Config {
 		VideosPath:     DefaultVidsPath,
 		ImagesPath:     DefaultImagesPath,
 		ThumbnailsPath: DefaultThumbnailsPath,
+		HLSPath:        DefaultHLSPath,
+		SubtitlesPath:  DefaultSubtitlesPath,
+
+		EnableHLSTranscode: true,
+		RescanInterval:     DefaultRescanInterval,
+
+		RoomGracePeriod: DefaultRoomGracePeriod,
+		SeatGracePeriod: DefaultSeatGracePeriod,
+		SyncInterval:    DefaultSyncInterval,
+		DriftThreshold:  DefaultDriftThreshold,
+		ReadyTimeout:    DefaultReadyTimeout,
+
+		ChatHistoryLength: DefaultChatHistoryLength,
+
+		Layouts:     defaultLayoutConfig(),
+		WaitingList: true,
+
+		UploadsPath:   DefaultUploadsPath,
+		MaxUploadSize: DefaultMaxUploadSize,
+
+		Access: defaultAccessConfig(),
+		Accounts: AccountConfig{
+			SessionLifetime: DefaultSessionLifetime,
+		},
+		OIDC: defaultOIDCConfig(),
+
+		RateLimits: defaultRateLimitConfig(),
 
 		WebServerConfig: webserverConfig,
 	}
 }
 
-func setupDatabase(config Config) (*gorm.DB, error) {
+func setupDatabase(config Config) (*gorm.DB, *database.Library, <-chan database.LibraryChange, error) {
 	db, err := database.Open(config.DatabasePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	cpuCount := runtime.NumCPU() - 1
@@ -54,9 +110,47 @@ func setupDatabase(config Config) (*gorm.DB, error) {
 
 	requests := make(chan database.FFMPegRequest)
 	go database.StartFFMPegWorkerPools(cpuCount, requests)
-	go database.ScanForNewFileVideos(db, config.VideosPath, config.ThumbnailsPath, requests)
-	go database.ScanForNewFileImages(db, config.ImagesPath, config.ThumbnailsPath, requests)
-	return db, nil
+
+	libraryConfig := database.LibraryConfig{
+		VideosPath:     config.VideosPath,
+		ImagesPath:     config.ImagesPath,
+		ThumbnailsPath: config.ThumbnailsPath,
+		SubtitlesPath:  config.SubtitlesPath,
+		RescanInterval: config.RescanInterval,
+	}
+
+	if config.EnableHLSTranscode {
+		libraryConfig.HLSPath = config.HLSPath
+	}
+
+	changes := make(chan database.LibraryChange)
+	library := database.NewLibrary(db, libraryConfig, requests, changes)
+	go library.Watch()
+	return db, library, changes, nil
+}
+
+func setupLayouts(config Config) (LayoutConfig, error) {
+	layouts := config.Layouts
+	if config.LayoutsFile != "" {
+		var err error
+		layouts, err = LoadLayoutFile(config.LayoutsFile, layouts)
+		if err != nil {
+			return layouts, err
+		}
+	}
+
+	return layouts, layouts.Validate()
+}
+
+func forwardLibraryChanges(changes <-chan database.LibraryChange, rooms *RoomRegistry) {
+	for change := range changes {
+		switch change {
+		case database.VideoLibraryChanged:
+			rooms.Broadcast(ServerMessage{Type: ServerMessageVideoListChanged})
+		case database.ImageLibraryChanged:
+			rooms.Broadcast(ServerMessage{Type: ServerMessageImageListChanged})
+		}
+	}
 }
 
 func setLogLevel(levelName string) {
@@ -93,15 +187,162 @@ func fileConfig(filePath string, config Config) Config {
 	}
 
 	mediaSection := configFile.Section("media")
+	roomsSection := configFile.Section("rooms")
+	syncSection := configFile.Section("sync")
+	chatSection := configFile.Section("chat")
+	adminSection := configFile.Section("admin")
+	accessSection := configFile.Section("access")
+	accountsSection := configFile.Section("accounts")
+	oidcSection := configFile.Section("oidc")
+	rateLimitsSection := configFile.Section("rate-limits")
+
+	layouts, err := layoutsFromFile(configFile, config.Layouts)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid theater layout")
+	}
+
+	layouts.DefaultLayout = roomsSection.Key("layout").MustString(layouts.DefaultLayout)
+
+	roomPasswords := map[string]string{}
+	for roomID, password := range config.Access.RoomPasswords {
+		roomPasswords[roomID] = password
+	}
+	for _, key := range configFile.Section("room-passwords").Keys() {
+		roomPasswords[key.Name()] = key.String()
+	}
+
+	groupRoles := map[string]string{}
+	for group, role := range config.OIDC.GroupRoles {
+		groupRoles[group] = role
+	}
+	for _, key := range configFile.Section("oidc-group-roles").Keys() {
+		if !validRole(Role(key.String())) {
+			log.WithFields(log.Fields{
+				"group": key.Name(),
+				"role":  key.String(),
+			}).Fatal("Unknown role for identity provider group")
+		}
+
+		groupRoles[key.Name()] = key.String()
+	}
+
+	rateLimits := rateLimitsFromFile(rateLimitsSection, config.RateLimits.Limits)
+
+	scopes := config.OIDC.Scopes
+	if oidcSection.HasKey("scopes") {
+		scopes = oidcSection.Key("scopes").Strings(",")
+	}
+
+	trustedProxies := config.Access.TrustedProxies
+	if accessSection.HasKey("trusted-proxies") {
+		trustedProxies, err = parseTrustedProxies(accessSection.Key("trusted-proxies").Strings(","))
+		if err != nil {
+			log.WithError(err).Fatal("Invalid trusted proxies")
+		}
+	}
+
+	allowedGroups := config.OIDC.AllowedGroups
+	if oidcSection.HasKey("allowed-groups") {
+		allowedGroups = oidcSection.Key("allowed-groups").Strings(",")
+	}
+
 	return Config{
-		DatabasePath:    mediaSection.Key("database").MustString(config.DatabasePath),
-		ImagesPath:      mediaSection.Key("images").MustString(config.ImagesPath),
-		VideosPath:      mediaSection.Key("videos").MustString(config.VideosPath),
-		ThumbnailsPath:  mediaSection.Key("thumbnails").MustString(config.ThumbnailsPath),
+		LogLevel: config.LogLevel,
+
+		DatabasePath:   mediaSection.Key("database").MustString(config.DatabasePath),
+		ImagesPath:     mediaSection.Key("images").MustString(config.ImagesPath),
+		VideosPath:     mediaSection.Key("videos").MustString(config.VideosPath),
+		ThumbnailsPath: mediaSection.Key("thumbnails").MustString(config.ThumbnailsPath),
+		HLSPath:        mediaSection.Key("hls").MustString(config.HLSPath),
+		SubtitlesPath:  mediaSection.Key("subtitles").MustString(config.SubtitlesPath),
+
+		EnableHLSTranscode: mediaSection.Key("transcode-hls").MustBool(config.EnableHLSTranscode),
+		RescanInterval:     mediaSection.Key("rescan-interval").MustDuration(config.RescanInterval),
+
+		RoomGracePeriod: roomsSection.Key("grace-period").MustDuration(config.RoomGracePeriod),
+		SeatGracePeriod: roomsSection.Key("seat-grace-period").MustDuration(config.SeatGracePeriod),
+		SyncInterval:    syncSection.Key("interval").MustDuration(config.SyncInterval),
+		DriftThreshold:  syncSection.Key("drift-threshold").MustDuration(config.DriftThreshold),
+		ReadyTimeout:    syncSection.Key("ready-timeout").MustDuration(config.ReadyTimeout),
+
+		ChatHistoryLength: chatSection.Key("history-length").MustInt(config.ChatHistoryLength),
+
+		Layouts:     layouts,
+		LayoutsFile: roomsSection.Key("layouts-file").MustString(config.LayoutsFile),
+		WaitingList: roomsSection.Key("waiting-list").MustBool(config.WaitingList),
+
+		AdminToken:    adminSection.Key("token").MustString(config.AdminToken),
+		UploadsPath:   adminSection.Key("uploads").MustString(config.UploadsPath),
+		MaxUploadSize: adminSection.Key("max-upload-size").MustInt64(config.MaxUploadSize),
+
+		Access: AccessConfig{
+			Password:       accessSection.Key("password").MustString(config.Access.Password),
+			RoomPasswords:  roomPasswords,
+			InviteSecret:   accessSection.Key("invite-secret").MustString(config.Access.InviteSecret),
+			InviteOnly:     accessSection.Key("invite-only").MustBool(config.Access.InviteOnly),
+			InviteLifetime: accessSection.Key("invite-lifetime").MustDuration(config.Access.InviteLifetime),
+			TrustedProxies: trustedProxies,
+		},
+		Accounts: AccountConfig{
+			SessionLifetime: accountsSection.Key("session-lifetime").MustDuration(config.Accounts.SessionLifetime),
+			Registration:    accountsSection.Key("registration").MustBool(config.Accounts.Registration),
+		},
+		OIDC: OIDCConfig{
+			Issuer:        oidcSection.Key("issuer").MustString(config.OIDC.Issuer),
+			DiscoveryURL:  oidcSection.Key("discovery-url").MustString(config.OIDC.DiscoveryURL),
+			ClientID:      oidcSection.Key("client-id").MustString(config.OIDC.ClientID),
+			ClientSecret:  oidcSection.Key("client-secret").MustString(config.OIDC.ClientSecret),
+			RedirectURL:   oidcSection.Key("redirect-url").MustString(config.OIDC.RedirectURL),
+			Scopes:        scopes,
+			GroupsClaim:   oidcSection.Key("groups-claim").MustString(config.OIDC.GroupsClaim),
+			AllowedGroups: allowedGroups,
+			GroupRoles:    groupRoles,
+		},
+
+		RateLimits: RateLimitConfig{
+			Limits:       rateLimits,
+			MuteAfter:    rateLimitsSection.Key("mute-after").MustInt(config.RateLimits.MuteAfter),
+			StrikeWindow: rateLimitsSection.Key("strike-window").MustDuration(config.RateLimits.StrikeWindow),
+			MuteDuration: rateLimitsSection.Key("mute-duration").MustDuration(config.RateLimits.MuteDuration),
+		},
+
 		WebServerConfig: webserver.FileConfig(configFile, config.WebServerConfig),
 	}
 }
 
+// A rate of zero removes the limit.
+func rateLimitsFromFile(section *ini.Section, limits map[MessageType]RateLimit) map[MessageType]RateLimit {
+	fileLimits := map[MessageType]RateLimit{}
+	for messageType, limit := range limits {
+		fileLimits[messageType] = limit
+	}
+
+	for _, key := range section.Keys() {
+		typeName, isRate := strings.CutSuffix(key.Name(), "-rate")
+		typeName, isBurst := strings.CutSuffix(typeName, "-burst")
+		if !isRate && !isBurst {
+			continue
+		}
+
+		// Leaving has to get through, or the client is never cleaned up.
+		messageType := MessageType(typeName)
+		if messageType == MessageDisconnect {
+			continue
+		}
+
+		limit := fileLimits[messageType]
+		limit.Rate = section.Key(typeName + "-rate").MustFloat64(limit.Rate)
+		limit.Burst = section.Key(typeName + "-burst").MustInt(limit.Burst)
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+
+		fileLimits[messageType] = limit
+	}
+
+	return fileLimits
+}
+
 func commandLineConfig(config Config) Config {
 	logLevel := flag.String("log-level", config.LogLevel,
 		"Log level (panic, fatal, error, warn, info, debug and trace)")
@@ -110,8 +351,58 @@ func commandLineConfig(config Config) Config {
 	videosPath := flag.String("vids", config.VideosPath, "Path to videos")
 	imagesPath := flag.String("images", config.ImagesPath, "Path to images")
 	thumbnailsPath := flag.String("thumbnails", config.ThumbnailsPath, "Path to thumbnails")
+	hlsPath := flag.String("hls", config.HLSPath, "Path to HLS transcodes")
+	subtitlesPath := flag.String("subtitles", config.SubtitlesPath, "Path to converted subtitles")
+	disableHLSTranscode := flag.Bool("disable-hls", !config.EnableHLSTranscode, "Disable HLS transcoding of videos")
+	rescanInterval := flag.Duration("rescan-interval", config.RescanInterval,
+		"How often to rescan the media library for changes (0 to disable)")
+
+	roomGracePeriod := flag.Duration("room-grace-period", config.RoomGracePeriod,
+		"How long an empty room is kept before being torn down")
+	seatGracePeriod := flag.Duration("seat-grace-period", config.SeatGracePeriod,
+		"How long a disconnected viewer's seat is reserved for them to reconnect")
+	syncInterval := flag.Duration("sync-interval", config.SyncInterval,
+		"How often client clocks are synced and playback position broadcast (0 to disable)")
+	driftThreshold := flag.Duration("drift-threshold", config.DriftThreshold,
+		"How far a client can drift from the room before being nudged back")
+	readyTimeout := flag.Duration("ready-timeout", config.ReadyTimeout,
+		"How long to wait for every client to buffer before playing anyway (0 to wait forever)")
+
+	chatHistoryLength := flag.Int("chat-history", config.ChatHistoryLength,
+		"How many recent chat messages are sent to viewers as they join")
+
+	defaultLayout := flag.String("layout", config.Layouts.DefaultLayout, "Name of the theater layout rooms use by default")
+	layoutsFile := flag.String("layouts", config.LayoutsFile, "Path to a file of extra theater layouts")
+	disableWaitingList := flag.Bool("disable-waiting-list", !config.WaitingList,
+		"Leave viewers who join a full theater spectating, rather than queueing them for a seat")
+
+	adminToken := flag.String("admin-token", config.AdminToken, "Bearer token for the admin API (empty to disable it)")
+	uploadsPath := flag.String("uploads", config.UploadsPath, "Path to keep partial uploads in")
+	maxUploadSize := flag.Int64("max-upload-size", config.MaxUploadSize, "Largest file that can be uploaded, in bytes")
+
+	roomPassword := flag.String("room-password", config.Access.Password,
+		"Password needed to join rooms without one of their own (empty for none)")
+	inviteSecret := flag.String("invite-secret", config.Access.InviteSecret,
+		"Secret invites to rooms are signed with (empty to disable invites)")
+	inviteOnly := flag.Bool("invite-only", config.Access.InviteOnly, "Only let viewers with an invite or password join rooms")
+	inviteLifetime := flag.Duration("invite-lifetime", config.Access.InviteLifetime, "How long invites last by default")
+
+	sessionLifetime := flag.Duration("session-lifetime", config.Accounts.SessionLifetime, "How long viewers stay logged in for")
+	enableRegistration := flag.Bool("enable-registration", config.Accounts.Registration,
+		"Let anyone create an account, rather than only through the admin API")
+
+	oidcIssuer := flag.String("oidc-issuer", config.OIDC.Issuer,
+		"OpenID Connect issuer everyone has to sign in through (empty to disable single sign-on)")
+	oidcDiscoveryURL := flag.String("oidc-discovery-url", config.OIDC.DiscoveryURL,
+		"Where to fetch the identity provider's metadata from, if not the issuer")
+	oidcClientID := flag.String("oidc-client-id", config.OIDC.ClientID, "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", config.OIDC.ClientSecret, "OpenID Connect client secret")
+	oidcRedirectURL := flag.String("oidc-redirect-url", config.OIDC.RedirectURL,
+		"URL the identity provider sends people back to (empty to use the host they visited)")
 
 	webserverConfig := webserver.CommandLineConfig(config.WebServerConfig)
+	layouts := config.Layouts
+	layouts.DefaultLayout = *defaultLayout
 	return Config{
 		LogLevel: *logLevel,
 
@@ -119,6 +410,53 @@ func commandLineConfig(config Config) Config {
 		VideosPath:     *videosPath,
 		ImagesPath:     *imagesPath,
 		ThumbnailsPath: *thumbnailsPath,
+		HLSPath:        *hlsPath,
+		SubtitlesPath:  *subtitlesPath,
+
+		EnableHLSTranscode: !*disableHLSTranscode,
+		RescanInterval:     *rescanInterval,
+
+		RoomGracePeriod: *roomGracePeriod,
+		SeatGracePeriod: *seatGracePeriod,
+		SyncInterval:    *syncInterval,
+		DriftThreshold:  *driftThreshold,
+		ReadyTimeout:    *readyTimeout,
+
+		ChatHistoryLength: *chatHistoryLength,
+
+		Layouts:     layouts,
+		LayoutsFile: *layoutsFile,
+		WaitingList: !*disableWaitingList,
+
+		AdminToken:    *adminToken,
+		UploadsPath:   *uploadsPath,
+		MaxUploadSize: *maxUploadSize,
+
+		Access: AccessConfig{
+			Password:       *roomPassword,
+			RoomPasswords:  config.Access.RoomPasswords,
+			InviteSecret:   *inviteSecret,
+			InviteOnly:     *inviteOnly,
+			InviteLifetime: *inviteLifetime,
+			TrustedProxies: config.Access.TrustedProxies,
+		},
+		Accounts: AccountConfig{
+			SessionLifetime: *sessionLifetime,
+			Registration:    *enableRegistration,
+		},
+		OIDC: OIDCConfig{
+			Issuer:        *oidcIssuer,
+			DiscoveryURL:  *oidcDiscoveryURL,
+			ClientID:      *oidcClientID,
+			ClientSecret:  *oidcClientSecret,
+			RedirectURL:   *oidcRedirectURL,
+			Scopes:        config.OIDC.Scopes,
+			GroupsClaim:   config.OIDC.GroupsClaim,
+			AllowedGroups: config.OIDC.AllowedGroups,
+			GroupRoles:    config.OIDC.GroupRoles,
+		},
+
+		RateLimits: config.RateLimits,
 
 		WebServerConfig: webserverConfig,
 	}
@@ -130,19 +468,43 @@ func main() {
 	config = commandLineConfig(config)
 	setLogLevel(config.LogLevel)
 
-	db, err := setupDatabase(config)
+	if config.OIDC.Enabled() && config.Accounts.Registration {
+		log.Warn("Disabling registration, as it would let people skip single sign-on")
+		config.Accounts.Registration = false
+	}
+
+	layouts, err := setupLayouts(config)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid theater layout")
+	}
+
+	db, library, libraryChanges, err := setupDatabase(config)
 	if err != nil {
 		panic(err)
 	}
 
 	clients := make(chan Client)
-	serverMessages := make(chan ServerMessage)
-	go StartServer(db, serverMessages)
-	go ListenForNewClients(clients, serverMessages)
+	rooms := NewRoomRegistry(db, RoomConfig{
+		EmptyRoomGracePeriod: config.RoomGracePeriod,
+		SeatGracePeriod:      config.SeatGracePeriod,
+		SyncInterval:         config.SyncInterval,
+		DriftThreshold:       config.DriftThreshold,
+		ReadyTimeout:         config.ReadyTimeout,
+		ChatHistoryLength:    config.ChatHistoryLength,
+		Layouts:              layouts,
+		WaitingList:          config.WaitingList,
+		RateLimits:           config.RateLimits,
+	})
+	go ListenForNewClients(clients, rooms)
+	go forwardLibraryChanges(libraryChanges, rooms)
 
+	accounts := newAccountAPI(config.Accounts, db)
 	webHandler := webserver.Handler(config.WebServerConfig)
-	connectionHandler := ConnectionHandler(clients, webHandler)
-	if err := webserver.Listen(config.WebServerConfig, connectionHandler); err != nil {
+	connectionHandler := ConnectionHandler(clients, db, config.Access, accounts, webHandler)
+	accountHandler := AccountHandler(accounts, connectionHandler)
+	oidcHandler := OIDCHandler(newOIDCLogin(config.OIDC, db, accounts), accountHandler)
+	adminHandler := AdminHandler(config, db, library, rooms, oidcHandler)
+	if err := webserver.Listen(config.WebServerConfig, adminHandler); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,356 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"nhooyr.io/websocket"
+	"strconv"
+	"strings"
+	"time"
+	"watch-party/database"
+)
+
+const StatusBanned = websocket.StatusCode(4010)
+
+const DefaultKickReason = "Removed by a moderator"
+
+type Mute struct {
+	Reason string
+
+	// Zero if they're muted until someone unmutes them.
+	Until time.Time
+}
+
+func (mute Mute) active(now time.Time) bool {
+	return mute.Until.IsZero() || now.Before(mute.Until)
+}
+
+func (mute Mute) outlasts(until time.Time) bool {
+	return mute.Until.IsZero() || mute.Until.After(until)
+}
+
+type ModerationTarget struct {
+	Seat
+	SpectatorID uint `json:"spectator_id,omitempty"`
+}
+
+type ModerateMessage struct {
+	ModerationTarget
+	Reason string `json:"reason"`
+
+	// In seconds. Zero bans forever, or mutes until they're unmuted.
+	Duration float64 `json:"duration"`
+}
+
+type UnmuteMessage struct {
+	ModerationTarget
+}
+
+type MutedMessage struct {
+	Muted  bool       `json:"muted"`
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// X-Forwarded-For is only followed back through trusted proxies.
+func remoteAddress(request *http.Request, trustedProxies []*net.IPNet) string {
+	address, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		address = request.RemoteAddr
+	}
+
+	forwarded := strings.Split(strings.Join(request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && trustedProxy(trustedProxies, address); i-- {
+		forwardedAddress := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(forwardedAddress) == nil {
+			break
+		}
+
+		address = forwardedAddress
+	}
+
+	return address
+}
+
+// Anyone not logged in may share their address, so is muted by connection.
+func muteKey(client *Client) string {
+	if client.UserID != 0 {
+		return "user:" + strconv.FormatUint(uint64(client.UserID), 10)
+	}
+
+	return "token:" + *client.Token
+}
+
+func banReason(ban database.Ban) string {
+	reason := "You're banned from this room"
+	if ban.RoomID == "" {
+		reason = "You're banned"
+	}
+
+	if !ban.Permanent() {
+		reason += " until " + ban.ExpiresAt.UTC().Format(time.RFC822)
+	}
+
+	if ban.Reason != "" {
+		reason += ": " + ban.Reason
+	}
+
+	return closeReason(reason)
+}
+
+func moderatorName(client *Client) string {
+	switch {
+	case client.Profile.Name != "":
+		return client.Profile.Name
+	case client.Username != "":
+		return client.Username
+	default:
+		return *client.Token
+	}
+}
+
+func (message ServerMessage) target() ModerationTarget {
+	return ModerationTarget{
+		Seat:        message.Seat,
+		SpectatorID: message.SpectatorID,
+	}
+}
+
+func (server *Server) findSpectator(spectatorID uint) *Client {
+	for _, client := range server.connectedClients {
+		if client.SpectatorID == spectatorID {
+			return client
+		}
+	}
+
+	return nil
+}
+
+func (server *Server) moderationTarget(token string, moderationTarget ModerationTarget) *Client {
+	sender, senderExists := server.connectedClients[token]
+	if !senderExists {
+		return nil
+	}
+
+	var target *Client
+	if moderationTarget.SpectatorID != 0 {
+		target = server.findSpectator(moderationTarget.SpectatorID)
+		if target == nil {
+			server.sendError(token, "invalid-spectator", "That viewer has left")
+			return nil
+		}
+	} else if targetToken := server.stage.PlayerInSeat(moderationTarget.Seat); targetToken != nil {
+		target = server.connectedClients[*targetToken]
+	}
+
+	if target == nil {
+		server.sendError(token, "invalid-seat", "Nobody is sitting there")
+		return nil
+	}
+
+	if target.Role.AtLeast(sender.Role) {
+		server.sendError(token, "permission-denied", "You can only moderate viewers with a lower role than yours")
+		return nil
+	}
+
+	return target
+}
+
+func (server *Server) disconnect(token string, status websocket.StatusCode, reason string) error {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return ErrNoSuchClient
+	}
+
+	delete(server.sessions, client.SessionToken)
+
+	// Closing waits for the client to reply, so don't hold up the room.
+	go client.Connection.Close(status, closeReason(reason))
+	return nil
+}
+
+func (server *Server) kickViewer(token string, moderationTarget ModerationTarget, reason string) {
+	target := server.moderationTarget(token, moderationTarget)
+	if target == nil {
+		return
+	}
+
+	if reason == "" {
+		reason = DefaultKickReason
+	}
+
+	_ = server.kick(*target.Token, reason)
+}
+
+func (server *Server) ban(token string, moderationTarget ModerationTarget, reason string, duration time.Duration) {
+	if duration < 0 {
+		server.sendError(token, "invalid-duration", "Bans can't last a negative time")
+		return
+	}
+
+	target := server.moderationTarget(token, moderationTarget)
+	if target == nil {
+		return
+	}
+
+	ban := database.Ban{
+		RoomID:   server.roomID,
+		Reason:   reason,
+		BannedBy: moderatorName(server.connectedClients[token]),
+	}
+
+	if target.UserID != 0 {
+		ban.UserID = target.UserID
+	} else {
+		ban.Address = target.Address
+	}
+
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	if err := database.CreateBan(server.db, &ban); err != nil {
+		log.WithError(err).Error("Unable to save ban")
+		server.sendError(token, "ban-failed", "Unable to ban that viewer")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"token":    token,
+		"target":   *target.Token,
+		"room":     server.roomID,
+		"duration": duration,
+		"reason":   reason,
+	}).Info("Banned viewer")
+
+	server.enforceBans()
+}
+
+// Moderators may share an address with who they banned.
+func (server *Server) enforceBans() {
+	for token, client := range server.connectedClients {
+		if client.Role.AtLeast(RoleModerator) {
+			continue
+		}
+
+		ban, err := database.FindBan(server.db, server.roomID, client.UserID, client.Address)
+		if err != nil {
+			log.WithError(err).Error("Unable to query bans")
+			return
+		}
+
+		if ban == nil {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"token": token,
+			"room":  server.roomID,
+			"ban":   ban.ID,
+		}).Info("Disconnecting banned viewer")
+
+		_ = server.disconnect(token, StatusBanned, banReason(*ban))
+	}
+}
+
+func (server *Server) activeMute(client *Client) (Mute, bool) {
+	key := muteKey(client)
+	mute, has := server.mutes[key]
+	if !has {
+		return Mute{}, false
+	}
+
+	if !mute.active(time.Now()) {
+		delete(server.mutes, key)
+		return Mute{}, false
+	}
+
+	return mute, true
+}
+
+func (server *Server) refuseMuted(client *Client) bool {
+	if _, muted := server.activeMute(client); !muted {
+		return false
+	}
+
+	server.sendError(*client.Token, "muted", "You've been muted")
+	return true
+}
+
+func (server *Server) sendMuted(client *Client) {
+	mute, muted := server.activeMute(client)
+	message := MutedMessage{
+		Muted:  muted,
+		Reason: mute.Reason,
+	}
+
+	if muted && !mute.Until.IsZero() {
+		message.Until = &mute.Until
+	}
+
+	_ = client.Send(MessageMuted, message)
+}
+
+func (server *Server) sendMuteState(client *Client) {
+	if _, muted := server.activeMute(client); muted {
+		server.sendMuted(client)
+	}
+}
+
+func (server *Server) muteClient(client *Client, reason string, duration time.Duration) {
+	mute := Mute{Reason: reason}
+	if duration > 0 {
+		mute.Until = time.Now().Add(duration)
+	}
+
+	key := muteKey(client)
+	server.mutes[key] = mute
+
+	log.WithFields(log.Fields{
+		"token":    *client.Token,
+		"room":     server.roomID,
+		"duration": duration,
+		"reason":   reason,
+	}).Info("Muted viewer")
+
+	for _, other := range server.connectedClients {
+		if muteKey(other) == key {
+			server.sendMuted(other)
+		}
+	}
+}
+
+func (server *Server) mute(token string, moderationTarget ModerationTarget, reason string, duration time.Duration) {
+	if duration < 0 {
+		server.sendError(token, "invalid-duration", "Mutes can't last a negative time")
+		return
+	}
+
+	if target := server.moderationTarget(token, moderationTarget); target != nil {
+		server.muteClient(target, reason, duration)
+	}
+}
+
+func (server *Server) unmute(token string, moderationTarget ModerationTarget) {
+	target := server.moderationTarget(token, moderationTarget)
+	if target == nil {
+		return
+	}
+
+	key := muteKey(target)
+	delete(server.mutes, key)
+
+	log.WithFields(log.Fields{
+		"token":  token,
+		"target": *target.Token,
+		"room":   server.roomID,
+	}).Info("Unmuted viewer")
+
+	for _, other := range server.connectedClients {
+		if muteKey(other) == key {
+			server.sendMuted(other)
+		}
+	}
+}
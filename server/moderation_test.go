@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestModeratorName(t *testing.T) {
+	token := "moderator-token"
+	tests := []struct {
+		name     string
+		client   Client
+		expected string
+	}{
+		{name: "display name", client: Client{Token: &token, Username: "alice", Profile: Profile{Name: "Alice"}}, expected: "Alice"},
+		{name: "username", client: Client{Token: &token, Username: "alice"}, expected: "alice"},
+		{name: "anonymous", client: Client{Token: &token}, expected: token},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if name := moderatorName(&test.client); name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+func TestRemoteAddress(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		proxies    []*net.IPNet
+		expected   string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", proxies: proxies, expected: "203.0.113.7"},
+		{name: "no trusted proxies", remoteAddr: "127.0.0.1:5000", forwarded: []string{"203.0.113.7"}, expected: "127.0.0.1"},
+		{name: "untrusted proxy", remoteAddr: "198.51.100.1:5000", forwarded: []string{"203.0.113.7"}, proxies: proxies, expected: "198.51.100.1"},
+		{name: "trusted proxy", remoteAddr: "127.0.0.1:5000", forwarded: []string{"203.0.113.7"}, proxies: proxies, expected: "203.0.113.7"},
+		{name: "chain of trusted proxies", remoteAddr: "127.0.0.1:5000", forwarded: []string{"203.0.113.7, 10.1.2.3"}, proxies: proxies, expected: "203.0.113.7"},
+		{name: "several headers", remoteAddr: "127.0.0.1:5000", forwarded: []string{"203.0.113.7", "10.1.2.3"}, proxies: proxies, expected: "203.0.113.7"},
+		{name: "spoofed by the viewer", remoteAddr: "127.0.0.1:5000", forwarded: []string{"192.0.2.1, 203.0.113.7"}, proxies: proxies, expected: "203.0.113.7"},
+		{name: "garbage", remoteAddr: "127.0.0.1:5000", forwarded: []string{"not-an-address"}, proxies: proxies, expected: "127.0.0.1"},
+		{name: "empty header", remoteAddr: "127.0.0.1:5000", forwarded: []string{""}, proxies: proxies, expected: "127.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for _, forwarded := range test.forwarded {
+				request.Header.Add("X-Forwarded-For", forwarded)
+			}
+
+			if address := remoteAddress(request, test.proxies); address != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, address)
+			}
+		})
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 	"net/http"
 	"nhooyr.io/websocket"
+	"time"
+	"watch-party/database"
 )
 
 type MessageType string
@@ -16,11 +19,30 @@ type Message struct {
 }
 
 type Client struct {
-	Messages   <-chan Message
-	Connection *websocket.Conn
-	Context    *context.Context
-	Token      *string
-	Ready      bool
+	RoomID       string
+	Messages     <-chan Message
+	Connection   *websocket.Conn
+	Context      *context.Context
+	Token        *string
+	SessionToken string
+	Profile      Profile
+	Role         Role
+	JoinedAt     time.Time
+	Clock        ClockEstimate
+	Ready        bool
+	CatchingUp   bool
+
+	Collection string
+
+	Address string
+
+	// Zero if the client isn't logged in.
+	UserID   uint
+	Username string
+
+	GroupRole Role
+
+	SpectatorID uint
 }
 
 func (client *Client) Send(messageType MessageType, data interface{}) error {
@@ -45,9 +67,23 @@ func (client *Client) Send(messageType MessageType, data interface{}) error {
 func handleSocketConnection(
 	response http.ResponseWriter,
 	request *http.Request,
+	db *gorm.DB,
+	access AccessConfig,
+	accounts *accountAPI,
 	clients chan<- Client,
 ) {
-	log.Info("Opening new web socket connection")
+	roomID := request.URL.Query().Get("room")
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	if !ValidRoomID(roomID) {
+		log.WithField("room", roomID).Warn("Rejecting connection with invalid room ID")
+		http.Error(response, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	log.WithField("room", roomID).Info("Opening new web socket connection")
 
 	connection, err := websocket.Accept(response, request, nil)
 	if err != nil {
@@ -55,18 +91,69 @@ func handleSocketConnection(
 		return
 	}
 
+	query := request.URL.Query()
+	if status, reason, allowed := access.checkAccess(roomID, query.Get("password"), query.Get("invite")); !allowed {
+		log.WithFields(log.Fields{
+			"room":   roomID,
+			"status": status,
+			"reason": reason,
+		}).Warn("Rejected join")
+
+		_ = connection.Close(status, reason)
+		return
+	}
+
+	user := accounts.sessionUser(request)
+	address := remoteAddress(request, access.TrustedProxies)
+	userID := uint(0)
+	if user != nil {
+		userID = user.ID
+	}
+
+	ban, err := database.FindBan(db, roomID, userID, address)
+	if err != nil {
+		log.WithError(err).Error("Unable to query bans")
+		_ = connection.Close(websocket.StatusInternalError, "Unable to join")
+		return
+	}
+
+	if ban != nil {
+		log.WithFields(log.Fields{
+			"room":    roomID,
+			"address": address,
+			"ban":     ban.ID,
+		}).Warn("Rejected banned join")
+
+		_ = connection.Close(StatusBanned, banReason(*ban))
+		return
+	}
+
 	requestContext := request.Context()
 	messages := make(chan Message)
 	defer connection.Close(websocket.StatusNormalClosure, "")
 	defer close(messages)
 
-	clients <- Client{
-		Messages:   messages,
-		Connection: connection,
-		Context:    &requestContext,
-		Ready:      false,
+	client := Client{
+		RoomID:       roomID,
+		Messages:     messages,
+		Connection:   connection,
+		Context:      &requestContext,
+		SessionToken: request.URL.Query().Get("session"),
+		Profile:      Profile{Name: sanitizeDisplayName(request.URL.Query().Get("name"))},
+		JoinedAt:     time.Now(),
+		Ready:        false,
+		Address:      address,
+	}
+
+	if user != nil {
+		client.UserID = user.ID
+		client.Username = user.Username
+		client.Profile = userProfile(*user)
+		client.GroupRole = Role(user.GroupRole)
 	}
 
+	clients <- client
+
 	for {
 		_, content, err := connection.Read(requestContext)
 		if err != nil {
@@ -89,11 +176,17 @@ func handleSocketConnection(
 	}
 }
 
-func ConnectionHandler(clients chan<- Client, webHandler http.HandlerFunc) http.HandlerFunc {
+func ConnectionHandler(
+	clients chan<- Client,
+	db *gorm.DB,
+	access AccessConfig,
+	accounts *accountAPI,
+	webHandler http.HandlerFunc,
+) http.HandlerFunc {
 	return func(response http.ResponseWriter, request *http.Request) {
 		url := request.URL.Path
 		if request.Header.Get("Upgrade") == "websocket" && url == "/socket" {
-			handleSocketConnection(response, request, clients)
+			handleSocketConnection(response, request, db, access, accounts, clients)
 			return
 		}
 
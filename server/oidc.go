@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"watch-party/database"
+)
+
+const OIDCLoginPath = "/auth/login"
+const OIDCCallbackPath = "/auth/callback"
+const OIDCStateCookieName = "watch_party_oidc"
+
+var ErrOIDCNonceMismatch = errors.New("id token nonce doesn't match")
+
+type OIDCConfig struct {
+	// Empty disables single sign-on.
+	Issuer string
+
+	// Defaults to the issuer, for providers reached at another address.
+	DiscoveryURL string
+
+	ClientID     string
+	ClientSecret string
+
+	// Defaults to the callback path on whichever host the request came in on.
+	RedirectURL string
+	Scopes      []string
+
+	GroupsClaim string
+
+	// Only members of one of these groups can sign in, anyone can if empty.
+	AllowedGroups []string
+
+	// A room only has one host, so host isn't given out by groups.
+	GroupRoles map[string]string
+}
+
+func defaultOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Scopes:      DefaultOIDCScopes,
+		GroupsClaim: DefaultOIDCGroupsClaim,
+		GroupRoles:  map[string]string{},
+	}
+}
+
+func (config OIDCConfig) Enabled() bool {
+	return config.Issuer != ""
+}
+
+type Identity struct {
+	Subject  string
+	Username string
+	Name     string
+	Groups   []string
+}
+
+type identityProvider interface {
+	AuthCodeURL(ctx context.Context, redirectURL string, state string, nonce string) (string, error)
+	Exchange(ctx context.Context, redirectURL string, code string, nonce string) (Identity, error)
+}
+
+type oidcProvider struct {
+	config OIDCConfig
+
+	lock     sync.Mutex
+	provider *oidc.Provider
+}
+
+type idTokenClaims struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+	Name              string `json:"name"`
+}
+
+func (provider *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+
+	if provider.provider != nil {
+		return provider.provider, nil
+	}
+
+	discoveryURL := provider.config.Issuer
+	if provider.config.DiscoveryURL != "" {
+		discoveryURL = provider.config.DiscoveryURL
+		ctx = oidc.InsecureIssuerURLContext(ctx, provider.config.Issuer)
+	}
+
+	discovered, err := oidc.NewProvider(ctx, discoveryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("issuer", provider.config.Issuer).Info("Discovered identity provider")
+	provider.provider = discovered
+	return discovered, nil
+}
+
+func (provider *oidcProvider) oauthConfig(discovered *oidc.Provider, redirectURL string) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     provider.config.ClientID,
+		ClientSecret: provider.config.ClientSecret,
+		Endpoint:     discovered.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       provider.config.Scopes,
+	}
+}
+
+func (provider *oidcProvider) AuthCodeURL(ctx context.Context, redirectURL string, state string, nonce string) (string, error) {
+	discovered, err := provider.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	oauthConfig := provider.oauthConfig(discovered, redirectURL)
+	return oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+func (provider *oidcProvider) Exchange(ctx context.Context, redirectURL string, code string, nonce string) (Identity, error) {
+	discovered, err := provider.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	oauthConfig := provider.oauthConfig(discovered, redirectURL)
+	token, err := oauthConfig.Exchange(ctx, code)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("no id token in token response")
+	}
+
+	verifier := discovered.Verifier(&oidc.Config{ClientID: provider.config.ClientID})
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if idToken.Nonce != nonce {
+		return Identity{}, ErrOIDCNonceMismatch
+	}
+
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+
+	var allClaims map[string]interface{}
+	if err := idToken.Claims(&allClaims); err != nil {
+		return Identity{}, err
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	return Identity{
+		Subject:  claims.Subject,
+		Username: username,
+		Name:     claims.Name,
+		Groups:   claimStrings(allClaims[provider.config.GroupsClaim]),
+	}, nil
+}
+
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+type oidcLogin struct {
+	db       *gorm.DB
+	config   OIDCConfig
+	provider identityProvider
+	accounts *accountAPI
+}
+
+func newOIDCLogin(config OIDCConfig, db *gorm.DB, accounts *accountAPI) *oidcLogin {
+	return &oidcLogin{
+		db:       db,
+		config:   config,
+		provider: &oidcProvider{config: config},
+		accounts: accounts,
+	}
+}
+
+func randomState() (string, error) {
+	stateBytes := make([]byte, 16)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(stateBytes), nil
+}
+
+func safeReturnPath(returnPath string) string {
+	if !strings.HasPrefix(returnPath, "/") || strings.HasPrefix(returnPath, "//") ||
+		strings.HasPrefix(returnPath, "/\\") {
+		return "/"
+	}
+
+	return returnPath
+}
+
+func (login *oidcLogin) redirectURL(request *http.Request) string {
+	if login.config.RedirectURL != "" {
+		return login.config.RedirectURL
+	}
+
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + request.Host + OIDCCallbackPath
+}
+
+func (login *oidcLogin) groupsAllowed(groups []string) bool {
+	if len(login.config.AllowedGroups) == 0 {
+		return true
+	}
+
+	for _, group := range groups {
+		for _, allowed := range login.config.AllowedGroups {
+			if group == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (login *oidcLogin) groupRole(groups []string) Role {
+	role := RoleViewer
+	for _, group := range groups {
+		groupRole := Role(login.config.GroupRoles[group])
+		if validRole(groupRole) && groupRole.rank() > role.rank() {
+			role = groupRole
+		}
+	}
+
+	return role
+}
+
+func (login *oidcLogin) setStateCookie(response http.ResponseWriter, request *http.Request, value string, maxAge int) {
+	http.SetCookie(response, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    value,
+		Path:     OIDCCallbackPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (login *oidcLogin) begin(response http.ResponseWriter, request *http.Request) {
+	state, err := randomState()
+	if err != nil {
+		log.WithError(err).Error("Unable to generate sign in state")
+		http.Error(response, "Unable to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := randomState()
+	if err != nil {
+		log.WithError(err).Error("Unable to generate sign in nonce")
+		http.Error(response, "Unable to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := login.provider.AuthCodeURL(request.Context(), login.redirectURL(request), state, nonce)
+	if err != nil {
+		log.WithError(err).Error("Unable to discover identity provider")
+		http.Error(response, "Unable to reach the identity provider", http.StatusBadGateway)
+		return
+	}
+
+	returnPath := safeReturnPath(request.URL.Query().Get("next"))
+	cookieValue := state + "." + nonce + "." + base64.RawURLEncoding.EncodeToString([]byte(returnPath))
+	login.setStateCookie(response, request, cookieValue, int(OIDCStateLifetime.Seconds()))
+	http.Redirect(response, request, authURL, http.StatusFound)
+}
+
+func (login *oidcLogin) finish(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		log.WithFields(log.Fields{
+			"error":       providerError,
+			"description": query.Get("error_description"),
+		}).Warn("Identity provider refused sign in")
+		http.Error(response, "Sign in was cancelled or refused", http.StatusForbidden)
+		return
+	}
+
+	cookie, err := request.Cookie(OIDCStateCookieName)
+	if err != nil {
+		http.Error(response, "Sign in expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	login.setStateCookie(response, request, "", -1)
+	parts := strings.SplitN(cookie.Value, ".", 3)
+	if len(parts) != 3 || query.Get("state") == "" || query.Get("state") != parts[0] {
+		log.Warn("Sign in state mismatch")
+		http.Error(response, "Sign in expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	returnPath := "/"
+	if decoded, err := base64.RawURLEncoding.DecodeString(parts[2]); err == nil {
+		returnPath = safeReturnPath(string(decoded))
+	}
+
+	identity, err := login.provider.Exchange(request.Context(), login.redirectURL(request), query.Get("code"), parts[1])
+	if err != nil {
+		log.WithError(err).Warn("Unable to verify sign in")
+		http.Error(response, "Unable to verify sign in", http.StatusUnauthorized)
+		return
+	}
+
+	if !login.groupsAllowed(identity.Groups) {
+		log.WithFields(log.Fields{
+			"subject":  identity.Subject,
+			"username": identity.Username,
+			"groups":   identity.Groups,
+		}).Warn("Refused sign in from outside the allowed groups")
+		http.Error(response, "You aren't allowed to use this server", http.StatusForbidden)
+		return
+	}
+
+	role := login.groupRole(identity.Groups)
+	user, err := database.SaveSSOUser(login.db, login.config.Issuer, identity.Subject,
+		identity.Username, sanitizeDisplayName(identity.Name), string(role))
+	if err != nil {
+		log.WithError(err).Error("Unable to save signed in user")
+		http.Error(response, "Unable to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	if !login.accounts.startSession(response, request, user) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"username": user.Username,
+		"role":     role,
+	}).Info("Signed in through identity provider")
+
+	http.Redirect(response, request, returnPath, http.StatusFound)
+}
+
+func (login *oidcLogin) requireSession(response http.ResponseWriter, request *http.Request) bool {
+	if login.accounts.sessionUser(request) != nil {
+		return true
+	}
+
+	isPage := request.Method == http.MethodGet && request.Header.Get("Upgrade") == "" &&
+		!strings.HasPrefix(request.URL.Path, "/api/")
+	if !isPage {
+		http.Error(response, "Sign in required", http.StatusUnauthorized)
+		return false
+	}
+
+	loginURL := fmt.Sprintf("%s?next=%s", OIDCLoginPath, url.QueryEscape(request.URL.RequestURI()))
+	http.Redirect(response, request, loginURL, http.StatusFound)
+	return false
+}
+
+func OIDCHandler(login *oidcLogin, next http.HandlerFunc) http.HandlerFunc {
+	if !login.config.Enabled() {
+		return next
+	}
+
+	return func(response http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case OIDCLoginPath:
+			login.begin(response, request)
+		case OIDCCallbackPath:
+			login.finish(response, request)
+		default:
+			if login.requireSession(response, request) {
+				next(response, request)
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"watch-party/database"
+)
+
+const fakeIssuer = "https://idp.example"
+
+// fakeProvider signs in whoever its codes were issued for, checking the nonce
+// the way a real ID token would.
+type fakeProvider struct {
+	identities map[string]Identity
+
+	// Overrides the nonce the ID token was issued with, to fake a replay.
+	nonce string
+}
+
+func (provider *fakeProvider) AuthCodeURL(ctx context.Context, redirectURL string, state string, nonce string) (string, error) {
+	query := url.Values{
+		"redirect_uri": {redirectURL},
+		"state":        {state},
+		"nonce":        {nonce},
+	}
+
+	return fakeIssuer + "/authorize?" + query.Encode(), nil
+}
+
+func (provider *fakeProvider) Exchange(ctx context.Context, redirectURL string, code string, nonce string) (Identity, error) {
+	identity, has := provider.identities[code]
+	if !has {
+		return Identity{}, errors.New("invalid code")
+	}
+
+	if provider.nonce != "" && provider.nonce != nonce {
+		return Identity{}, ErrOIDCNonceMismatch
+	}
+
+	return identity, nil
+}
+
+func newTestOIDCLogin(t *testing.T, provider *fakeProvider) (*oidcLogin, http.HandlerFunc) {
+	db, err := database.Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultOIDCConfig()
+	config.Issuer = fakeIssuer
+	config.AllowedGroups = []string{"friends", "admins"}
+	config.GroupRoles = map[string]string{"admins": string(RoleModerator)}
+
+	accounts := newAccountAPI(AccountConfig{SessionLifetime: time.Hour}, db)
+	login := &oidcLogin{
+		db:       db,
+		config:   config,
+		provider: provider,
+		accounts: accounts,
+	}
+
+	page := func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusOK)
+	}
+
+	return login, OIDCHandler(login, AccountHandler(accounts, page))
+}
+
+func serve(handler http.HandlerFunc, method string, target string, cookies []*http.Cookie) *http.Response {
+	request := httptest.NewRequest(method, target, nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder.Result()
+}
+
+func findCookie(response *http.Response, name string) *http.Cookie {
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+
+	return nil
+}
+
+func TestOIDCCallback(t *testing.T) {
+	identities := map[string]Identity{
+		"friend-code": {Subject: "1", Username: "alice", Name: "Alice", Groups: []string{"friends"}},
+		"admin-code":  {Subject: "2", Username: "bob", Name: "Bob", Groups: []string{"friends", "admins"}},
+		"other-code":  {Subject: "3", Username: "eve", Name: "Eve", Groups: []string{"strangers"}},
+	}
+
+	tests := []struct {
+		name      string
+		next      string
+		code      string
+		state     string
+		noCookie  bool
+		nonce     string
+		status    int
+		location  string
+		groupRole Role
+	}{
+		{
+			name:      "viewer",
+			next:      "/room/lobby",
+			code:      "friend-code",
+			status:    http.StatusFound,
+			location:  "/room/lobby",
+			groupRole: RoleViewer,
+		},
+		{
+			name:      "moderator group",
+			code:      "admin-code",
+			status:    http.StatusFound,
+			location:  "/",
+			groupRole: RoleModerator,
+		},
+		{
+			name:      "redirect off site",
+			next:      "//evil.example/",
+			code:      "friend-code",
+			status:    http.StatusFound,
+			location:  "/",
+			groupRole: RoleViewer,
+		},
+		{
+			name:   "outside allowed groups",
+			code:   "other-code",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "unknown code",
+			code:   "made-up-code",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "state mismatch",
+			code:   "friend-code",
+			state:  "forged-state",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:     "no state cookie",
+			code:     "friend-code",
+			noCookie: true,
+			status:   http.StatusBadRequest,
+		},
+		{
+			name:   "nonce mismatch",
+			code:   "friend-code",
+			nonce:  "replayed-nonce",
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &fakeProvider{identities: identities}
+			login, handler := newTestOIDCLogin(t, provider)
+
+			begin := serve(handler, http.MethodGet, OIDCLoginPath+"?next="+url.QueryEscape(test.next), nil)
+			if begin.StatusCode != http.StatusFound {
+				t.Fatalf("expected a redirect to the provider, got %d", begin.StatusCode)
+			}
+
+			authURL, err := url.Parse(begin.Header.Get("Location"))
+			if err != nil || !strings.HasPrefix(authURL.String(), fakeIssuer) {
+				t.Fatalf("expected a redirect to the provider, got %s", begin.Header.Get("Location"))
+			}
+
+			state := authURL.Query().Get("state")
+			if test.state != "" {
+				state = test.state
+			}
+
+			provider.nonce = authURL.Query().Get("nonce")
+			if test.nonce != "" {
+				provider.nonce = test.nonce
+			}
+
+			var cookies []*http.Cookie
+			if !test.noCookie {
+				cookies = begin.Cookies()
+			}
+
+			callback := serve(handler, http.MethodGet,
+				OIDCCallbackPath+"?state="+url.QueryEscape(state)+"&code="+test.code, cookies)
+			if callback.StatusCode != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, callback.StatusCode)
+			}
+
+			session := findCookie(callback, SessionCookieName)
+			if test.status != http.StatusFound {
+				if session != nil {
+					t.Error("expected no session")
+				}
+				return
+			}
+
+			if location := callback.Header.Get("Location"); location != test.location {
+				t.Errorf("expected redirect to %s, got %s", test.location, location)
+			}
+
+			if session == nil {
+				t.Fatal("expected a session")
+			}
+
+			user, err := database.FindSessionUser(login.db, session.Value)
+			if err != nil || user == nil {
+				t.Fatalf("expected the session to be logged in, got %v", err)
+			}
+
+			identity := identities[test.code]
+			if user.SSOIssuer != fakeIssuer || user.SSOSubject != identity.Subject || user.Username != identity.Username {
+				t.Errorf("expected user for %+v, got %+v", identity, user)
+			}
+
+			if Role(user.GroupRole) != test.groupRole {
+				t.Errorf("expected group role %s, got %s", test.groupRole, user.GroupRole)
+			}
+		})
+	}
+}
+
+func TestOIDCStateCookieUsedOnce(t *testing.T) {
+	provider := &fakeProvider{identities: map[string]Identity{
+		"friend-code": {Subject: "1", Username: "alice", Groups: []string{"friends"}},
+	}}
+	_, handler := newTestOIDCLogin(t, provider)
+
+	begin := serve(handler, http.MethodGet, OIDCLoginPath, nil)
+	authURL, _ := url.Parse(begin.Header.Get("Location"))
+	provider.nonce = authURL.Query().Get("nonce")
+	callbackPath := OIDCCallbackPath + "?state=" + url.QueryEscape(authURL.Query().Get("state")) + "&code=friend-code"
+
+	callback := serve(handler, http.MethodGet, callbackPath, begin.Cookies())
+	if callback.StatusCode != http.StatusFound {
+		t.Fatalf("expected sign in, got %d", callback.StatusCode)
+	}
+
+	stateCookie := findCookie(callback, OIDCStateCookieName)
+	if stateCookie == nil || stateCookie.MaxAge >= 0 {
+		t.Error("expected the state cookie to be cleared")
+	}
+}
+
+func TestOIDCGroupRole(t *testing.T) {
+	login := &oidcLogin{config: OIDCConfig{GroupRoles: map[string]string{
+		"admins":  string(RoleModerator),
+		"owners":  string(RoleHost),
+		"typos":   "moderater",
+		"viewers": string(RoleViewer),
+	}}}
+
+	tests := []struct {
+		name     string
+		groups   []string
+		expected Role
+	}{
+		{name: "no groups", expected: RoleViewer},
+		{name: "unmapped group", groups: []string{"friends"}, expected: RoleViewer},
+		{name: "mapped group", groups: []string{"friends", "admins"}, expected: RoleModerator},
+		{name: "highest role wins", groups: []string{"admins", "owners", "viewers"}, expected: RoleHost},
+		{name: "invalid role", groups: []string{"typos"}, expected: RoleViewer},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if role := login.groupRole(test.groups); role != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, role)
+			}
+		})
+	}
+}
+
+func TestOIDCRequiresSession(t *testing.T) {
+	_, handler := newTestOIDCLogin(t, &fakeProvider{})
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		status   int
+		location string
+	}{
+		{name: "page", method: http.MethodGet, target: "/room/lobby", status: http.StatusFound, location: OIDCLoginPath + "?next=%2Froom%2Flobby"},
+		{name: "api", method: http.MethodGet, target: "/api/videos", status: http.StatusUnauthorized},
+		{name: "password login", method: http.MethodPost, target: AccountAPIPath + "login", status: http.StatusUnauthorized},
+		{name: "registration", method: http.MethodPost, target: AccountAPIPath + "register", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serve(handler, test.method, test.target, nil)
+			if response.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, response.StatusCode)
+			}
+
+			if location := response.Header.Get("Location"); location != test.location {
+				t.Errorf("expected redirect to %q, got %q", test.location, location)
+			}
+		})
+	}
+}
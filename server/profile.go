@@ -0,0 +1,108 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"watch-party/database"
+)
+
+var monkeyColourPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+type Profile struct {
+	Name   string `json:"name"`
+	Colour string `json:"colour"`
+	Avatar string `json:"avatar"`
+}
+
+type SeatOccupant struct {
+	Seat
+	Profile Profile `json:"profile"`
+
+	Away bool `json:"away"`
+}
+
+func validAvatar(avatar string) bool {
+	for _, monkeyAvatar := range MonkeyAvatars {
+		if avatar == monkeyAvatar {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateProfile(profile Profile) (Profile, bool) {
+	profile.Name = sanitizeDisplayName(profile.Name)
+	if profile.Colour != "" && !monkeyColourPattern.MatchString(profile.Colour) {
+		return profile, false
+	}
+
+	if profile.Avatar != "" && !validAvatar(profile.Avatar) {
+		return profile, false
+	}
+
+	return profile, true
+}
+
+func (server *Server) seatOccupants() []SeatOccupant {
+	awayProfiles := map[string]Profile{}
+	for _, session := range server.sessions {
+		if session.DisconnectedAt != nil {
+			awayProfiles[session.Token] = session.Profile
+		}
+	}
+
+	var occupants []SeatOccupant
+	for token, seat := range server.stage.seatsUsed {
+		if client, exists := server.connectedClients[token]; exists {
+			occupants = append(occupants, SeatOccupant{
+				Seat:    seat,
+				Profile: client.Profile,
+			})
+			continue
+		}
+
+		occupants = append(occupants, SeatOccupant{
+			Seat:    seat,
+			Profile: awayProfiles[token],
+			Away:    true,
+		})
+	}
+
+	return occupants
+}
+
+func (server *Server) updateProfile(token string, profile Profile) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	profile, valid := validateProfile(profile)
+	if !valid {
+		server.sendError(token, "invalid-profile", "That monkey isn't available")
+		return
+	}
+
+	client.Profile = profile
+	if session, has := server.sessions[client.SessionToken]; has {
+		session.Profile = profile
+	}
+
+	if client.UserID != 0 {
+		err := database.SaveUserProfile(server.db, client.UserID, profile.Name, profile.Colour, profile.Avatar)
+		if err != nil {
+			log.WithError(err).
+				WithField("user", client.Username).
+				Error("Unable to save profile")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"token":  token,
+		"name":   profile.Name,
+		"avatar": profile.Avatar,
+	}).Info("Updated profile")
+
+	server.updateSeats()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"watch-party/database"
+)
+
+const MaxQueueLength = 100
+
+type QueueItem struct {
+	ID      uint
+	VideoID uint
+}
+
+type QueueItemData struct {
+	ID uint `json:"id"`
+	GalleryItemData
+}
+
+type QueueMessage struct {
+	Items []QueueItemData `json:"items"`
+}
+
+type QueueAddMessage struct {
+	VideoFile string `json:"video"`
+}
+
+type QueueMoveMessage struct {
+	ItemID   uint `json:"item_id"`
+	Position int  `json:"position"`
+}
+
+type QueueRemoveMessage struct {
+	ItemID uint `json:"item_id"`
+}
+
+type VideoEndedMessage struct {
+	VideoFile string  `json:"video"`
+	Progress  float64 `json:"progress"`
+}
+
+func (server *Server) queueMessage() QueueMessage {
+	items := make([]QueueItemData, 0, len(server.queue))
+	for _, item := range server.queue {
+		var video database.Video
+		if result := server.db.First(&video, item.VideoID); result.Error != nil {
+			log.WithError(result.Error).
+				WithField("video", item.VideoID).
+				Warn("Queued video is missing")
+			continue
+		}
+
+		items = append(items, QueueItemData{
+			ID:              item.ID,
+			GalleryItemData: videoGalleryItem(video),
+		})
+	}
+
+	return QueueMessage{
+		Items: items,
+	}
+}
+
+func (server *Server) updateQueue() {
+	server.broadcastExcept("", MessageQueue, server.queueMessage())
+}
+
+func (server *Server) sendQueue(client *Client) {
+	_ = client.Send(MessageQueue, server.queueMessage())
+}
+
+func (server *Server) queueItemIndex(itemID uint) int {
+	for i, item := range server.queue {
+		if item.ID == itemID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (server *Server) queueAdd(token string, videoFile string) {
+	if len(server.queue) >= MaxQueueLength {
+		server.sendError(token, "queue-full", "The queue is full")
+		return
+	}
+
+	var video database.Video
+	result := server.db.
+		Where(database.Video{VideoFilePath: videoFile}).
+		First(&video)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		server.sendError(token, "invalid-video", "No such video")
+		return
+	}
+
+	if result.Error != nil {
+		log.WithError(result.Error).Error("Unable to query videos")
+		return
+	}
+
+	server.nextQueueItemID += 1
+	server.queue = append(server.queue, QueueItem{
+		ID:      server.nextQueueItemID,
+		VideoID: video.ID,
+	})
+
+	log.WithFields(log.Fields{
+		"token": token,
+		"video": videoFile,
+	}).Info("Added video to queue")
+
+	server.updateQueue()
+}
+
+func (server *Server) queueMove(token string, itemID uint, position int) {
+	index := server.queueItemIndex(itemID)
+	if index < 0 {
+		server.sendError(token, "invalid-queue-item", "That's no longer in the queue")
+		return
+	}
+
+	if position < 0 {
+		position = 0
+	}
+	if position >= len(server.queue) {
+		position = len(server.queue) - 1
+	}
+
+	item := server.queue[index]
+	server.queue = append(server.queue[:index], server.queue[index+1:]...)
+	server.queue = append(server.queue[:position], append([]QueueItem{item}, server.queue[position:]...)...)
+	server.updateQueue()
+}
+
+func (server *Server) queueRemove(token string, itemID uint) {
+	index := server.queueItemIndex(itemID)
+	if index < 0 {
+		server.sendError(token, "invalid-queue-item", "That's no longer in the queue")
+		return
+	}
+
+	server.queue = append(server.queue[:index], server.queue[index+1:]...)
+	server.updateQueue()
+}
+
+func (server *Server) videoEnded(token string, videoFile string, progress float64) {
+	if videoFile == "" || videoFile != server.videoState.VideoFile || !server.videoState.Playing {
+		return
+	}
+
+	if !server.isResyncRequest(ServerMessage{Playing: true, Progress: progress}) {
+		log.WithFields(log.Fields{
+			"token":    token,
+			"progress": progress,
+		}).Warn("Ignoring out of sync video ended report")
+		return
+	}
+
+	var video database.Video
+	result := server.db.
+		Where(database.Video{VideoFilePath: videoFile}).
+		First(&video)
+	if result.Error == nil && video.Duration > 0 && server.videoState.Progress < video.Duration-ResyncProgressTolerance {
+		log.WithFields(log.Fields{
+			"token":    token,
+			"progress": server.videoState.Progress,
+			"duration": video.Duration,
+		}).Warn("Ignoring early video ended report")
+		return
+	}
+
+	server.videoState.Finished = true
+	server.saveWatchProgress()
+	server.advanceQueue()
+}
+
+func (server *Server) advanceQueue() {
+	for len(server.queue) > 0 {
+		item := server.queue[0]
+		server.queue = server.queue[1:]
+
+		var video database.Video
+		if result := server.db.First(&video, item.VideoID); result.Error != nil {
+			log.WithError(result.Error).
+				WithField("video", item.VideoID).
+				Warn("Skipping missing queued video")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"room":  server.roomID,
+			"video": video.VideoFilePath,
+		}).Info("Playing next video in queue")
+
+		noSender := ""
+		server.requestPlay(ServerMessage{
+			Type:     ServerMessageRequestPlay,
+			Token:    &noSender,
+			Playing:  true,
+			Progress: 0,
+			File:     &video.VideoFilePath,
+		})
+		break
+	}
+
+	server.updateQueue()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitConfig struct {
+	// Message types without a limit can be sent as often as clients like.
+	Limits map[MessageType]RateLimit
+
+	// Zero disables muting.
+	MuteAfter    int
+	StrikeWindow time.Duration
+	MuteDuration time.Duration
+}
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Limits: map[MessageType]RateLimit{
+			MessageChat:         {Rate: DefaultChatRate, Burst: DefaultChatBurst},
+			MessageMonkeyAction: {Rate: DefaultMonkeyActionRate, Burst: DefaultMonkeyActionBurst},
+		},
+		MuteAfter:    DefaultFloodMuteAfter,
+		StrikeWindow: DefaultFloodStrikeWindow,
+		MuteDuration: DefaultFloodMuteDuration,
+	}
+}
+
+type RateLimitedMessage struct {
+	Type MessageType `json:"type"`
+
+	// Seconds until another message of this type will be accepted.
+	RetryAfter float64 `json:"retry_after"`
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// Only used by its client's goroutine, so needs no locking.
+type rateLimiter struct {
+	config  RateLimitConfig
+	buckets map[MessageType]*tokenBucket
+
+	warned map[MessageType]bool
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:  config,
+		buckets: map[MessageType]*tokenBucket{},
+		warned:  map[MessageType]bool{},
+	}
+}
+
+func (limiter *rateLimiter) allow(messageType MessageType, now time.Time) (bool, time.Duration) {
+	limit, limited := limiter.config.Limits[messageType]
+	if !limited || limit.Rate <= 0 {
+		return true, 0
+	}
+
+	bucket, has := limiter.buckets[messageType]
+	if !has {
+		bucket = &tokenBucket{
+			tokens:     float64(limit.Burst),
+			lastRefill: now,
+		}
+		limiter.buckets[messageType] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.lastRefill).Seconds() * limit.Rate
+	if bucket.tokens > float64(limit.Burst) {
+		bucket.tokens = float64(limit.Burst)
+	}
+	bucket.lastRefill = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens -= 1
+		limiter.warned[messageType] = false
+		return true, 0
+	}
+
+	retryAfter := time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	return false, retryAfter
+}
+
+func (limiter *rateLimiter) shouldWarn(messageType MessageType) bool {
+	if limiter.warned[messageType] {
+		return false
+	}
+
+	limiter.warned[messageType] = true
+	return true
+}
+
+func (server *Server) strike(key string, now time.Time) bool {
+	limits := server.config.RateLimits
+	if limits.MuteAfter <= 0 {
+		return false
+	}
+
+	for otherKey, strikes := range server.strikes {
+		if now.Sub(strikes[len(strikes)-1]) >= limits.StrikeWindow {
+			delete(server.strikes, otherKey)
+		}
+	}
+
+	var recent []time.Time
+	for _, strike := range server.strikes[key] {
+		if now.Sub(strike) < limits.StrikeWindow {
+			recent = append(recent, strike)
+		}
+	}
+
+	recent = append(recent, now)
+	if len(recent) < limits.MuteAfter {
+		server.strikes[key] = recent
+		return false
+	}
+
+	delete(server.strikes, key)
+	return true
+}
+
+func (server *Server) rateLimited(token string, messageType MessageType, retryAfter time.Duration, warn bool) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	now := time.Now()
+	if server.strike(muteKey(client), now) {
+		warn = true
+		until := now.Add(server.config.RateLimits.MuteDuration)
+		if mute, muted := server.activeMute(client); !muted || !mute.outlasts(until) {
+			log.WithFields(log.Fields{
+				"token": token,
+				"room":  server.roomID,
+				"type":  messageType,
+			}).Warn("Muting viewer for flooding")
+
+			server.muteClient(client, "Sending messages too quickly", server.config.RateLimits.MuteDuration)
+		}
+	}
+
+	if !warn {
+		return
+	}
+
+	_ = client.Send(MessageRateLimited, RateLimitedMessage{
+		Type:       messageType,
+		RetryAfter: retryAfter.Seconds(),
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	config := RateLimitConfig{Limits: map[MessageType]RateLimit{
+		MessageChat: {Rate: 1, Burst: 3},
+	}}
+
+	type attempt struct {
+		messageType MessageType
+		after       time.Duration
+		allowed     bool
+		retryAfter  time.Duration
+	}
+
+	tests := []struct {
+		name     string
+		attempts []attempt
+	}{
+		{
+			name: "burst",
+			attempts: []attempt{
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, false, time.Second},
+			},
+		},
+		{
+			name: "refills over time",
+			attempts: []attempt{
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, true, 0},
+				{MessageChat, 500 * time.Millisecond, false, 500 * time.Millisecond},
+				{MessageChat, 500 * time.Millisecond, true, 0},
+				{MessageChat, 0, false, time.Second},
+			},
+		},
+		{
+			name: "refills up to the burst",
+			attempts: []attempt{
+				{MessageChat, 0, true, 0},
+				{MessageChat, time.Hour, true, 0},
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, true, 0},
+				{MessageChat, 0, false, time.Second},
+			},
+		},
+		{
+			name: "unlimited type",
+			attempts: []attempt{
+				{MessageReady, 0, true, 0},
+				{MessageReady, 0, true, 0},
+				{MessageReady, 0, true, 0},
+				{MessageReady, 0, true, 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := newRateLimiter(config)
+			now := start
+			for i, attempt := range test.attempts {
+				now = now.Add(attempt.after)
+				allowed, retryAfter := limiter.allow(attempt.messageType, now)
+				if allowed != attempt.allowed || retryAfter != attempt.retryAfter {
+					t.Errorf("attempt %d: expected %t, %s, got %t, %s",
+						i, attempt.allowed, attempt.retryAfter, allowed, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterShouldWarn(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newRateLimiter(RateLimitConfig{Limits: map[MessageType]RateLimit{
+		MessageChat: {Rate: 1, Burst: 1},
+	}})
+
+	limiter.allow(MessageChat, now)
+	if _, _ = limiter.allow(MessageChat, now); !limiter.shouldWarn(MessageChat) {
+		t.Error("expected a warning for the first dropped message")
+	}
+
+	if limiter.shouldWarn(MessageChat) {
+		t.Error("expected no warning for the next dropped message")
+	}
+
+	if !limiter.shouldWarn(MessageMonkeyAction) {
+		t.Error("expected a warning for another type of message")
+	}
+
+	if allowed, _ := limiter.allow(MessageChat, now.Add(time.Second)); !allowed {
+		t.Fatal("expected the bucket to have refilled")
+	}
+
+	if !limiter.shouldWarn(MessageChat) {
+		t.Error("expected a warning once a message has got through")
+	}
+}
+
+func TestServerStrike(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		muteAfter int
+		strikes   []time.Duration
+		key       string
+		muted     []bool
+	}{
+		{
+			name:      "mutes after enough strikes",
+			muteAfter: 3,
+			strikes:   []time.Duration{0, time.Second, 2 * time.Second},
+			muted:     []bool{false, false, true},
+		},
+		{
+			name:      "starts again after a mute",
+			muteAfter: 2,
+			strikes:   []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second},
+			muted:     []bool{false, true, false, true},
+		},
+		{
+			name:      "strikes run out",
+			muteAfter: 3,
+			strikes:   []time.Duration{0, 30 * time.Second, 65 * time.Second, 70 * time.Second},
+			muted:     []bool{false, false, false, true},
+		},
+		{
+			name:      "muting disabled",
+			muteAfter: 0,
+			strikes:   []time.Duration{0, 0, 0},
+			muted:     []bool{false, false, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := Server{
+				config: RoomConfig{RateLimits: RateLimitConfig{
+					MuteAfter:    test.muteAfter,
+					StrikeWindow: time.Minute,
+				}},
+				strikes: map[string][]time.Time{},
+			}
+
+			for i, after := range test.strikes {
+				if muted := server.strike("token:flooder", start.Add(after)); muted != test.muted[i] {
+					t.Errorf("strike %d: expected muted to be %t", i, test.muted[i])
+				}
+			}
+		})
+	}
+}
+
+func TestServerStrikeKeys(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	server := Server{
+		config: RoomConfig{RateLimits: RateLimitConfig{
+			MuteAfter:    2,
+			StrikeWindow: time.Minute,
+		}},
+		strikes: map[string][]time.Time{},
+	}
+
+	if server.strike("token:first", now) || server.strike("token:second", now) {
+		t.Fatal("expected no mute after one strike each")
+	}
+
+	if !server.strike("token:first", now) {
+		t.Error("expected strikes to be counted per key")
+	}
+
+	server.strike("token:third", now.Add(2*time.Minute))
+	if _, has := server.strikes["token:second"]; has {
+		t.Error("expected strikes which ran out to be forgotten")
+	}
+}
+
+func TestMuteOutlasts(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		mute     Mute
+		until    time.Time
+		outlasts bool
+	}{
+		{name: "permanent", mute: Mute{}, until: now.Add(time.Hour), outlasts: true},
+		{name: "ends later", mute: Mute{Until: now.Add(2 * time.Hour)}, until: now.Add(time.Hour), outlasts: true},
+		{name: "ends sooner", mute: Mute{Until: now.Add(time.Minute)}, until: now.Add(time.Hour)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if outlasts := test.mute.outlasts(test.until); outlasts != test.outlasts {
+				t.Errorf("expected %t, got %t", test.outlasts, outlasts)
+			}
+		})
+	}
+}
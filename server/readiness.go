@@ -0,0 +1,111 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+type ReadyBarrier struct {
+	Open      bool
+	StartedAt time.Time
+}
+
+type CatchingUpMessage struct {
+	Seats []Seat `json:"seats"`
+}
+
+func (server *Server) startReadyBarrier() {
+	server.readyBarrier = ReadyBarrier{
+		Open:      true,
+		StartedAt: time.Now(),
+	}
+
+	for _, client := range server.connectedClients {
+		client.Ready = false
+		client.CatchingUp = false
+	}
+}
+
+func (server *Server) releaseReadyBarrier() {
+	if !server.readyBarrier.Open {
+		return
+	}
+
+	server.readyBarrier.Open = false
+	server.videoState.LastProgressUpdate = time.Now()
+}
+
+func (server *Server) updateCatchingUp() {
+	var seats []Seat
+	for token, client := range server.connectedClients {
+		if !client.CatchingUp {
+			continue
+		}
+
+		if seat := server.stage.SeatForPlayer(token); seat != nil {
+			seats = append(seats, *seat)
+		}
+	}
+
+	server.broadcastExcept("", MessageCatchingUp, CatchingUpMessage{
+		Seats: seats,
+	})
+}
+
+func (server *Server) skipStragglers() {
+	server.releaseReadyBarrier()
+
+	var skipped []string
+	for token, client := range server.connectedClients {
+		if client.Ready {
+			_ = client.Send(MessageReady, nil)
+			continue
+		}
+
+		client.CatchingUp = true
+		skipped = append(skipped, token)
+	}
+
+	log.WithFields(log.Fields{
+		"room":    server.roomID,
+		"skipped": skipped,
+		"timeout": server.config.ReadyTimeout,
+	}).Warn("Ready timeout, playing without stragglers")
+
+	for _, token := range skipped {
+		server.resyncClient(server.connectedClients[token])
+	}
+
+	server.updateCatchingUp()
+}
+
+func (server *Server) resyncClient(client *Client) {
+	server.updateVideoState()
+	client.Ready = false
+	_ = client.Send(MessageRequestPlay, RequestPlayMessage{
+		Playing:   server.videoState.Playing,
+		Progress:  server.videoState.Progress,
+		VideoFile: &server.videoState.VideoFile,
+	})
+}
+
+func (server *Server) caughtUp(client *Client) {
+	log.WithField("token", *client.Token).Info("Caught up with the room")
+
+	client.Ready = true
+	client.CatchingUp = false
+	_ = client.Send(MessageReady, nil)
+	server.updateCatchingUp()
+}
+
+func (server *Server) readyTimeoutTick() {
+	if !server.readyBarrier.Open || server.config.ReadyTimeout <= 0 {
+		return
+	}
+
+	if time.Since(server.readyBarrier.StartedAt) < server.config.ReadyTimeout {
+		return
+	}
+
+	server.skipStragglers()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadyBarrier(t *testing.T) {
+	tests := []struct {
+		name       string
+		ready      []int
+		timeout    bool
+		released   bool
+		stragglers []int
+	}{
+		{name: "everyone ready", ready: []int{0, 1, 2}, released: true},
+		{name: "still buffering", ready: []int{0, 2}},
+		{name: "timed out", ready: []int{0, 2}, timeout: true, released: true, stragglers: []int{1}},
+		{name: "nobody ready in time", timeout: true, released: true, stragglers: []int{0, 1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{ReadyTimeout: time.Minute})
+			var viewers []*testViewer
+			for _, name := range []string{"Alice", "Bob", "Carol"} {
+				viewers = append(viewers, joinTestViewer(t, server, name))
+			}
+
+			server.videoState = VideoPlaybackState{
+				Playing:            true,
+				Progress:           100,
+				VideoFile:          "movie.mp4",
+				LastProgressUpdate: time.Now(),
+			}
+			server.startReadyBarrier()
+			for _, viewer := range viewers {
+				viewer.messages(t)
+			}
+
+			for _, i := range test.ready {
+				server.ready(*viewers[i].client.Token)
+			}
+
+			if test.timeout {
+				server.readyBarrier.StartedAt = time.Now().Add(-2 * time.Minute)
+				server.readyTimeoutTick()
+			}
+
+			if server.readyBarrier.Open == test.released {
+				t.Fatalf("expected released to be %t", test.released)
+			}
+
+			for i, viewer := range viewers {
+				straggler := false
+				for _, j := range test.stragglers {
+					straggler = straggler || i == j
+				}
+
+				messages := viewer.messages(t)
+				if toldReady := lastMessage(messages, MessageReady, nil); toldReady != (test.released && !straggler) {
+					t.Errorf("viewer %d: expected told ready to be %t", i, test.released && !straggler)
+				}
+
+				if viewer.client.CatchingUp != straggler || lastMessage(messages, MessageRequestPlay, nil) != straggler {
+					t.Errorf("viewer %d: expected catching up to be %t", i, straggler)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyBarrierFreezesProgress(t *testing.T) {
+	server := newTestServer(t, RoomConfig{})
+	server.videoState = VideoPlaybackState{
+		Playing:            true,
+		Progress:           100,
+		LastProgressUpdate: time.Now().Add(-time.Minute),
+	}
+
+	server.startReadyBarrier()
+	server.updateVideoState()
+	if server.videoState.Progress != 100 {
+		t.Errorf("expected progress to wait for everyone, got %f", server.videoState.Progress)
+	}
+
+	server.releaseReadyBarrier()
+	server.videoState.LastProgressUpdate = server.videoState.LastProgressUpdate.Add(-2 * time.Second)
+	server.updateVideoState()
+	if server.videoState.Progress < 102 || server.videoState.Progress > 103 {
+		t.Errorf("expected progress to carry on from where it was held, got %f", server.videoState.Progress)
+	}
+}
+
+func TestCaughtUp(t *testing.T) {
+	server := newTestServer(t, RoomConfig{ReadyTimeout: time.Minute})
+	waiting := joinTestViewer(t, server, "Alice")
+	straggler := joinTestViewer(t, server, "Bob")
+
+	server.ready(*waiting.client.Token)
+	server.skipStragglers()
+	waiting.messages(t)
+	straggler.messages(t)
+
+	server.ready(*straggler.client.Token)
+	if straggler.client.CatchingUp || !straggler.client.Ready {
+		t.Error("expected the straggler to have caught up")
+	}
+
+	if !lastMessage(straggler.messages(t), MessageReady, nil) {
+		t.Error("expected the straggler to be told to play")
+	}
+
+	var catchingUp CatchingUpMessage
+	waitingMessages := waiting.messages(t)
+	if !lastMessage(waitingMessages, MessageCatchingUp, &catchingUp) || len(catchingUp.Seats) != 0 {
+		t.Errorf("expected nobody to be catching up, got %+v", catchingUp)
+	}
+
+	if lastMessage(waitingMessages, MessageReady, nil) {
+		t.Error("expected everyone else to carry on")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"math"
+	"watch-party/database"
+)
+
+type Role string
+
+const (
+	RoleViewer    = Role("viewer")
+	RoleModerator = Role("moderator")
+	RoleHost      = Role("host")
+)
+
+// How far, in seconds, play requests from viewers can be from the room, so
+// they can resync after buffering.
+const ResyncProgressTolerance = 5.0
+
+func (role Role) rank() int {
+	switch role {
+	case RoleHost:
+		return 2
+	case RoleModerator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (role Role) AtLeast(other Role) bool {
+	return role.rank() >= other.rank()
+}
+
+func validRole(role Role) bool {
+	return role == RoleViewer || role == RoleModerator || role == RoleHost
+}
+
+// Anything not listed can be sent by everyone.
+var requiredRoles = map[ServerMessageType]Role{
+	ServerMessageRequestPlay:     RoleModerator,
+	ServerMessageRequestImage:    RoleModerator,
+	ServerMessageRequestSubtitle: RoleModerator,
+	ServerMessageGrantRole:       RoleHost,
+	ServerMessageKickViewer:      RoleModerator,
+	ServerMessageBan:             RoleModerator,
+	ServerMessageMute:            RoleModerator,
+	ServerMessageUnmute:          RoleModerator,
+}
+
+type ErrorMessage struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type SeatRole struct {
+	Seat
+	Role Role `json:"role"`
+}
+
+type RolesMessage struct {
+	YourRole Role       `json:"your_role"`
+	Roles    []SeatRole `json:"roles"`
+}
+
+type GrantRoleMessage struct {
+	Seat
+	Role Role `json:"role"`
+}
+
+func (server *Server) sendError(token string, errorName string, message string) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	_ = client.Send(MessageError, ErrorMessage{
+		Error:   errorName,
+		Message: message,
+	})
+}
+
+func (server *Server) isResyncRequest(message ServerMessage) bool {
+	if message.File != nil && *message.File != server.videoState.VideoFile {
+		return false
+	}
+
+	if message.Playing != server.videoState.Playing {
+		return false
+	}
+
+	server.updateVideoState()
+	return math.Abs(message.Progress-server.videoState.Progress) <= ResyncProgressTolerance
+}
+
+func (server *Server) permitted(message ServerMessage) bool {
+	requiredRole, isControl := requiredRoles[message.Type]
+	if !isControl || message.Token == nil {
+		return true
+	}
+
+	client, exists := server.connectedClients[*message.Token]
+	if !exists {
+		return false
+	}
+
+	if client.Role.AtLeast(requiredRole) {
+		return true
+	}
+
+	// Only the viewer asking needs to resync, everyone else carries on.
+	if message.Type == ServerMessageRequestPlay && server.isResyncRequest(message) {
+		if !server.readyBarrier.Open {
+			client.CatchingUp = true
+		}
+
+		server.resyncClient(client)
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"token":    *message.Token,
+		"role":     client.Role,
+		"required": requiredRole,
+		"type":     message.Type,
+	}).Info("Denied control request")
+
+	server.sendError(*message.Token, "permission-denied",
+		"You need to be the "+string(requiredRole)+" to do that")
+	return false
+}
+
+func (server *Server) currentHost() *Client {
+	for _, client := range server.connectedClients {
+		if client.Role == RoleHost {
+			return client
+		}
+	}
+
+	return nil
+}
+
+func (server *Server) assignJoinRole(client *Client) {
+	client.Role = RoleViewer
+	if validRole(client.GroupRole) && client.GroupRole.AtLeast(RoleModerator) {
+		client.Role = RoleModerator
+	}
+
+	if client.UserID != 0 {
+		savedRole, err := database.UserRole(server.db, client.UserID, server.roomID)
+		if err != nil {
+			log.WithError(err).
+				WithField("user", client.Username).
+				Error("Unable to load role")
+		}
+
+		if role := Role(savedRole); validRole(role) && role.AtLeast(RoleModerator) {
+			client.Role = RoleModerator
+		}
+	}
+
+	if server.currentHost() == nil {
+		client.Role = RoleHost
+	}
+}
+
+func (server *Server) saveRole(client *Client) {
+	if client.UserID == 0 {
+		return
+	}
+
+	if err := database.SaveUserRole(server.db, client.UserID, server.roomID, string(client.Role)); err != nil {
+		log.WithError(err).
+			WithField("user", client.Username).
+			Error("Unable to save role")
+	}
+}
+
+func (server *Server) handOffHost() {
+	if server.currentHost() != nil {
+		return
+	}
+
+	var newHost *Client
+	for _, client := range server.connectedClients {
+		if newHost == nil {
+			newHost = client
+			continue
+		}
+
+		if client.Role.rank() != newHost.Role.rank() {
+			if client.Role.AtLeast(newHost.Role) {
+				newHost = client
+			}
+			continue
+		}
+
+		if client.JoinedAt.Before(newHost.JoinedAt) {
+			newHost = client
+		}
+	}
+
+	if newHost == nil {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"token": *newHost.Token,
+		"room":  server.roomID,
+	}).Info("Handed off host")
+
+	server.setRole(newHost, RoleHost)
+}
+
+func (server *Server) setRole(client *Client, role Role) {
+	client.Role = role
+	if session, has := server.sessions[client.SessionToken]; has {
+		session.Role = role
+	}
+}
+
+func (server *Server) updateRoles() {
+	var roles []SeatRole
+	for token, client := range server.connectedClients {
+		seat := server.stage.SeatForPlayer(token)
+		if seat == nil || client.Role == RoleViewer {
+			continue
+		}
+
+		roles = append(roles, SeatRole{
+			Seat: *seat,
+			Role: client.Role,
+		})
+	}
+
+	for _, client := range server.connectedClients {
+		_ = client.Send(MessageRoles, RolesMessage{
+			YourRole: client.Role,
+			Roles:    roles,
+		})
+	}
+}
+
+func (server *Server) grantRole(token string, seat Seat, role Role) {
+	if !validRole(role) {
+		server.sendError(token, "invalid-role", "Unknown role")
+		return
+	}
+
+	targetToken := server.stage.PlayerInSeat(seat)
+	if targetToken == nil {
+		server.sendError(token, "invalid-seat", "Nobody is sitting there")
+		return
+	}
+
+	target, exists := server.connectedClients[*targetToken]
+	if !exists || *targetToken == token {
+		server.sendError(token, "invalid-seat", "You can't change that viewer's role")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"token":  token,
+		"target": *targetToken,
+		"role":   role,
+	}).Info("Changed role")
+
+	// There's only ever one host, so granting it hands ours over.
+	if role == RoleHost {
+		granter := server.connectedClients[token]
+		server.setRole(granter, RoleModerator)
+		server.saveRole(granter)
+	}
+
+	server.setRole(target, role)
+	server.saveRole(target)
+	server.updateRoles()
+}
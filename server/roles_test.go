@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role    Role
+		other   Role
+		atLeast bool
+	}{
+		{role: RoleHost, other: RoleModerator, atLeast: true},
+		{role: RoleModerator, other: RoleModerator, atLeast: true},
+		{role: RoleViewer, other: RoleModerator},
+		{role: Role("admin"), other: RoleViewer, atLeast: true},
+		{role: Role("admin"), other: RoleModerator},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.role)+" "+string(test.other), func(t *testing.T) {
+			if atLeast := test.role.AtLeast(test.other); atLeast != test.atLeast {
+				t.Errorf("expected %t, got %t", test.atLeast, atLeast)
+			}
+		})
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	videoFile := "movie.mp4"
+	otherFile := "other.mp4"
+
+	tests := []struct {
+		name        string
+		role        Role
+		messageType ServerMessageType
+		playing     bool
+		progress    float64
+		file        *string
+		permitted   bool
+		denied      bool
+		resynced    bool
+	}{
+		{name: "host seeking", role: RoleHost, messageType: ServerMessageRequestPlay, playing: true, progress: 500, permitted: true},
+		{name: "moderator pausing", role: RoleModerator, messageType: ServerMessageRequestPlay, progress: 100, permitted: true},
+		{name: "viewer seeking", role: RoleViewer, messageType: ServerMessageRequestPlay, playing: true, progress: 500, denied: true},
+		{name: "viewer pausing", role: RoleViewer, messageType: ServerMessageRequestPlay, progress: 100, denied: true},
+		{name: "viewer changing video", role: RoleViewer, messageType: ServerMessageRequestPlay, playing: true, progress: 100, file: &otherFile, denied: true},
+		{name: "viewer resyncing", role: RoleViewer, messageType: ServerMessageRequestPlay, playing: true, progress: 102, resynced: true},
+		{name: "viewer resyncing with the video", role: RoleViewer, messageType: ServerMessageRequestPlay, playing: true, progress: 98, file: &videoFile, resynced: true},
+		{name: "viewer granting roles", role: RoleViewer, messageType: ServerMessageGrantRole, denied: true},
+		{name: "moderator granting roles", role: RoleModerator, messageType: ServerMessageGrantRole, denied: true},
+		{name: "viewer chatting", role: RoleViewer, messageType: ServerMessageChat, permitted: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{})
+			host := joinTestViewer(t, server, "Host")
+			sender := joinTestViewer(t, server, "Sender")
+			server.setRole(sender.client, test.role)
+			if test.role == RoleHost {
+				server.setRole(host.client, RoleModerator)
+			}
+
+			server.videoState = VideoPlaybackState{
+				Playing:            true,
+				Progress:           100,
+				VideoFile:          videoFile,
+				LastProgressUpdate: time.Now(),
+			}
+			server.releaseReadyBarrier()
+			host.messages(t)
+			sender.messages(t)
+
+			permitted := server.permitted(ServerMessage{
+				Type:     test.messageType,
+				Token:    sender.client.Token,
+				Playing:  test.playing,
+				Progress: test.progress,
+				File:     test.file,
+			})
+			if permitted != test.permitted {
+				t.Errorf("expected permitted to be %t", test.permitted)
+			}
+
+			senderMessages := sender.messages(t)
+			var errorMessage ErrorMessage
+			lastMessage(senderMessages, MessageError, &errorMessage)
+			if denied := errorMessage.Error == "permission-denied"; denied != test.denied {
+				t.Errorf("expected denied to be %t, got %+v", test.denied, errorMessage)
+			}
+
+			var requestPlay RequestPlayMessage
+			if resynced := lastMessage(senderMessages, MessageRequestPlay, &requestPlay); resynced != test.resynced {
+				t.Fatalf("expected resynced to be %t", test.resynced)
+			}
+
+			if !test.resynced {
+				return
+			}
+
+			if !requestPlay.Playing || requestPlay.Progress < 100 || requestPlay.Progress > 101 {
+				t.Errorf("expected to be sent where the room is, got %+v", requestPlay)
+			}
+
+			if lastMessage(host.messages(t), MessageRequestPlay, nil) {
+				t.Error("expected nobody else to be resynced")
+			}
+
+			if server.readyBarrier.Open || !sender.client.CatchingUp {
+				t.Error("expected the room to carry on while the viewer catches up")
+			}
+		})
+	}
+}
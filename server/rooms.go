@@ -0,0 +1,169 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+var roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type Room struct {
+	ID       string
+	Messages chan ServerMessage
+
+	// Closed on teardown, so sending doesn't need the registry locked.
+	stopped chan struct{}
+
+	clientCount   int
+	generation    int
+	teardownTimer *time.Timer
+}
+
+type RoomConfig struct {
+	EmptyRoomGracePeriod time.Duration
+
+	SeatGracePeriod time.Duration
+
+	SyncInterval time.Duration
+
+	DriftThreshold time.Duration
+
+	ReadyTimeout time.Duration
+
+	ChatHistoryLength int
+
+	Layouts LayoutConfig
+
+	WaitingList bool
+
+	RateLimits RateLimitConfig
+}
+
+type RoomRegistry struct {
+	rooms map[string]*Room
+	mutex sync.Mutex
+
+	db     *gorm.DB
+	config RoomConfig
+}
+
+func NewRoomRegistry(db *gorm.DB, config RoomConfig) *RoomRegistry {
+	return &RoomRegistry{
+		rooms:  map[string]*Room{},
+		db:     db,
+		config: config,
+	}
+}
+
+func ValidRoomID(roomID string) bool {
+	return roomIDPattern.MatchString(roomID)
+}
+
+// Every call must be paired with a call to Leave.
+func (registry *RoomRegistry) Join(roomID string) *Room {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	room, exists := registry.rooms[roomID]
+	if !exists {
+		room = &Room{
+			ID:       roomID,
+			Messages: make(chan ServerMessage),
+			stopped:  make(chan struct{}),
+		}
+
+		registry.rooms[roomID] = room
+		go StartServer(roomID, registry.db, registry.config, room.Messages, room.stopped)
+		log.WithField("room", roomID).Info("Created room")
+	}
+
+	if room.teardownTimer != nil {
+		room.teardownTimer.Stop()
+		room.teardownTimer = nil
+	}
+
+	room.generation += 1
+	room.clientCount += 1
+	return room
+}
+
+func (registry *RoomRegistry) Leave(room *Room) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	room.clientCount -= 1
+	if room.clientCount > 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"room":         room.ID,
+		"grace-period": registry.config.EmptyRoomGracePeriod,
+	}).Info("Room is empty, scheduling teardown")
+
+	generation := room.generation
+	room.teardownTimer = time.AfterFunc(registry.config.EmptyRoomGracePeriod, func() {
+		registry.teardown(room, generation)
+	})
+}
+
+func (registry *RoomRegistry) teardown(room *Room, generation int) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	// Someone joined (and maybe left again) since this teardown was scheduled.
+	if room.generation != generation || room.clientCount > 0 {
+		return
+	}
+
+	delete(registry.rooms, room.ID)
+	close(room.stopped)
+	log.WithField("room", room.ID).Info("Tore down empty room")
+}
+
+func (room *Room) send(message ServerMessage) bool {
+	select {
+	case room.Messages <- message:
+		return true
+	case <-room.stopped:
+		return false
+	}
+}
+
+func (registry *RoomRegistry) Broadcast(message ServerMessage) {
+	registry.mutex.Lock()
+	rooms := make([]*Room, 0, len(registry.rooms))
+	for _, room := range registry.rooms {
+		rooms = append(rooms, room)
+	}
+	registry.mutex.Unlock()
+
+	for _, room := range rooms {
+		room.send(message)
+	}
+}
+
+func (registry *RoomRegistry) RoomIDs() []string {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	roomIDs := make([]string, 0, len(registry.rooms))
+	for roomID := range registry.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	sort.Strings(roomIDs)
+	return roomIDs
+}
+
+func (registry *RoomRegistry) Send(roomID string, message ServerMessage) bool {
+	registry.mutex.Lock()
+	room, exists := registry.rooms[roomID]
+	registry.mutex.Unlock()
+
+	return exists && room.send(message)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"watch-party/database"
+)
+
+func newTestRegistry(t *testing.T, gracePeriod time.Duration) *RoomRegistry {
+	db, err := database.Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRoomRegistry(db, RoomConfig{
+		EmptyRoomGracePeriod: gracePeriod,
+		Layouts:              defaultLayoutConfig(),
+	})
+}
+
+func roomStatusOf(registry *RoomRegistry, roomID string) (RoomStatus, bool) {
+	reply := make(chan RoomStatus, 1)
+	if !registry.Send(roomID, ServerMessage{Type: ServerMessageRoomStatus, StatusReply: reply}) {
+		return RoomStatus{}, false
+	}
+
+	return <-reply, true
+}
+
+func TestValidRoomID(t *testing.T) {
+	tests := []struct {
+		roomID string
+		valid  bool
+	}{
+		{roomID: "default", valid: true},
+		{roomID: "movie_night-2", valid: true},
+		{roomID: ""},
+		{roomID: "movie night"},
+		{roomID: "../secrets"},
+		{roomID: strings.Repeat("a", 65)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.roomID, func(t *testing.T) {
+			if valid := ValidRoomID(test.roomID); valid != test.valid {
+				t.Errorf("expected %t, got %t", test.valid, valid)
+			}
+		})
+	}
+}
+
+func TestRoomRegistryJoin(t *testing.T) {
+	registry := newTestRegistry(t, time.Minute)
+	lobby := registry.Join("lobby")
+	cinema := registry.Join("cinema")
+
+	if again := registry.Join("lobby"); again != lobby {
+		t.Error("expected joining again to give the same room")
+	}
+
+	if lobby == cinema {
+		t.Fatal("expected each room to be separate")
+	}
+
+	for _, roomID := range []string{"lobby", "cinema"} {
+		status, sent := roomStatusOf(registry, roomID)
+		if !sent || status.ID != roomID {
+			t.Errorf("expected the server for %s to answer, got %+v", roomID, status)
+		}
+	}
+
+	if _, sent := roomStatusOf(registry, "missing"); sent {
+		t.Error("expected sending to a missing room to fail")
+	}
+
+	if roomIDs := registry.RoomIDs(); len(roomIDs) != 2 || roomIDs[0] != "cinema" || roomIDs[1] != "lobby" {
+		t.Errorf("expected both rooms in order, got %v", roomIDs)
+	}
+}
+
+func TestRoomRegistryTeardown(t *testing.T) {
+	const gracePeriod = 20 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		leaves   int
+		rejoin   bool
+		tornDown bool
+	}{
+		{name: "everyone left", leaves: 2, tornDown: true},
+		{name: "someone still there", leaves: 1},
+		{name: "rejoined in time", leaves: 2, rejoin: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := newTestRegistry(t, gracePeriod)
+			room := registry.Join("lobby")
+			registry.Join("lobby")
+
+			for i := 0; i < test.leaves; i++ {
+				registry.Leave(room)
+			}
+
+			if test.rejoin {
+				registry.Join("lobby")
+			}
+
+			time.Sleep(5 * gracePeriod)
+
+			_, sent := roomStatusOf(registry, "lobby")
+			if sent == test.tornDown {
+				t.Errorf("expected torn down to be %t", test.tornDown)
+			}
+
+			if test.tornDown && room.send(ServerMessage{Type: ServerMessageRoomStatus}) {
+				t.Error("expected sending to a torn down room to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Both seats are kept so the swap is dropped if either viewer moves.
+type SeatSwap struct {
+	From        Seat
+	Target      Seat
+	TargetToken string
+}
+
+type ChangeSeatMessage struct {
+	Seat
+}
+
+type SeatSwapRequestMessage struct {
+	From Seat   `json:"from"`
+	Name string `json:"name"`
+}
+
+type SeatSwapReplyMessage struct {
+	Seat
+	Accept bool `json:"accept"`
+}
+
+func (server *Server) canSit(client *Client, seat Seat) bool {
+	layout := server.stage.layout
+	if layout.IsVIPSeat(seat) || layout.IsReservedRow(seat.Row) {
+		return client.Role.AtLeast(RoleModerator)
+	}
+
+	return true
+}
+
+func (server *Server) seatsChanged() {
+	server.promoteWaitingViewers()
+	server.updateSeats()
+	server.updateRoles()
+	server.updateCatchingUp()
+}
+
+func (server *Server) changeSeat(token string, seat Seat) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	if !server.stage.ValidSeat(seat) {
+		server.sendError(token, "invalid-seat", "There's no seat there")
+		return
+	}
+
+	if !server.canSit(client, seat) {
+		server.sendError(token, "reserved-seat", "That seat is kept for moderators")
+		return
+	}
+
+	from := server.stage.SeatForPlayer(token)
+	occupantToken := server.stage.PlayerInSeat(seat)
+	if occupantToken == nil {
+		if from == nil && !server.firstInLine(token) {
+			server.sendError(token, "waiting-list", "Someone has been waiting longer for a seat")
+			return
+		}
+
+		server.stage.MoveViewer(token, seat)
+		server.cancelSeatSwaps(token)
+		server.leaveWaitingList(token)
+		server.seatsChanged()
+		return
+	}
+
+	if *occupantToken == token {
+		return
+	}
+
+	if from == nil {
+		server.sendError(token, "seat-taken", "Someone is already sitting there")
+		return
+	}
+
+	occupant, isConnected := server.connectedClients[*occupantToken]
+	if !isConnected {
+		server.sendError(token, "seat-taken", "That seat is being saved for someone")
+		return
+	}
+
+	if !server.canSit(occupant, *from) {
+		server.sendError(token, "reserved-seat", "They can't sit in that seat")
+		return
+	}
+
+	server.seatSwaps[token] = SeatSwap{
+		From:        *from,
+		Target:      seat,
+		TargetToken: *occupantToken,
+	}
+
+	log.WithFields(log.Fields{
+		"token":  token,
+		"target": *occupantToken,
+	}).Info("Asked to swap seats")
+
+	_ = occupant.Send(MessageSeatSwapRequest, SeatSwapRequestMessage{
+		From: *from,
+		Name: client.Profile.Name,
+	})
+}
+
+func (server *Server) seatSwapReply(token string, from Seat, accept bool) {
+	requesterToken := server.stage.PlayerInSeat(from)
+	if requesterToken == nil {
+		server.sendError(token, "invalid-seat-swap", "They've moved on")
+		return
+	}
+
+	swap, has := server.seatSwaps[*requesterToken]
+	requester, isConnected := server.connectedClients[*requesterToken]
+	if !has || !isConnected || swap.TargetToken != token {
+		server.sendError(token, "invalid-seat-swap", "They've moved on")
+		return
+	}
+
+	target := server.stage.SeatForPlayer(token)
+	if target == nil || *target != swap.Target || from != swap.From {
+		delete(server.seatSwaps, *requesterToken)
+		server.sendError(token, "invalid-seat-swap", "Someone has moved since they asked")
+		return
+	}
+
+	// Roles may have changed since they asked.
+	if accept && (!server.canSit(requester, swap.Target) || !server.canSit(server.connectedClients[token], from)) {
+		delete(server.seatSwaps, *requesterToken)
+		server.sendError(token, "reserved-seat", "One of you can't sit in that seat")
+		return
+	}
+
+	delete(server.seatSwaps, *requesterToken)
+	_ = requester.Send(MessageSeatSwapReply, SeatSwapReplyMessage{
+		Seat:   swap.Target,
+		Accept: accept,
+	})
+
+	if !accept {
+		return
+	}
+
+	server.stage.SwapViewers(*requesterToken, token)
+	server.cancelSeatSwaps(*requesterToken)
+	server.cancelSeatSwaps(token)
+	server.seatsChanged()
+}
+
+func (server *Server) cancelSeatSwaps(token string) {
+	delete(server.seatSwaps, token)
+	for requesterToken, swap := range server.seatSwaps {
+		if swap.TargetToken == token {
+			delete(server.seatSwaps, requesterToken)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestChangeSeat(t *testing.T) {
+	layout := Layout{
+		Rows:            []int{3, 3, 3},
+		ReservedRows:    []int{0},
+		VIPSeats:        []Seat{{Row: 2, Column: 0}},
+		AccessibleSeats: []Seat{{Row: 2, Column: 2}},
+	}
+
+	tests := []struct {
+		name      string
+		role      Role
+		seat      Seat
+		moved     bool
+		errorName string
+	}{
+		{name: "standard seat", role: RoleViewer, seat: Seat{Row: 2, Column: 1}, moved: true},
+		{name: "accessible seat", role: RoleViewer, seat: Seat{Row: 2, Column: 2}, moved: true},
+		{name: "viewer in reserved row", role: RoleViewer, seat: Seat{Row: 0, Column: 0}, errorName: "reserved-seat"},
+		{name: "viewer in VIP seat", role: RoleViewer, seat: Seat{Row: 2, Column: 0}, errorName: "reserved-seat"},
+		{name: "moderator in reserved row", role: RoleModerator, seat: Seat{Row: 0, Column: 0}, moved: true},
+		{name: "moderator in VIP seat", role: RoleModerator, seat: Seat{Row: 2, Column: 0}, moved: true},
+		{name: "missing seat", role: RoleModerator, seat: Seat{Row: 1, Column: 3}, errorName: "invalid-seat"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{Layouts: LayoutConfig{
+				Layouts:       map[string]Layout{"test": layout},
+				DefaultLayout: "test",
+				RoomLayouts:   map[string]string{},
+			}})
+
+			host := joinTestViewer(t, server, "Host")
+			server.stage.MoveViewer(*host.client.Token, Seat{Row: 0, Column: 2})
+
+			viewer := joinTestViewer(t, server, "Viewer")
+			server.setRole(viewer.client, test.role)
+			viewer.messages(t)
+
+			server.changeSeat(*viewer.client.Token, test.seat)
+
+			seat := server.stage.SeatForPlayer(*viewer.client.Token)
+			if moved := seat != nil && *seat == test.seat; moved != test.moved {
+				t.Errorf("expected moved to be %v, got seat %v", test.moved, seat)
+			}
+
+			var errorMessage ErrorMessage
+			lastMessage(viewer.messages(t), MessageError, &errorMessage)
+			if errorMessage.Error != test.errorName {
+				t.Errorf("expected error %q, got %q", test.errorName, errorMessage.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"watch-party/database"
+)
+
+type SeasonData struct {
+	Season   int               `json:"season"`
+	Episodes []GalleryItemData `json:"episodes"`
+}
+
+type SeriesData struct {
+	Name    string       `json:"name"`
+	Seasons []SeasonData `json:"seasons"`
+}
+
+// Release names aren't consistent about case.
+func groupSeries(episodes []database.Video) []SeriesData {
+	sort.SliceStable(episodes, func(i, j int) bool {
+		a, b := episodes[i], episodes[j]
+		aSeries, bSeries := strings.ToLower(a.Series), strings.ToLower(b.Series)
+		if aSeries != bSeries {
+			return aSeries < bSeries
+		}
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		return a.Episode < b.Episode
+	})
+
+	seriesList := make([]SeriesData, 0)
+	for _, episode := range episodes {
+		last := len(seriesList) - 1
+		if last < 0 || !strings.EqualFold(seriesList[last].Name, episode.Series) {
+			seriesList = append(seriesList, SeriesData{Name: episode.Series})
+			last += 1
+		}
+
+		series := &seriesList[last]
+		lastSeason := len(series.Seasons) - 1
+		if lastSeason < 0 || series.Seasons[lastSeason].Season != episode.Season {
+			series.Seasons = append(series.Seasons, SeasonData{Season: episode.Season})
+			lastSeason += 1
+		}
+
+		season := &series.Seasons[lastSeason]
+		season.Episodes = append(season.Episodes, videoGalleryItem(episode))
+	}
+
+	return seriesList
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"time"
@@ -21,6 +22,29 @@ const (
 	ServerMessageReady
 	ServerMessageMonkeyAction
 	ServerMessageChat
+	ServerMessageVideoListChanged
+	ServerMessageImageListChanged
+	ServerMessageSubtitleList
+	ServerMessageRequestSubtitle
+	ServerMessageGrantRole
+	ServerMessageQueueAdd
+	ServerMessageQueueMove
+	ServerMessageQueueRemove
+	ServerMessageVideoEnded
+	ServerMessagePong
+	ServerMessageChatHistory
+	ServerMessageProfile
+	ServerMessageChangeSeat
+	ServerMessageSeatSwapReply
+	ServerMessageRoomStatus
+	ServerMessageKick
+	ServerMessageForcePlay
+	ServerMessageKickViewer
+	ServerMessageBan
+	ServerMessageMute
+	ServerMessageUnmute
+	ServerMessageEnforceBans
+	ServerMessageRateLimited
 )
 
 type ServerMessage struct {
@@ -36,6 +60,33 @@ type ServerMessage struct {
 
 	File *string
 	Name string
+
+	SubtitleID *uint
+
+	Seat        Seat
+	SpectatorID uint
+	Role        Role
+
+	ItemID   uint
+	Position int
+
+	Pong PongMessage
+
+	Before uint
+	Limit  int
+
+	Profile Profile
+
+	Accept bool
+
+	Collection string
+
+	Duration time.Duration
+
+	Warn bool
+
+	StatusReply chan<- RoomStatus
+	Reply       chan<- error
 }
 
 type VideoPlaybackState struct {
@@ -43,17 +94,34 @@ type VideoPlaybackState struct {
 	Progress           float64
 	LastProgressUpdate time.Time
 	VideoFile          string
+	SubtitleID         *uint
+
+	Finished bool
 }
 
 type Server struct {
+	roomID           string
+	config           RoomConfig
 	connectedClients map[string]*Client
+	sessions         map[string]*ViewerSession
 	stage            Stage
 	videoState       VideoPlaybackState
+	queue            []QueueItem
+	nextQueueItemID  uint
+	nextSpectatorID  uint
+	lastClockSync    time.Time
+	readyBarrier     ReadyBarrier
+	seatSwaps        map[string]SeatSwap
+	waitingList      []string
+	mutes            map[string]Mute
+	strikes          map[string][]time.Time
 	db               *gorm.DB
 }
 
 func (server *Server) updateVideoState() {
-	if !server.videoState.Playing {
+	// Nobody starts playing until the ready barrier is released
+	if !server.videoState.Playing || server.readyBarrier.Open {
+		server.videoState.LastProgressUpdate = time.Now()
 		return
 	}
 
@@ -63,8 +131,14 @@ func (server *Server) updateVideoState() {
 }
 
 func (server *Server) updateSeats() {
+	occupants := server.seatOccupants()
+	spectators := server.spectators()
 	for _, client := range server.connectedClients {
-		_ = client.Send(MessageUpdateState, server.stage.UpdateMessage(client.Token))
+		updateMessage := server.stage.UpdateMessage(client.Token)
+		updateMessage.Occupants = occupants
+		updateMessage.Spectators = len(spectators)
+		updateMessage.StandingRoom = spectators
+		_ = client.Send(MessageUpdateState, updateMessage)
 	}
 }
 
@@ -80,6 +154,11 @@ func (server *Server) generateNewToken() string {
 			continue
 		}
 
+		// Seats of disconnected viewers are still reserved with their token
+		if server.stage.SeatForPlayer(token) != nil {
+			continue
+		}
+
 		return token
 	}
 }
@@ -99,83 +178,251 @@ func (server *Server) broadcastExcept(
 }
 
 func (server *Server) join(client *Client) {
+	server.nextSpectatorID += 1
+	client.SpectatorID = server.nextSpectatorID
+
+	if session := server.resumableSession(client.SessionToken); session != nil {
+		server.replaceConnection(session)
+		server.resume(client, session)
+		return
+	}
+
 	token := server.generateNewToken()
 
 	client.Token = &token
+	server.assignJoinRole(client)
 	server.connectedClients[token] = client
-	server.stage.PlaceViewer(token)
+	server.startSession(client)
+	server.takeSeat(client)
 	server.updateSeats()
+	server.updateRoles()
 	server.updateVideoState()
+	server.startReadyBarrier()
 
 	for _, client := range server.connectedClients {
-		client.Ready = false
 		_ = client.Send(MessageRequestPlay, RequestPlayMessage{
 			Playing:   server.videoState.Playing,
 			Progress:  server.videoState.Progress,
 			VideoFile: &server.videoState.VideoFile,
 		})
 	}
+
+	_ = client.Send(MessageRequestSubtitle, server.activeSubtitleMessage())
+	server.sendQueue(client)
+	server.sendChatHistory(client, 0, server.config.ChatHistoryLength)
+	server.sendMuteState(client)
 }
 
-func (server *Server) leave(token string) {
-	if _, has := server.connectedClients[token]; has {
-		delete(server.connectedClients, token)
+func (server *Server) leave(token string, leaving *Client) {
+	// The viewer may have already reconnected, taking over their seat.
+	client, has := server.connectedClients[token]
+	if !has || client != leaving {
+		return
+	}
+
+	delete(server.connectedClients, token)
+	if len(server.connectedClients) == 0 {
+		server.saveWatchProgress()
+	}
+
+	server.cancelSeatSwaps(token)
+	server.leaveWaitingList(token)
+	if client.Role == RoleHost {
+		server.handOffHost()
+	}
+
+	if !server.suspendSession(client) {
+		server.stage.RemovePlayer(token)
+		server.promoteWaitingViewers()
 	}
 
-	server.stage.RemovePlayer(token)
 	server.updateSeats()
+	server.updateRoles()
 }
 
 type GalleryItemData struct {
-	Name          string `json:"name"`
-	ItemFile      string `json:"item_file"`
-	ThumbnailFile string `json:"thumbnail_file"`
+	Name          string         `json:"name"`
+	ItemFile      string         `json:"item_file"`
+	ThumbnailFile string         `json:"thumbnail_file"`
+	HLSPlaylist   string         `json:"hls_playlist,omitempty"`
+	Metadata      *VideoMetadata `json:"metadata,omitempty"`
+	Year          int            `json:"year,omitempty"`
+	Season        int            `json:"season,omitempty"`
+	Episode       int            `json:"episode,omitempty"`
+	Quality       string         `json:"quality,omitempty"`
+}
+
+type VideoMetadata struct {
+	Duration       float64               `json:"duration"`
+	Width          int                   `json:"width"`
+	Height         int                   `json:"height"`
+	VideoCodec     string                `json:"video_codec"`
+	AudioCodec     string                `json:"audio_codec"`
+	Bitrate        int64                 `json:"bitrate"`
+	Container      string                `json:"container"`
+	AudioTracks    []database.MediaTrack `json:"audio_tracks"`
+	SubtitleTracks []database.MediaTrack `json:"subtitle_tracks"`
+
+	// The browser can't play the file itself, only its HLS transcode.
+	BrowserUnsupported bool `json:"browser_unsupported"`
+}
+
+func videoGalleryItem(video database.Video) GalleryItemData {
+	item := GalleryItemData{
+		Name:          video.Title,
+		ItemFile:      video.VideoFilePath,
+		ThumbnailFile: video.ThumbnailPath,
+		Year:          video.Year,
+		Season:        video.Season,
+		Episode:       video.Episode,
+		Quality:       video.Quality,
+	}
+
+	if video.HLSStatus == database.HLSReady {
+		item.HLSPlaylist = video.HLSPlaylistPath
+	}
+
+	if video.MetadataProbed && video.Container != "" {
+		item.Metadata = &VideoMetadata{
+			Duration:           video.Duration,
+			Width:              video.Width,
+			Height:             video.Height,
+			VideoCodec:         video.VideoCodec,
+			AudioCodec:         video.AudioCodec,
+			Bitrate:            video.Bitrate,
+			Container:          video.Container,
+			AudioTracks:        video.AudioTracks,
+			SubtitleTracks:     video.SubtitleTracks,
+			BrowserUnsupported: video.BrowserUnsupported,
+		}
+	}
+
+	return item
 }
 
 type VideoListMessage struct {
-	Videos []GalleryItemData `json:"videos"`
+	Collection  string            `json:"collection"`
+	Title       string            `json:"title"`
+	Parent      *string           `json:"parent"`
+	Collections []CollectionData  `json:"collections"`
+	Videos      []GalleryItemData `json:"videos"`
+	Series      []SeriesData      `json:"series"`
+
+	ContinueWatching []WatchedItemData `json:"continue_watching,omitempty"`
+	RecentlyWatched  []WatchedItemData `json:"recently_watched,omitempty"`
+}
+
+func (server *Server) videoListMessage(collectionPath string) (VideoListMessage, error) {
+	var videos []database.Video
+	result := server.db.
+		Order("title").
+		Find(&videos)
+	if result.Error != nil {
+		return VideoListMessage{}, result.Error
+	}
+
+	message := VideoListMessage{
+		Collection: collectionPath,
+	}
+
+	if collectionPath != "" {
+		var collection database.Collection
+		result := server.db.
+			Where(database.Collection{Path: collectionPath}).
+			First(&collection)
+		if result.Error != nil {
+			return VideoListMessage{}, result.Error
+		}
+
+		parent := parentCollection(collectionPath)
+		message.Title = collection.Title
+		message.Parent = &parent
+	}
+
+	collections, err := server.childCollections(collectionPath, videos)
+	if err != nil {
+		return VideoListMessage{}, err
+	}
+
+	message.Collections = collections
+	message.Videos = make([]GalleryItemData, 0)
+	var episodes []database.Video
+	for _, video := range videos {
+		if videoCollection(video) != collectionPath {
+			continue
+		}
+
+		if video.Series != "" {
+			episodes = append(episodes, video)
+			continue
+		}
+
+		message.Videos = append(message.Videos, videoGalleryItem(video))
+	}
+
+	message.Series = groupSeries(episodes)
+	if collectionPath == "" {
+		if err := server.addWatchHistory(&message); err != nil {
+			return VideoListMessage{}, err
+		}
+	}
+
+	return message, nil
 }
 
-func (server *Server) videoList(token string) {
+func (server *Server) videoList(token string, collectionPath string) {
 	client, exists := server.connectedClients[token]
 	if !exists {
 		return
 	}
 
-	var videos []database.Video
-	if result := server.db.Find(&videos); result.Error != nil {
-		log.WithError(result.Error).Error("Unable to query videos")
+	message, err := server.videoListMessage(collectionPath)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		server.sendError(token, "invalid-collection", "No such collection")
 		return
 	}
 
-	var videoDataList = make([]GalleryItemData, len(videos))
-	for i, video := range videos {
-		videoDataList[i] = GalleryItemData{
-			Name:          video.Title,
-			ItemFile:      video.VideoFilePath,
-			ThumbnailFile: video.ThumbnailPath,
-		}
+	if err != nil {
+		log.WithError(err).Error("Unable to query videos")
+		return
 	}
 
-	_ = client.Send(MessageVideoList, VideoListMessage{
-		Videos: videoDataList,
-	})
+	client.Collection = collectionPath
+	_ = client.Send(MessageVideoList, message)
+}
+
+func (server *Server) videoListChanged() {
+	messages := map[string]VideoListMessage{}
+	for _, client := range server.connectedClients {
+		message, cached := messages[client.Collection]
+		if !cached {
+			var err error
+			message, err = server.videoListMessage(client.Collection)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				client.Collection = ""
+				message, err = server.videoListMessage("")
+			}
+
+			if err != nil {
+				log.WithError(err).Error("Unable to query videos")
+				return
+			}
+
+			messages[client.Collection] = message
+		}
+
+		_ = client.Send(MessageVideoList, message)
+	}
 }
 
 type ImageListMessage struct {
 	Images []GalleryItemData `json:"images"`
 }
 
-func (server *Server) imageList(token string) {
-	client, exists := server.connectedClients[token]
-	if !exists {
-		return
-	}
-
+func (server *Server) imageListMessage() (ImageListMessage, error) {
 	var images []database.Image
 	if result := server.db.Find(&images); result.Error != nil {
-		log.WithError(result.Error).Error("Unable to query videos")
-		return
+		return ImageListMessage{}, result.Error
 	}
 
 	var imageDataList = make([]GalleryItemData, len(images))
@@ -187,9 +434,34 @@ func (server *Server) imageList(token string) {
 		}
 	}
 
-	_ = client.Send(MessageImageList, ImageListMessage{
+	return ImageListMessage{
 		Images: imageDataList,
-	})
+	}, nil
+}
+
+func (server *Server) imageList(token string) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	message, err := server.imageListMessage()
+	if err != nil {
+		log.WithError(err).Error("Unable to query images")
+		return
+	}
+
+	_ = client.Send(MessageImageList, message)
+}
+
+func (server *Server) imageListChanged() {
+	message, err := server.imageListMessage()
+	if err != nil {
+		log.WithError(err).Error("Unable to query images")
+		return
+	}
+
+	server.broadcastExcept("", MessageImageList, message)
 }
 
 func (server *Server) requestPlay(message ServerMessage) {
@@ -200,22 +472,47 @@ func (server *Server) requestPlay(message ServerMessage) {
 		videoFile = *message.File
 	}
 
+	videoChanged := videoFile != server.videoState.VideoFile
+	var resumePoint *database.WatchProgress
+	if videoChanged {
+		server.saveWatchProgress()
+		resumePoint = server.resumePoint(videoFile)
+	}
+
+	subtitleID := server.videoState.SubtitleID
+	subtitleCleared := false
+	if videoChanged && subtitleID != nil {
+		subtitleID = nil
+		subtitleCleared = true
+	}
+
+	// Pausing at the end of a video doesn't make it unfinished.
+	server.updateVideoState()
+	finished := !videoChanged && server.videoState.Finished &&
+		message.Progress >= server.videoState.Progress-ResyncProgressTolerance
+
 	server.videoState = VideoPlaybackState{
 		Playing:            message.Playing,
 		Progress:           message.Progress,
 		VideoFile:          videoFile,
+		SubtitleID:         subtitleID,
 		LastProgressUpdate: time.Now(),
+		Finished:           finished,
 	}
 
-	for _, client := range server.connectedClients {
-		client.Ready = false
-	}
-
+	server.saveWatchProgress()
+	server.startReadyBarrier()
 	server.broadcastExcept(*message.Token, MessageRequestPlay, RequestPlayMessage{
 		Playing:   message.Playing,
 		Progress:  message.Progress,
 		VideoFile: message.File,
 	})
+
+	if subtitleCleared {
+		server.broadcastExcept("", MessageRequestSubtitle, ActiveSubtitleMessage{})
+	}
+
+	server.offerResume(*message.Token, videoFile, resumePoint)
 }
 
 func (server *Server) requestImage(message ServerMessage) {
@@ -229,6 +526,9 @@ func (server *Server) requestImage(message ServerMessage) {
 		"name": message.Name,
 	}).Info("Got image request")
 
+	subtitleCleared := server.videoState.SubtitleID != nil
+
+	server.saveWatchProgress()
 	server.videoState = VideoPlaybackState{
 		Playing:            false,
 		Progress:           0,
@@ -240,9 +540,17 @@ func (server *Server) requestImage(message ServerMessage) {
 		File: *message.File,
 		Name: message.Name,
 	})
+	if subtitleCleared {
+		server.broadcastExcept("", MessageRequestSubtitle, ActiveSubtitleMessage{})
+	}
 }
 
 func (server *Server) ready(token string) {
+	if client, exists := server.connectedClients[token]; exists && client.CatchingUp {
+		server.caughtUp(client)
+		return
+	}
+
 	allClientsReady := true
 	for clientToken, client := range server.connectedClients {
 		if token == clientToken {
@@ -251,7 +559,7 @@ func (server *Server) ready(token string) {
 			continue
 		}
 
-		if !client.Ready {
+		if !client.Ready && !client.CatchingUp {
 			allClientsReady = false
 			log.WithField("token", clientToken).Info("Is still buffering")
 		}
@@ -259,19 +567,128 @@ func (server *Server) ready(token string) {
 
 	if allClientsReady {
 		log.Info("All clients are ready, playing request")
+		server.releaseReadyBarrier()
 		server.broadcastExcept("", MessageReady, nil)
 	}
 }
 
+type SubtitleData struct {
+	ID       uint   `json:"id"`
+	Label    string `json:"label"`
+	Language string `json:"language"`
+	File     string `json:"file"`
+}
+
+type SubtitleListMessage struct {
+	VideoFile string         `json:"video"`
+	Subtitles []SubtitleData `json:"subtitles"`
+}
+
+type ActiveSubtitleMessage struct {
+	Subtitle *SubtitleData `json:"subtitle"`
+}
+
+func subtitleData(subtitle database.Subtitle) SubtitleData {
+	return SubtitleData{
+		ID:       subtitle.ID,
+		Label:    subtitle.Label,
+		Language: subtitle.Language,
+		File:     subtitle.FilePath,
+	}
+}
+
+func (server *Server) subtitleList(token string, videoFile *string) {
+	client, exists := server.connectedClients[token]
+	if !exists {
+		return
+	}
+
+	file := server.videoState.VideoFile
+	if videoFile != nil {
+		file = *videoFile
+	}
+
+	var subtitles []database.Subtitle
+	result := server.db.
+		Joins("JOIN videos ON videos.id = subtitles.video_id").
+		Where("videos.video_file_path = ? AND subtitles.status = ?", file, database.SubtitleReady).
+		Find(&subtitles)
+	if result.Error != nil {
+		log.WithError(result.Error).Error("Unable to query subtitles")
+		return
+	}
+
+	var subtitleDataList = make([]SubtitleData, len(subtitles))
+	for i, subtitle := range subtitles {
+		subtitleDataList[i] = subtitleData(subtitle)
+	}
+
+	_ = client.Send(MessageSubtitleList, SubtitleListMessage{
+		VideoFile: file,
+		Subtitles: subtitleDataList,
+	})
+}
+
+func (server *Server) activeSubtitleMessage() ActiveSubtitleMessage {
+	if server.videoState.SubtitleID == nil {
+		return ActiveSubtitleMessage{}
+	}
+
+	var subtitle database.Subtitle
+	if result := server.db.First(&subtitle, *server.videoState.SubtitleID); result.Error != nil {
+		log.WithError(result.Error).Error("Unable to query active subtitle")
+		return ActiveSubtitleMessage{}
+	}
+
+	data := subtitleData(subtitle)
+	return ActiveSubtitleMessage{
+		Subtitle: &data,
+	}
+}
+
+func (server *Server) requestSubtitle(token string, subtitleID *uint) {
+	if subtitleID != nil {
+		var count int64
+		result := server.db.
+			Model(&database.Subtitle{}).
+			Joins("JOIN videos ON videos.id = subtitles.video_id").
+			Where("subtitles.id = ? AND subtitles.status = ?", *subtitleID, database.SubtitleReady).
+			Where("videos.video_file_path = ?", server.videoState.VideoFile).
+			Count(&count)
+		if result.Error != nil {
+			log.WithError(result.Error).Error("Unable to query subtitles")
+			return
+		}
+
+		if count == 0 {
+			log.WithFields(log.Fields{
+				"token":    token,
+				"subtitle": *subtitleID,
+			}).Warn("Invalid subtitle request")
+			return
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"token":    token,
+		"subtitle": subtitleID,
+	}).Info("Got subtitle request")
+
+	server.videoState.SubtitleID = subtitleID
+	server.broadcastExcept("", MessageRequestSubtitle, server.activeSubtitleMessage())
+}
+
 type MonkeyActionResponseMessage struct {
 	Action string `json:"action"`
 	Row    int    `json:"row"`
 	Column int    `json:"column"`
+	Name   string `json:"name"`
 }
 
 func (server *Server) monkeyAction(token string, action string) {
+	client, exists := server.connectedClients[token]
 	seat := server.stage.SeatForPlayer(token)
-	if seat == nil {
+	if !exists || seat == nil || server.refuseMuted(client) {
 		return
 	}
 
@@ -279,19 +696,29 @@ func (server *Server) monkeyAction(token string, action string) {
 		Action: action,
 		Row:    seat.Row,
 		Column: seat.Column,
+		Name:   client.Profile.Name,
 	})
 }
 
 type ChatResponseMessage struct {
-	Message string `json:"message"`
-	Row     int    `json:"row"`
-	Column  int    `json:"column"`
+	Message     string `json:"message"`
+	Row         int    `json:"row"`
+	Column      int    `json:"column"`
+	Name        string `json:"name"`
+	SpectatorID uint   `json:"spectator_id,omitempty"`
 }
 
 func (server *Server) chat(token string, message string) {
+	client, exists := server.connectedClients[token]
+	if !exists || server.refuseMuted(client) {
+		return
+	}
+
 	seat := server.stage.SeatForPlayer(token)
+	spectatorID := uint(0)
 	if seat == nil {
-		return
+		seat = &spectatorSeat
+		spectatorID = client.SpectatorID
 	}
 
 	log.WithFields(log.Fields{
@@ -301,10 +728,13 @@ func (server *Server) chat(token string, message string) {
 		"seat":    seat.Column,
 	}).Trace("Send chat message")
 
+	server.saveChatMessage(token, *seat, message)
 	server.broadcastExcept("", MessageChat, ChatResponseMessage{
-		Message: message,
-		Row:     seat.Row,
-		Column:  seat.Column,
+		Message:     message,
+		Row:         seat.Row,
+		Column:      seat.Column,
+		Name:        client.Profile.Name,
+		SpectatorID: spectatorID,
 	})
 }
 
@@ -323,13 +753,17 @@ func (server *Server) logRequest(message ServerMessage) {
 }
 
 func (server *Server) handleMessage(message ServerMessage) {
+	if !server.permitted(message) {
+		return
+	}
+
 	switch message.Type {
 	case ServerMessageJoin:
 		server.join(message.Client)
 	case ServerMessageLeave:
-		server.leave(*message.Token)
+		server.leave(*message.Token, message.Client)
 	case ServerMessageVideoList:
-		server.videoList(*message.Token)
+		server.videoList(*message.Token, message.Collection)
 	case ServerMessageImageList:
 		server.imageList(*message.Token)
 	case ServerMessageRequestPlay:
@@ -342,16 +776,69 @@ func (server *Server) handleMessage(message ServerMessage) {
 		server.monkeyAction(*message.Token, message.Action)
 	case ServerMessageChat:
 		server.chat(*message.Token, message.Message)
+	case ServerMessageVideoListChanged:
+		server.videoListChanged()
+	case ServerMessageImageListChanged:
+		server.imageListChanged()
+	case ServerMessageSubtitleList:
+		server.subtitleList(*message.Token, message.File)
+	case ServerMessageRequestSubtitle:
+		server.requestSubtitle(*message.Token, message.SubtitleID)
+	case ServerMessageGrantRole:
+		server.grantRole(*message.Token, message.Seat, message.Role)
+	case ServerMessageQueueAdd:
+		server.queueAdd(*message.Token, *message.File)
+	case ServerMessageQueueMove:
+		server.queueMove(*message.Token, message.ItemID, message.Position)
+	case ServerMessageQueueRemove:
+		server.queueRemove(*message.Token, message.ItemID)
+	case ServerMessageVideoEnded:
+		server.videoEnded(*message.Token, *message.File, message.Progress)
+	case ServerMessagePong:
+		server.pong(*message.Token, message.Pong)
+	case ServerMessageChatHistory:
+		server.chatHistory(*message.Token, message.Before, message.Limit)
+	case ServerMessageProfile:
+		server.updateProfile(*message.Token, message.Profile)
+	case ServerMessageChangeSeat:
+		server.changeSeat(*message.Token, message.Seat)
+	case ServerMessageSeatSwapReply:
+		server.seatSwapReply(*message.Token, message.Seat, message.Accept)
+	case ServerMessageRoomStatus:
+		message.StatusReply <- server.roomStatus()
+	case ServerMessageKick:
+		message.Reply <- server.kick(*message.Token, message.Message)
+	case ServerMessageForcePlay:
+		message.Reply <- server.forcePlay(message)
+	case ServerMessageKickViewer:
+		server.kickViewer(*message.Token, message.target(), message.Message)
+	case ServerMessageBan:
+		server.ban(*message.Token, message.target(), message.Message, message.Duration)
+	case ServerMessageMute:
+		server.mute(*message.Token, message.target(), message.Message, message.Duration)
+	case ServerMessageUnmute:
+		server.unmute(*message.Token, message.target())
+	case ServerMessageEnforceBans:
+		server.enforceBans()
+	case ServerMessageRateLimited:
+		server.rateLimited(*message.Token, MessageType(message.Action), message.Duration, message.Warn)
 	default:
 		panic(message)
 	}
 }
 
-func StartServer(db *gorm.DB, messages <-chan ServerMessage) {
-	server := Server{
+func newServer(roomID string, db *gorm.DB, config RoomConfig) *Server {
+	return &Server{
+		roomID:           roomID,
+		config:           config,
 		connectedClients: map[string]*Client{},
+		sessions:         map[string]*ViewerSession{},
+		seatSwaps:        map[string]SeatSwap{},
+		mutes:            map[string]Mute{},
+		strikes:          map[string][]time.Time{},
 		stage: Stage{
 			seatsUsed: map[string]Seat{},
+			layout:    config.Layouts.ForRoom(roomID),
 		},
 		videoState: VideoPlaybackState{
 			Playing:            false,
@@ -361,8 +848,35 @@ func StartServer(db *gorm.DB, messages <-chan ServerMessage) {
 		},
 		db: db,
 	}
+}
+
+func StartServer(
+	roomID string,
+	db *gorm.DB,
+	config RoomConfig,
+	messages <-chan ServerMessage,
+	stopped <-chan struct{},
+) {
+	server := newServer(roomID, db, config)
 
-	for message := range messages {
-		server.handleMessage(message)
+	ticker := time.NewTicker(ServerTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-messages:
+			server.handleMessage(message)
+		case <-stopped:
+			log.WithField("room", roomID).Info("Room server stopped")
+			return
+		case <-ticker.C:
+			server.tick()
+		}
 	}
 }
+
+func (server *Server) tick() {
+	server.expireSessions()
+	server.clockSyncTick()
+	server.readyTimeoutTick()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"watch-party/database"
+)
+
+const testMarker = MessageType("test-marker")
+
+type testViewer struct {
+	client   *Client
+	received <-chan Message
+}
+
+func newTestServer(t *testing.T, config RoomConfig) *Server {
+	db, err := database.Open(path.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Layouts.Layouts == nil {
+		config.Layouts = defaultLayoutConfig()
+	}
+
+	return newServer("test", db, config)
+}
+
+func connectTestViewer(t *testing.T, name string) *testViewer {
+	accepted := make(chan *websocket.Conn, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		connection, err := websocket.Accept(response, request, nil)
+		if err == nil {
+			accepted <- connection
+		}
+	}))
+	t.Cleanup(httpServer.Close)
+
+	ctx := context.Background()
+	connection, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = connection.Close(websocket.StatusNormalClosure, "")
+	})
+
+	received := make(chan Message, 256)
+	go func() {
+		defer close(received)
+		for {
+			_, content, err := connection.Read(ctx)
+			if err != nil {
+				return
+			}
+
+			var message Message
+			if err := json.Unmarshal(content, &message); err == nil {
+				received <- message
+			}
+		}
+	}()
+
+	// The server only writes, but something has to answer the close handshake.
+	serverConnection := <-accepted
+	serverConnection.CloseRead(ctx)
+
+	return &testViewer{
+		client: &Client{
+			RoomID:     "test",
+			Connection: serverConnection,
+			Context:    &ctx,
+			Profile:    Profile{Name: name},
+			JoinedAt:   time.Now(),
+		},
+		received: received,
+	}
+}
+
+func joinTestViewer(t *testing.T, server *Server, name string) *testViewer {
+	viewer := connectTestViewer(t, name)
+	server.join(viewer.client)
+	return viewer
+}
+
+// messages collects everything sent to the viewer so far.
+func (viewer *testViewer) messages(t *testing.T) []Message {
+	t.Helper()
+	if err := viewer.client.Send(testMarker, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []Message
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message, open := <-viewer.received:
+			if !open {
+				t.Fatal("connection closed")
+			}
+
+			if message.Type == testMarker {
+				return messages
+			}
+
+			messages = append(messages, message)
+		case <-timeout:
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+}
+
+func (viewer *testViewer) closed() bool {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, open := <-viewer.received:
+			if !open {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// lastMessage decodes the last message of a type into `data`, returning false
+// if there wasn't one.
+func lastMessage(messages []Message, messageType MessageType, data interface{}) bool {
+	found := false
+	for _, message := range messages {
+		if message.Type != messageType {
+			continue
+		}
+
+		found = true
+		if data != nil {
+			_ = json.Unmarshal(message.Data, data)
+		}
+	}
+
+	return found
+}
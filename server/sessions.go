@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type ViewerSession struct {
+	SessionToken string
+	Token        string
+	Profile      Profile
+	Role         Role
+	Ready        bool
+
+	// Nil while the viewer is connected.
+	DisconnectedAt *time.Time
+}
+
+type SessionMessage struct {
+	SessionToken string  `json:"session_token"`
+	GracePeriod  float64 `json:"grace_period"`
+}
+
+func sanitizeDisplayName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+
+		return r
+	}, name)
+
+	name = strings.TrimSpace(name)
+	if len([]rune(name)) > MaxDisplayNameLength {
+		name = string([]rune(name)[:MaxDisplayNameLength])
+	}
+
+	return name
+}
+
+func (server *Server) generateSessionToken() string {
+	for {
+		tokenBytes := make([]byte, 16)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			panic(err)
+		}
+
+		sessionToken := hex.EncodeToString(tokenBytes)
+		if _, has := server.sessions[sessionToken]; has {
+			continue
+		}
+
+		return sessionToken
+	}
+}
+
+func (server *Server) sendSession(client *Client) {
+	_ = client.Send(MessageSession, SessionMessage{
+		SessionToken: client.SessionToken,
+		GracePeriod:  server.config.SeatGracePeriod.Seconds(),
+	})
+}
+
+func (server *Server) startSession(client *Client) {
+	client.SessionToken = server.generateSessionToken()
+	server.sessions[client.SessionToken] = &ViewerSession{
+		SessionToken: client.SessionToken,
+		Token:        *client.Token,
+		Profile:      client.Profile,
+		Role:         client.Role,
+	}
+
+	server.sendSession(client)
+}
+
+func (server *Server) resumableSession(sessionToken string) *ViewerSession {
+	if sessionToken == "" {
+		return nil
+	}
+
+	session, has := server.sessions[sessionToken]
+	if !has {
+		return nil
+	}
+
+	if server.stage.SeatForPlayer(session.Token) == nil {
+		return nil
+	}
+
+	return session
+}
+
+// Refreshing can reconnect before the old connection is noticed closing.
+func (server *Server) replaceConnection(session *ViewerSession) {
+	previous, connected := server.connectedClients[session.Token]
+	if !connected {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"token": session.Token,
+		"room":  server.roomID,
+	}).Info("Viewer reconnected, closing their old connection")
+
+	delete(server.connectedClients, session.Token)
+	session.Ready = previous.Ready
+	session.Profile = previous.Profile
+	session.Role = previous.Role
+
+	go previous.Connection.Close(websocket.StatusNormalClosure, "Reconnected")
+}
+
+func (server *Server) resume(client *Client, session *ViewerSession) {
+	token := session.Token
+	client.Token = &token
+	client.Ready = session.Ready
+	if !server.readyBarrier.Open {
+		// Playback already started without them, so they join in once buffered
+		client.Ready = false
+		client.CatchingUp = true
+	}
+	// Only the name can be given when connecting.
+	name := client.Profile.Name
+	client.Profile = session.Profile
+	if name != "" {
+		client.Profile.Name = name
+	}
+
+	session.Profile = client.Profile
+	session.DisconnectedAt = nil
+
+	// Someone else may have been handed host while they were away
+	client.Role = session.Role
+	if client.Role == RoleHost && server.currentHost() != nil {
+		client.Role = RoleModerator
+	}
+
+	server.connectedClients[token] = client
+	server.setRole(client, client.Role)
+	if server.currentHost() == nil {
+		server.setRole(client, RoleHost)
+	}
+
+	log.WithFields(log.Fields{
+		"token":   token,
+		"name":    client.Profile.Name,
+		"ready":   client.Ready,
+		"room":    server.roomID,
+		"session": session.SessionToken[:4] + "...",
+	}).Info("Viewer reclaimed their seat")
+
+	server.sendSession(client)
+	server.takeSeat(client)
+	server.updateSeats()
+	server.updateRoles()
+	server.updateVideoState()
+
+	// Only the returning viewer needs to catch up, everyone else carries on.
+	_ = client.Send(MessageRequestPlay, RequestPlayMessage{
+		Playing:   server.videoState.Playing,
+		Progress:  server.videoState.Progress,
+		VideoFile: &server.videoState.VideoFile,
+	})
+	_ = client.Send(MessageRequestSubtitle, server.activeSubtitleMessage())
+	server.sendQueue(client)
+	server.sendChatHistory(client, 0, server.config.ChatHistoryLength)
+	server.sendMuteState(client)
+}
+
+func (server *Server) suspendSession(client *Client) bool {
+	session, has := server.sessions[client.SessionToken]
+	if !has {
+		return false
+	}
+
+	if server.config.SeatGracePeriod <= 0 {
+		delete(server.sessions, client.SessionToken)
+		return false
+	}
+
+	now := time.Now()
+	session.Ready = client.Ready
+	session.Profile = client.Profile
+	session.Role = client.Role
+	session.DisconnectedAt = &now
+
+	log.WithFields(log.Fields{
+		"token":        session.Token,
+		"grace-period": server.config.SeatGracePeriod,
+	}).Info("Reserving seat for disconnected viewer")
+	return true
+}
+
+func (server *Server) expireSessions() {
+	seatsFreed := false
+	for sessionToken, session := range server.sessions {
+		if session.DisconnectedAt == nil {
+			continue
+		}
+
+		if time.Since(*session.DisconnectedAt) < server.config.SeatGracePeriod {
+			continue
+		}
+
+		log.WithField("token", session.Token).
+			Info("Seat reservation expired")
+
+		delete(server.sessions, sessionToken)
+		server.stage.RemovePlayer(session.Token)
+		seatsFreed = true
+	}
+
+	if seatsFreed {
+		server.promoteWaitingViewers()
+		server.updateSeats()
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResumeSession(t *testing.T) {
+	tests := []struct {
+		name         string
+		gracePeriod  time.Duration
+		leave        bool
+		expire       bool
+		sessionToken string
+		resumed      bool
+	}{
+		{name: "after leaving", gracePeriod: time.Minute, leave: true, resumed: true},
+		{name: "before leaving is noticed", gracePeriod: time.Minute, resumed: true},
+		{name: "reservation expired", gracePeriod: time.Minute, leave: true, expire: true},
+		{name: "no grace period", leave: true},
+		{name: "unknown session", gracePeriod: time.Minute, leave: true, sessionToken: "made-up"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{SeatGracePeriod: test.gracePeriod})
+			first := joinTestViewer(t, server, "Alice")
+			token := *first.client.Token
+			seat := *server.stage.SeatForPlayer(token)
+
+			if test.leave {
+				server.leave(token, first.client)
+			}
+
+			if test.expire {
+				disconnectedAt := time.Now().Add(-2 * test.gracePeriod)
+				server.sessions[first.client.SessionToken].DisconnectedAt = &disconnectedAt
+				server.expireSessions()
+			}
+
+			sessionToken := first.client.SessionToken
+			if test.sessionToken != "" {
+				sessionToken = test.sessionToken
+			}
+
+			second := connectTestViewer(t, "")
+			second.client.SessionToken = sessionToken
+			server.join(second.client)
+
+			if resumed := *second.client.Token == token; resumed != test.resumed {
+				t.Fatalf("expected resumed to be %t", test.resumed)
+			}
+
+			if !test.resumed {
+				return
+			}
+
+			if newSeat := server.stage.SeatForPlayer(token); newSeat == nil || *newSeat != seat {
+				t.Errorf("expected to get seat %+v back, got %+v", seat, newSeat)
+			}
+
+			if second.client.Profile.Name != "Alice" || second.client.Role != RoleHost {
+				t.Errorf("expected Alice's profile and role back, got %+v", second.client)
+			}
+
+			if server.connectedClients[token] != second.client || len(server.connectedClients) != 1 {
+				t.Error("expected the new connection to replace the old one")
+			}
+
+			if !test.leave && !first.closed() {
+				t.Error("expected the old connection to be closed")
+			}
+
+			server.leave(token, first.client)
+			if server.connectedClients[token] != second.client {
+				t.Error("expected the old connection leaving to be ignored")
+			}
+		})
+	}
+}
+
+func TestResumeSessionHost(t *testing.T) {
+	server := newTestServer(t, RoomConfig{SeatGracePeriod: time.Minute})
+	host := joinTestViewer(t, server, "Alice")
+	server.leave(*host.client.Token, host.client)
+
+	other := joinTestViewer(t, server, "Bob")
+	if other.client.Role != RoleHost {
+		t.Fatalf("expected Bob to be handed host, got %s", other.client.Role)
+	}
+
+	returning := connectTestViewer(t, "")
+	returning.client.SessionToken = host.client.SessionToken
+	server.join(returning.client)
+
+	if *returning.client.Token != *host.client.Token {
+		t.Fatal("expected Alice to get her seat back")
+	}
+
+	if returning.client.Role != RoleModerator || other.client.Role != RoleHost {
+		t.Errorf("expected Bob to stay host, got %s and %s", returning.client.Role, other.client.Role)
+	}
+
+	var session SessionMessage
+	if !lastMessage(returning.messages(t), MessageSession, &session) || session.SessionToken != host.client.SessionToken {
+		t.Errorf("expected the same session back, got %+v", session)
+	}
+}
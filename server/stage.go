@@ -12,28 +12,36 @@ type Seat struct {
 
 type Stage struct {
 	seatsUsed map[string]Seat
+	layout    Layout
 }
 
 type StageUpdateMessage struct {
 	SeatsNotFree []Seat  `json:"seats_not_free"`
 	YourToken    *string `json:"your_token"`
-	YourSeat     Seat    `json:"your_seat"`
+	YourSeat     *Seat   `json:"your_seat"`
+
+	Layout       Layout         `json:"layout"`
+	Occupants    []SeatOccupant `json:"occupants"`
+	Spectators   int            `json:"spectators"`
+	StandingRoom []Spectator    `json:"standing_room"`
 }
 
 func (stage *Stage) UpdateMessage(yourToken *string) StageUpdateMessage {
 	var seatsNotFree []Seat
-	var yourSeat Seat
-	for token, seat := range stage.seatsUsed {
+	for _, seat := range stage.seatsUsed {
 		seatsNotFree = append(seatsNotFree, seat)
-		if yourToken != nil && token == *yourToken {
-			yourSeat = seat
-		}
+	}
+
+	var yourSeat *Seat
+	if yourToken != nil {
+		yourSeat = stage.SeatForPlayer(*yourToken)
 	}
 
 	return StageUpdateMessage{
 		SeatsNotFree: seatsNotFree,
 		YourToken:    yourToken,
 		YourSeat:     yourSeat,
+		Layout:       stage.layout,
 	}
 }
 
@@ -56,28 +64,73 @@ func (stage *Stage) SeatForPlayer(token string) *Seat {
 	return &seat
 }
 
-func (stage *Stage) PlaceViewer(token string) {
-	for {
-		row := rand.Intn(len(RowSeatCount)-2) + 2
-		column := rand.Intn(RowSeatCount[row])
-		seat := Seat{
-			Row:    row,
-			Column: column,
-		}
+func (stage *Stage) ValidSeat(seat Seat) bool {
+	return stage.layout.ValidSeat(seat)
+}
 
-		existingPlayer := stage.PlayerInSeat(seat)
-		if existingPlayer == nil {
-			stage.seatsUsed[token] = seat
-			break
+func (stage *Stage) MoveViewer(token string, seat Seat) bool {
+	if !stage.ValidSeat(seat) || stage.PlayerInSeat(seat) != nil {
+		return false
+	}
+
+	stage.seatsUsed[token] = seat
+	log.WithFields(log.Fields{
+		"token": token,
+		"row":   seat.Row,
+		"seat":  seat.Column,
+	}).Info("Moved seat")
+	return true
+}
+
+func (stage *Stage) SwapViewers(token string, otherToken string) bool {
+	seat, has := stage.seatsUsed[token]
+	otherSeat, otherHas := stage.seatsUsed[otherToken]
+	if !has || !otherHas {
+		return false
+	}
+
+	stage.seatsUsed[token] = otherSeat
+	stage.seatsUsed[otherToken] = seat
+	log.WithFields(log.Fields{
+		"token": token,
+		"other": otherToken,
+	}).Info("Swapped seats")
+	return true
+}
+
+func (stage *Stage) freeStandardSeats() []Seat {
+	var seats []Seat
+	for row, seatCount := range stage.layout.Rows {
+		for column := 0; column < seatCount; column++ {
+			seat := Seat{
+				Row:    row,
+				Column: column,
+			}
+
+			if stage.layout.IsStandardSeat(seat) && stage.PlayerInSeat(seat) == nil {
+				seats = append(seats, seat)
+			}
 		}
 	}
 
-	seat := stage.seatsUsed[token]
+	return seats
+}
+
+func (stage *Stage) PlaceViewer(token string) bool {
+	freeSeats := stage.freeStandardSeats()
+	if len(freeSeats) == 0 {
+		log.WithField("token", token).Warn("No free seats left")
+		return false
+	}
+
+	seat := freeSeats[rand.Intn(len(freeSeats))]
+	stage.seatsUsed[token] = seat
 	log.WithFields(log.Fields{
 		"token": token,
 		"row":   seat.Row,
 		"seat":  seat.Column,
 	}).Info("Assigned seat")
+	return true
 }
 
 func (stage *Stage) RemovePlayer(token string) {
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"watch-party/database"
+)
+
+type UploadKind string
+
+const (
+	UploadVideo = UploadKind("video")
+	UploadImage = UploadKind("image")
+)
+
+var ErrUploadExists = errors.New("a file with that name already exists")
+var ErrChecksumMismatch = errors.New("checksum doesn't match")
+var ErrUploadOffset = errors.New("chunk doesn't start where the upload left off")
+
+type Upload struct {
+	ID        string     `json:"id"`
+	FileName  string     `json:"file_name"`
+	Kind      UploadKind `json:"kind"`
+	Size      int64      `json:"size"`
+	SHA256    string     `json:"sha256"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type UploadStatus struct {
+	Upload
+	Offset   int64 `json:"offset"`
+	Complete bool  `json:"complete"`
+}
+
+type UploadRequest struct {
+	FileName string     `json:"file_name"`
+	Kind     UploadKind `json:"kind"`
+	Size     int64      `json:"size"`
+	SHA256   string     `json:"sha256"`
+}
+
+type uploadManager struct {
+	path    string
+	maxSize int64
+	library *database.Library
+
+	// Chunks for the same upload are written one at a time.
+	locks map[string]*sync.Mutex
+	mutex sync.Mutex
+}
+
+func newUploadManager(uploadsPath string, maxSize int64, library *database.Library) *uploadManager {
+	return &uploadManager{
+		path:    uploadsPath,
+		maxSize: maxSize,
+		library: library,
+		locks:   map[string]*sync.Mutex{},
+	}
+}
+
+func (uploads *uploadManager) lock(uploadID string) func() {
+	uploads.mutex.Lock()
+	lock, has := uploads.locks[uploadID]
+	if !has {
+		lock = &sync.Mutex{}
+		uploads.locks[uploadID] = lock
+	}
+	uploads.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (uploads *uploadManager) forget(uploadID string) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+	delete(uploads.locks, uploadID)
+}
+
+func (uploads *uploadManager) partPath(uploadID string) string {
+	return path.Join(uploads.path, uploadID+".part")
+}
+
+func (uploads *uploadManager) metadataPath(uploadID string) string {
+	return path.Join(uploads.path, uploadID+".json")
+}
+
+func (uploads *uploadManager) destinationPath(upload Upload) string {
+	if upload.Kind == UploadImage {
+		return path.Join(uploads.library.ImagesPath(), upload.FileName)
+	}
+
+	return path.Join(uploads.library.VideosPath(), upload.FileName)
+}
+
+func allowedExtension(kind UploadKind, fileName string) bool {
+	extensions := UploadVideoExtensions
+	if kind == UploadImage {
+		extensions = UploadImageExtensions
+	}
+
+	extension := strings.ToLower(path.Ext(fileName))
+	for _, allowed := range extensions {
+		if extension == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validUploadID(uploadID string) bool {
+	_, err := hex.DecodeString(uploadID)
+	return err == nil && len(uploadID) == 32
+}
+
+func (uploads *uploadManager) validate(request UploadRequest) error {
+	if request.Kind != UploadVideo && request.Kind != UploadImage {
+		return errors.New("kind must be 'video' or 'image'")
+	}
+
+	fileName := filepath.Base(request.FileName)
+	if fileName != request.FileName || strings.HasPrefix(fileName, ".") || fileName == "" {
+		return errors.New("invalid file name")
+	}
+
+	if !allowedExtension(request.Kind, fileName) {
+		return fmt.Errorf("'%s' files can't be uploaded as a %s", path.Ext(fileName), request.Kind)
+	}
+
+	if request.Size <= 0 || request.Size > uploads.maxSize {
+		return fmt.Errorf("size must be between 1 and %d bytes", uploads.maxSize)
+	}
+
+	if checksum, err := hex.DecodeString(request.SHA256); err != nil || len(checksum) != sha256.Size {
+		return errors.New("sha256 must be a hex encoded SHA-256 checksum")
+	}
+
+	return nil
+}
+
+func (uploads *uploadManager) load(uploadID string) (Upload, error) {
+	var upload Upload
+	if !validUploadID(uploadID) {
+		return upload, os.ErrNotExist
+	}
+
+	metadata, err := os.ReadFile(uploads.metadataPath(uploadID))
+	if err != nil {
+		return upload, err
+	}
+
+	err = json.Unmarshal(metadata, &upload)
+	return upload, err
+}
+
+func (uploads *uploadManager) status(upload Upload) (UploadStatus, error) {
+	partInfo, err := os.Stat(uploads.partPath(upload.ID))
+	if err != nil {
+		return UploadStatus{}, err
+	}
+
+	return UploadStatus{
+		Upload: upload,
+		Offset: partInfo.Size(),
+	}, nil
+}
+
+func (uploads *uploadManager) remove(uploadID string) {
+	for _, filePath := range []string{uploads.partPath(uploadID), uploads.metadataPath(uploadID)} {
+		if err := os.Remove(filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).
+				WithField("upload", uploadID).
+				Warn("Unable to remove upload file")
+		}
+	}
+
+	uploads.forget(uploadID)
+}
+
+func (uploads *uploadManager) removeStale() {
+	entries, err := os.ReadDir(uploads.path)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		uploadID, isMetadata := strings.CutSuffix(entry.Name(), ".json")
+		if !isMetadata || !validUploadID(uploadID) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < UploadExpiry {
+			continue
+		}
+
+		log.WithField("upload", uploadID).Info("Removing abandoned upload")
+		uploads.remove(uploadID)
+	}
+}
+
+func (uploads *uploadManager) create(request UploadRequest) (UploadStatus, error) {
+	if err := uploads.validate(request); err != nil {
+		return UploadStatus{}, err
+	}
+
+	upload := Upload{
+		FileName:  request.FileName,
+		Kind:      request.Kind,
+		Size:      request.Size,
+		SHA256:    strings.ToLower(request.SHA256),
+		CreatedAt: time.Now(),
+	}
+
+	if _, err := os.Stat(uploads.destinationPath(upload)); err == nil {
+		return UploadStatus{}, ErrUploadExists
+	}
+
+	uploads.removeStale()
+	if err := os.MkdirAll(uploads.path, os.ModePerm); err != nil {
+		return UploadStatus{}, err
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return UploadStatus{}, err
+	}
+
+	upload.ID = hex.EncodeToString(idBytes)
+	metadata, err := json.Marshal(upload)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+
+	if err := os.WriteFile(uploads.metadataPath(upload.ID), metadata, 0o644); err != nil {
+		return UploadStatus{}, err
+	}
+
+	if err := os.WriteFile(uploads.partPath(upload.ID), nil, 0o644); err != nil {
+		return UploadStatus{}, err
+	}
+
+	log.WithFields(log.Fields{
+		"upload": upload.ID,
+		"file":   upload.FileName,
+		"size":   upload.Size,
+	}).Info("Started upload")
+
+	return UploadStatus{Upload: upload}, nil
+}
+
+func (uploads *uploadManager) writeChunk(upload Upload, offset int64, chunk io.Reader) (UploadStatus, error) {
+	unlock := uploads.lock(upload.ID)
+	defer unlock()
+
+	status, err := uploads.status(upload)
+	if err != nil {
+		return status, err
+	}
+
+	if offset != status.Offset {
+		return status, ErrUploadOffset
+	}
+
+	partFile, err := os.OpenFile(uploads.partPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return status, err
+	}
+
+	// Read one byte past the end, to tell if the client sent too much.
+	remaining := upload.Size - status.Offset
+	written, err := io.Copy(partFile, io.LimitReader(chunk, remaining+1))
+	closeErr := partFile.Close()
+	status.Offset += written
+	if err != nil {
+		return status, err
+	}
+
+	if closeErr != nil {
+		return status, closeErr
+	}
+
+	if status.Offset > upload.Size {
+		_ = os.Truncate(uploads.partPath(upload.ID), upload.Size)
+		status.Offset = upload.Size
+		return status, errors.New("chunk goes past the end of the upload")
+	}
+
+	if status.Offset < upload.Size {
+		return status, nil
+	}
+
+	if err := uploads.finish(upload); err != nil {
+		return status, err
+	}
+
+	status.Complete = true
+	return status, nil
+}
+
+func fileChecksum(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Linked where possible, so the file never appears half written.
+func moveFile(sourcePath string, destinationPath string) (bool, error) {
+	err := os.Link(sourcePath, destinationPath)
+	if errors.Is(err, os.ErrExist) {
+		return false, ErrUploadExists
+	}
+	if err == nil {
+		return true, os.Remove(sourcePath)
+	}
+
+	destination, err := os.OpenFile(destinationPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return false, ErrUploadExists
+	}
+	if err != nil {
+		return false, err
+	}
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		destination.Close()
+		_ = os.Remove(destinationPath)
+		return false, err
+	}
+	defer source.Close()
+
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		_ = os.Remove(destinationPath)
+		return false, err
+	}
+
+	if err := destination.Close(); err != nil {
+		_ = os.Remove(destinationPath)
+		return false, err
+	}
+
+	return false, os.Remove(sourcePath)
+}
+
+func (uploads *uploadManager) finish(upload Upload) error {
+	partPath := uploads.partPath(upload.ID)
+	checksum, err := fileChecksum(partPath)
+	if err != nil {
+		return err
+	}
+
+	if checksum != upload.SHA256 {
+		log.WithFields(log.Fields{
+			"upload":   upload.ID,
+			"expected": upload.SHA256,
+			"got":      checksum,
+		}).Warn("Upload checksum mismatch")
+
+		uploads.remove(upload.ID)
+		return ErrChecksumMismatch
+	}
+
+	destinationPath := uploads.destinationPath(upload)
+	linked, err := moveFile(partPath, destinationPath)
+	if err != nil {
+		return err
+	}
+
+	uploads.remove(upload.ID)
+
+	// Copies are left for the library watcher to pick up once they've settled.
+	if linked {
+		uploads.library.AddFile(destinationPath)
+	}
+
+	log.WithFields(log.Fields{
+		"upload": upload.ID,
+		"file":   destinationPath,
+	}).Info("Finished upload")
+	return nil
+}
+
+func (api *adminAPI) uploadsEndpoint(response http.ResponseWriter, request *http.Request, path []string) {
+	if len(path) == 0 {
+		if request.Method != http.MethodPost {
+			writeError(response, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var uploadRequest UploadRequest
+		if !readJSON(response, request, &uploadRequest) {
+			return
+		}
+
+		status, err := api.uploads.create(uploadRequest)
+		switch {
+		case errors.Is(err, ErrUploadExists):
+			writeError(response, http.StatusConflict, err.Error())
+		case err != nil:
+			writeError(response, http.StatusBadRequest, err.Error())
+		default:
+			writeJSON(response, http.StatusCreated, status)
+		}
+		return
+	}
+
+	upload, err := api.uploads.load(path[0])
+	if err != nil || len(path) > 1 {
+		writeError(response, http.StatusNotFound, "No such upload")
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet, http.MethodHead:
+		status, err := api.uploads.status(upload)
+		if err != nil {
+			writeError(response, http.StatusNotFound, "No such upload")
+			return
+		}
+
+		response.Header().Set("Upload-Offset", strconv.FormatInt(status.Offset, 10))
+		writeJSON(response, http.StatusOK, status)
+
+	case http.MethodPatch:
+		offset, err := strconv.ParseInt(request.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			writeError(response, http.StatusBadRequest, "Upload-Offset header is required")
+			return
+		}
+
+		if request.ContentLength > MaxUploadChunkSize {
+			writeError(response, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Chunks can be at most %d bytes", MaxUploadChunkSize))
+			return
+		}
+
+		chunk := http.MaxBytesReader(response, request.Body, MaxUploadChunkSize)
+		status, err := api.uploads.writeChunk(upload, offset, chunk)
+		response.Header().Set("Upload-Offset", strconv.FormatInt(status.Offset, 10))
+		switch {
+		case errors.Is(err, ErrChecksumMismatch):
+			writeError(response, http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, ErrUploadExists), errors.Is(err, ErrUploadOffset):
+			writeError(response, http.StatusConflict, err.Error())
+		case err != nil:
+			log.WithError(err).
+				WithField("upload", upload.ID).
+				Warn("Unable to write upload chunk")
+			writeError(response, http.StatusBadRequest, err.Error())
+		default:
+			writeJSON(response, http.StatusOK, status)
+		}
+
+	case http.MethodDelete:
+		unlock := api.uploads.lock(upload.ID)
+		api.uploads.remove(upload.ID)
+		unlock()
+		response.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(response, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
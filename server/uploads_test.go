@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"watch-party/database"
+)
+
+func newTestUploads(t *testing.T) *uploadManager {
+	root := t.TempDir()
+	config := database.LibraryConfig{
+		VideosPath: path.Join(root, "videos"),
+		ImagesPath: path.Join(root, "images"),
+	}
+
+	for _, dirPath := range []string{config.VideosPath, config.ImagesPath} {
+		if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	library := database.NewLibrary(nil, config, nil, nil)
+	return newUploadManager(path.Join(root, "uploads"), 1024, library)
+}
+
+func checksum(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestUploadChunks(t *testing.T) {
+	const content = "hello, theater"
+
+	type chunk struct {
+		offset int64
+		data   string
+	}
+
+	tests := []struct {
+		name     string
+		sha256   string
+		chunks   []chunk
+		err      error
+		offset   int64
+		complete bool
+		removed  bool
+	}{
+		{
+			name:     "single chunk",
+			sha256:   checksum(content),
+			chunks:   []chunk{{0, content}},
+			offset:   int64(len(content)),
+			complete: true,
+			removed:  true,
+		},
+		{
+			name:     "several chunks",
+			sha256:   checksum(content),
+			chunks:   []chunk{{0, "hello"}, {5, ", "}, {7, "theater"}},
+			offset:   int64(len(content)),
+			complete: true,
+			removed:  true,
+		},
+		{
+			name:   "partial upload",
+			sha256: checksum(content),
+			chunks: []chunk{{0, "hello"}},
+			offset: 5,
+		},
+		{
+			name:   "chunk past where it left off",
+			sha256: checksum(content),
+			chunks: []chunk{{0, "hello"}, {7, "theater"}},
+			err:    ErrUploadOffset,
+			offset: 5,
+		},
+		{
+			name:   "chunk resent",
+			sha256: checksum(content),
+			chunks: []chunk{{0, "hello"}, {0, "hello"}},
+			err:    ErrUploadOffset,
+			offset: 5,
+		},
+		{
+			name:    "checksum mismatch",
+			sha256:  checksum("something else"),
+			chunks:  []chunk{{0, content}},
+			err:     ErrChecksumMismatch,
+			offset:  int64(len(content)),
+			removed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uploads := newTestUploads(t)
+			status, err := uploads.create(UploadRequest{
+				FileName: "movie.mp4",
+				Kind:     UploadVideo,
+				Size:     int64(len(content)),
+				SHA256:   test.sha256,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, chunk := range test.chunks {
+				status, err = uploads.writeChunk(status.Upload, chunk.offset, strings.NewReader(chunk.data))
+				if err != nil {
+					break
+				}
+			}
+
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if status.Offset != test.offset {
+				t.Errorf("expected offset %d, got %d", test.offset, status.Offset)
+			}
+
+			if status.Complete != test.complete {
+				t.Errorf("expected complete to be %t", test.complete)
+			}
+
+			moved, readErr := os.ReadFile(uploads.destinationPath(status.Upload))
+			if test.complete && (readErr != nil || string(moved) != content) {
+				t.Errorf("expected upload in the library, got %q, %v", moved, readErr)
+			}
+			if !test.complete && readErr == nil {
+				t.Error("expected incomplete upload to stay out of the library")
+			}
+
+			if _, err := uploads.load(status.ID); test.removed == (err == nil) {
+				t.Errorf("expected upload removed to be %t, got %v", test.removed, err)
+			}
+		})
+	}
+}
+
+func TestUploadTooLong(t *testing.T) {
+	uploads := newTestUploads(t)
+	status, err := uploads.create(UploadRequest{
+		FileName: "movie.mp4",
+		Kind:     UploadVideo,
+		Size:     5,
+		SHA256:   checksum("hello"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = uploads.writeChunk(status.Upload, 0, strings.NewReader("hello, theater"))
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	if status.Offset != 5 {
+		t.Errorf("expected offset to be truncated to 5, got %d", status.Offset)
+	}
+}
+
+func TestUploadExists(t *testing.T) {
+	uploads := newTestUploads(t)
+	request := UploadRequest{
+		FileName: "movie.mp4",
+		Kind:     UploadVideo,
+		Size:     5,
+		SHA256:   checksum("hello"),
+	}
+
+	status, err := uploads.create(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(uploads.destinationPath(status.Upload), []byte("taken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uploads.writeChunk(status.Upload, 0, strings.NewReader("hello")); !errors.Is(err, ErrUploadExists) {
+		t.Errorf("expected %v, got %v", ErrUploadExists, err)
+	}
+
+	existing, _ := os.ReadFile(uploads.destinationPath(status.Upload))
+	if string(existing) != "taken" {
+		t.Errorf("expected existing file to be kept, got %q", existing)
+	}
+
+	if _, err := uploads.create(request); !errors.Is(err, ErrUploadExists) {
+		t.Errorf("expected %v, got %v", ErrUploadExists, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"watch-party/database"
+)
+
+const WatchHistoryLength = 10
+
+type WatchedItemData struct {
+	GalleryItemData
+	Progress  float64 `json:"progress"`
+	Finished  bool    `json:"finished"`
+	WatchedAt int64   `json:"watched_at"`
+}
+
+type ResumeOfferMessage struct {
+	VideoFile string  `json:"video"`
+	Progress  float64 `json:"progress"`
+	WatchedAt int64   `json:"watched_at"`
+}
+
+func watchedItems(watchProgress []database.WatchProgress) []WatchedItemData {
+	items := make([]WatchedItemData, len(watchProgress))
+	for i, progress := range watchProgress {
+		items[i] = WatchedItemData{
+			GalleryItemData: videoGalleryItem(progress.Video),
+			Progress:        progress.Progress,
+			Finished:        progress.Finished,
+			WatchedAt:       progress.WatchedAt.UnixMilli(),
+		}
+	}
+
+	return items
+}
+
+func (server *Server) addWatchHistory(message *VideoListMessage) error {
+	continueWatching, err := database.ContinueWatching(server.db, server.roomID, WatchHistoryLength)
+	if err != nil {
+		return err
+	}
+
+	recentlyWatched, err := database.RecentlyWatched(server.db, server.roomID, WatchHistoryLength)
+	if err != nil {
+		return err
+	}
+
+	message.ContinueWatching = watchedItems(continueWatching)
+	message.RecentlyWatched = watchedItems(recentlyWatched)
+	return nil
+}
+
+func (server *Server) findVideo(videoFile string) (*database.Video, error) {
+	var video database.Video
+	result := server.db.
+		Where(database.Video{VideoFilePath: videoFile}).
+		First(&video)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &video, nil
+}
+
+func (server *Server) saveWatchProgress() {
+	if server.videoState.VideoFile == "" {
+		return
+	}
+
+	video, err := server.findVideo(server.videoState.VideoFile)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+
+	if err == nil {
+		server.updateVideoState()
+		err = database.SaveWatchProgress(server.db, server.roomID, *video,
+			server.videoState.Progress, server.videoState.Finished)
+	}
+
+	if err != nil {
+		log.WithError(err).
+			WithField("video", server.videoState.VideoFile).
+			Error("Unable to save watch progress")
+	}
+}
+
+func (server *Server) resumePoint(videoFile string) *database.WatchProgress {
+	video, err := server.findVideo(videoFile)
+	if err != nil {
+		return nil
+	}
+
+	watchProgress, err := database.FindWatchProgress(server.db, server.roomID, video.ID)
+	if err != nil {
+		log.WithError(err).
+			WithField("video", videoFile).
+			Error("Unable to query watch progress")
+		return nil
+	}
+
+	if watchProgress == nil || watchProgress.Finished || watchProgress.Progress < database.MinWatchedProgress {
+		return nil
+	}
+
+	return watchProgress
+}
+
+func (server *Server) offerResume(token string, videoFile string, resumePoint *database.WatchProgress) {
+	client, exists := server.connectedClients[token]
+	if !exists || resumePoint == nil {
+		return
+	}
+
+	if server.videoState.Progress >= resumePoint.Progress-ResyncProgressTolerance {
+		return
+	}
+
+	_ = client.Send(MessageResumeOffer, ResumeOfferMessage{
+		VideoFile: videoFile,
+		Progress:  resumePoint.Progress,
+		WatchedAt: resumePoint.WatchedAt.UnixMilli(),
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"watch-party/database"
+)
+
+func newTestVideo(t *testing.T, server *Server, videoFile string) database.Video {
+	video := database.Video{Title: videoFile, VideoFilePath: videoFile, Duration: 1000}
+	if result := server.db.Create(&video); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+
+	return video
+}
+
+func TestResumePoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		roomID   string
+		progress float64
+		ended    bool
+		resume   bool
+	}{
+		{name: "never watched"},
+		{name: "partway", roomID: "test", progress: 300, resume: true},
+		{name: "finished", roomID: "test", progress: 980},
+		{name: "ended", roomID: "test", progress: 300, ended: true},
+		{name: "another room", roomID: "other", progress: 300},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{})
+			video := newTestVideo(t, server, "Movie.mkv")
+			if test.roomID != "" {
+				if err := database.SaveWatchProgress(server.db, test.roomID, video, test.progress, test.ended); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resumePoint := server.resumePoint("Movie.mkv")
+			if resume := resumePoint != nil; resume != test.resume {
+				t.Fatalf("expected resume to be %v, got %+v", test.resume, resumePoint)
+			}
+
+			if test.resume && resumePoint.Progress != test.progress {
+				t.Errorf("expected to resume at %v, got %v", test.progress, resumePoint.Progress)
+			}
+		})
+	}
+
+	server := newTestServer(t, RoomConfig{})
+	if server.resumePoint("Missing.mkv") != nil {
+		t.Error("expected no resume point for a missing video")
+	}
+}
+
+func TestOfferResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		playing float64
+		offered bool
+	}{
+		{name: "from the start", offered: true},
+		{name: "not far enough", playing: 100, offered: true},
+		{name: "already there", playing: 299},
+		{name: "past it", playing: 500},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, RoomConfig{})
+			video := newTestVideo(t, server, "Movie.mkv")
+			if err := database.SaveWatchProgress(server.db, "test", video, 300, false); err != nil {
+				t.Fatal(err)
+			}
+
+			viewer := joinTestViewer(t, server, "Viewer")
+			viewer.messages(t)
+
+			server.videoState.Progress = test.playing
+			server.offerResume(*viewer.client.Token, "Movie.mkv", server.resumePoint("Movie.mkv"))
+
+			var offer ResumeOfferMessage
+			offered := lastMessage(viewer.messages(t), MessageResumeOffer, &offer)
+			if offered != test.offered {
+				t.Fatalf("expected offered to be %v", test.offered)
+			}
+
+			if offered && (offer.VideoFile != "Movie.mkv" || offer.Progress != 300) {
+				t.Errorf("expected an offer to resume Movie.mkv at 300, got %+v", offer)
+			}
+		})
+	}
+}
+
+func TestAddWatchHistory(t *testing.T) {
+	server := newTestServer(t, RoomConfig{})
+	for i, progress := range []float64{300, 990} {
+		video := newTestVideo(t, server, fmt.Sprint("Episode ", i, ".mkv"))
+		if err := database.SaveWatchProgress(server.db, "test", video, progress, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var message VideoListMessage
+	if err := server.addWatchHistory(&message); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(message.ContinueWatching) != 1 || message.ContinueWatching[0].ItemFile != "Episode 0.mkv" {
+		t.Errorf("expected to continue Episode 0.mkv, got %+v", message.ContinueWatching)
+	}
+
+	if len(message.RecentlyWatched) != 2 {
+		t.Errorf("expected both episodes recently watched, got %+v", message.RecentlyWatched)
+	}
+
+	for _, item := range message.RecentlyWatched {
+		if item.Finished != (item.ItemFile == "Episode 1.mkv") || item.WatchedAt == 0 {
+			t.Errorf("unexpected watched item %+v", item)
+		}
+	}
+}